@@ -1,55 +1,86 @@
-package main
-
-import (
-	"DistributedCalc/internal/calculator"
-	"DistributedCalc/internal/grpc"
-	"DistributedCalc/internal/tasks"
-	"DistributedCalc/pkg/logger"
-	"context"
-	"net"
-	"os"
-	"os/signal"
-	"strconv"
-	"syscall"
-
-	"google.golang.org/grpc"
-)
-
-func main() {
-	logr := logger.NewLogger()
-	calc := calculator.NewCalculator()
-	listener, err := net.Listen("tcp", ":50051")
-	if err != nil {
-		logr.Error("Failed to listen: %v", err)
-		os.Exit(1)
-	}
-
-	server := grpc.NewServer()
-	grpc.RegisterCalcServiceServer(server, grpc.NewServer(logr))
-
-	go func() {
-		logr.Info("Starting agent_service on :50051")
-		if err := server.Serve(listener); err != nil {
-			logr.Error("Server failed: %v", err)
-			os.Exit(1)
-		}
-	}()
-
-	computingPower, _ := strconv.Atoi(os.Getenv("COMPUTING_POWER"))
-	if computingPower <= 0 {
-		computingPower = 4
-	}
-
-	taskClient := tasks.NewTaskClient("http://localhost:8080", logr)
-	for i := 0; i < computingPower; i++ {
-		go taskClient.RunWorker(context.Background(), calc)
-	}
-
-	quit := make(chan os.Signal, 1)
-	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
-	<-quit
-
-	logr.Info("Shutting down server...")
-	server.GracefulStop()
-	logr.Info("Server stopped")
-}
+package main
+
+import (
+	"DistributedCalc/internal/calculator"
+	calcgrpc "DistributedCalc/internal/grpc"
+	"DistributedCalc/internal/tasks"
+	"DistributedCalc/pkg/logger"
+	"context"
+	"net"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"google.golang.org/grpc"
+)
+
+func main() {
+	logr := logger.NewLogger()
+	listener, err := net.Listen("tcp", ":50051")
+	if err != nil {
+		logr.Error("Failed to listen: %v", err)
+		os.Exit(1)
+	}
+
+	server := grpc.NewServer()
+	calcgrpc.RegisterCalcServiceServer(server, calcgrpc.NewServer(logr))
+
+	go func() {
+		logr.Info("Starting agent_service on :50051")
+		if err := server.Serve(listener); err != nil {
+			logr.Error("Server failed: %v", err)
+			os.Exit(1)
+		}
+	}()
+
+	computingPower, _ := strconv.Atoi(os.Getenv("COMPUTING_POWER"))
+	if computingPower <= 0 {
+		computingPower = 4
+	}
+
+	agentID := os.Getenv("AGENT_ID")
+	if agentID == "" {
+		hostname, _ := os.Hostname()
+		agentID = hostname + "-" + strconv.Itoa(os.Getpid())
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	if os.Getenv("AGENT_MODE") == "http" {
+		// HTTP workers pull tasks from calc_service started with
+		// TASK_DISPATCH=http.
+		calcServiceURL := os.Getenv("CALC_SERVICE_URL")
+		if calcServiceURL == "" {
+			calcServiceURL = "http://localhost:8080"
+		}
+		logr.Info("Starting %d HTTP workers for %s", computingPower, calcServiceURL)
+		calc := calculator.NewCalculator()
+		taskClient := tasks.NewTaskClient(calcServiceURL, agentID, logr)
+		for i := 0; i < computingPower; i++ {
+			go taskClient.RunWorker(ctx, calc)
+		}
+		<-ctx.Done()
+	} else {
+		orchestratorAddr := os.Getenv("ORCHESTRATOR_ADDR")
+		if orchestratorAddr == "" {
+			orchestratorAddr = "localhost:50052"
+		}
+		agent := calcgrpc.NewAgent(agentID, computingPower, logr)
+		if capabilities := os.Getenv("AGENT_CAPABILITIES"); capabilities != "" {
+			agent.SetCapabilities(strings.Split(capabilities, ","))
+		}
+		if ms, _ := strconv.Atoi(os.Getenv("AGENT_HEARTBEAT_INTERVAL_MS")); ms > 0 {
+			agent.SetHeartbeatInterval(time.Duration(ms) * time.Millisecond)
+		}
+		if err := agent.Run(ctx, orchestratorAddr); err != nil {
+			logr.Error("Agent failed: %v", err)
+		}
+	}
+
+	logr.Info("Shutting down server...")
+	server.GracefulStop()
+	logr.Info("Server stopped")
+}
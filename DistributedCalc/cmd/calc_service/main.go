@@ -1,73 +1,143 @@
-package main
-
-import (
-	"DistributedCalc/internal/auth"
-	"DistributedCalc/internal/calculator"
-	"DistributedCalc/internal/grpc"
-	"DistributedCalc/internal/orchestrator"
-	"DistributedCalc/internal/storage"
-	"DistributedCalc/internal/tasks"
-	"DistributedCalc/pkg/logger"
-	"DistributedCalc/pkg/server"
-	"context"
-	"net/http"
-	"time"
-)
-
-func main() {
-	logr := logger.NewLogger()
-	dbConn, err := storage.NewSQLiteDB("calc.db", logr)
-	if err != nil {
-		logr.Error("Failed to init DB: %v", err)
-		return
-	}
-	defer dbConn.Close()
-
-	authService := auth.NewAuthService(dbConn, logr)
-	calcService := calculator.NewCalculatorService(dbConn, logr)
-	taskService := tasks.NewTaskService(dbConn, logr)
-	orch := orchestrator.NewOrchestrator(dbConn, logr)
-	client, err := grpc.NewClient(":50051", logr)
-	if err != nil {
-		logr.Error("Failed to init gRPC client: %v", err)
-		return
-	}
-	orch.SetGRPCClient(client)
-
-	go processExpressions(dbConn, orch, logr)
-
-	srv := server.NewServer(":8080", logr)
-	srv.AddRoute("/api/v1/register", http.HandlerFunc(authService.RegisterHandler), "POST")
-	srv.AddRoute("/api/v1/login", http.HandlerFunc(authService.LoginHandler), "POST")
-	srv.AddRoute("/api/v1/calculate", authService.JWTMiddleware(http.HandlerFunc(calcService.CalculateHandler), authService), "POST")
-	srv.AddRoute("/api/v1/expressions", authService.JWTMiddleware(http.HandlerFunc(calcService.ListExpressionsHandler), authService), "GET")
-	srv.AddRoute("/api/v1/expression", authService.JWTMiddleware(http.HandlerFunc(calcService.GetExpressionHandler), authService), "GET")
-	srv.AddRoute("/api/v1/task", http.HandlerFunc(taskService.GetTaskHandler), "GET")
-	srv.AddRoute("/api/v1/task/result", http.HandlerFunc(taskService.SubmitTaskResultHandler), "POST")
-
-	if err := srv.Run(); err != nil {
-		logr.Error("Server failed: %v", err)
-	}
-}
-
-func processExpressions(db *storage.SQLiteDB, orch *orchestrator.Orchestrator, logr *logger.Logger) {
-	for {
-		exprs, err := db.GetPendingExpressions()
-		if err != nil {
-			logr.Error("Failed to get pending expressions: %v", err)
-			continue
-		}
-		for _, expr := range exprs {
-			go func(expr storage.Expression) {
-				result, err := orch.ProcessExpression(context.Background(), expr.Expression, expr.ID)
-				if err != nil {
-					logr.Error("Failed to process expression %d: %v", expr.ID, err)
-					db.UpdateExpression(expr.ID, 0, "error")
-					return
-				}
-				db.UpdateExpression(expr.ID, result, "completed")
-			}(expr)
-		}
-		time.Sleep(1 * time.Second)
-	}
-}
+package main
+
+import (
+	"DistributedCalc/internal/agents"
+	"DistributedCalc/internal/auth"
+	"DistributedCalc/internal/calculator"
+	"DistributedCalc/internal/events"
+	calcgrpc "DistributedCalc/internal/grpc"
+	"DistributedCalc/internal/orchestrator"
+	"DistributedCalc/internal/storage"
+	"DistributedCalc/internal/tasks"
+	"DistributedCalc/internal/webhooks"
+	"DistributedCalc/pkg/logger"
+	"DistributedCalc/pkg/server"
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+)
+
+func main() {
+	logr := logger.NewLogger()
+	dbConn, err := storage.NewSQLiteDB("calc.db", logr)
+	if err != nil {
+		logr.Error("Failed to init DB: %v", err)
+		return
+	}
+	defer dbConn.Close()
+	bus := events.NewBus()
+	authService := auth.NewAuthService(dbConn, logr)
+	authService.SetAdmins(strings.Split(os.Getenv("ADMIN_LOGINS"), ","))
+	calcService := calculator.NewCalculatorService(dbConn, logr)
+
+	// Callbacks are only accepted when their receivers can verify them.
+	publishers := events.Publishers{bus}
+	if secret := os.Getenv("WEBHOOK_SECRET"); secret != "" {
+		notifier := webhooks.NewNotifier(dbConn, []byte(secret), logr)
+		if n, _ := strconv.Atoi(os.Getenv("WEBHOOK_MAX_ATTEMPTS")); n > 0 {
+			policy := webhooks.DefaultRetryPolicy()
+			policy.MaxAttempts = n
+			notifier.SetRetryPolicy(policy)
+		}
+		publishers = append(publishers, notifier)
+		calcService.SetCallbacksEnabled(true)
+	} else {
+		logr.Info("WEBHOOK_SECRET is not set, expressions with a callback_url are rejected")
+	}
+
+	if ms, _ := strconv.Atoi(os.Getenv("EXPRESSION_TIMEOUT_MS")); ms > 0 {
+		calcService.SetDefaultTimeout(time.Duration(ms) * time.Millisecond)
+	}
+	taskService := tasks.NewTaskService(dbConn, logr)
+	if ms, _ := strconv.Atoi(os.Getenv("TASK_LEASE_TIMEOUT_MS")); ms > 0 {
+		taskService.SetLeaseTimeout(time.Duration(ms) * time.Millisecond)
+	}
+	go taskService.ReleaseExpiredLeases(context.Background(), time.Second)
+	orch := orchestrator.NewOrchestrator(dbConn, logr)
+	orch.SetRetryPolicy(orchestrator.RetryPolicyFromEnv())
+	optimizePolicy := orchestrator.OptimizePolicyFromEnv()
+	orch.SetOptimizePolicy(optimizePolicy)
+	calcService.SetOptimizePolicy(optimizePolicy)
+	if ms, _ := strconv.Atoi(os.Getenv("TASK_TIMEOUT_MS")); ms > 0 {
+		orch.SetTaskTimeout(time.Duration(ms) * time.Millisecond)
+	}
+	// The orchestrator learns of tasks finished by HTTP agents from the
+	// database.
+	dbConn.SetPublisher(append(publishers, orch))
+
+	agentService := agents.NewAgentService(dbConn, logr)
+	heartbeatTimeout := 5 * time.Second
+	if ms, _ := strconv.Atoi(os.Getenv("AGENT_HEARTBEAT_TIMEOUT_MS")); ms > 0 {
+		heartbeatTimeout = time.Duration(ms) * time.Millisecond
+	}
+	go agentService.MonitorLiveness(context.Background(), heartbeatTimeout, heartbeatTimeout/2)
+
+	hub := calcgrpc.NewAgentHub(logr)
+	hub.SetObserver(agentService)
+	hub.SetHeartbeatTimeout(heartbeatTimeout)
+	// With TASK_DISPATCH=http tasks are left to HTTP agents instead.
+	if os.Getenv("TASK_DISPATCH") != "http" {
+		orch.SetGRPCClient(hub)
+	}
+	listener, err := net.Listen("tcp", ":50052")
+	if err != nil {
+		logr.Error("Failed to listen: %v", err)
+		return
+	}
+	grpcServer := grpc.NewServer()
+	calcgrpc.RegisterTaskChannelServer(grpcServer, hub)
+	go func() {
+		logr.Info("Starting task channel on :50052")
+		if err := grpcServer.Serve(listener); err != nil {
+			logr.Error("Task channel failed: %v", err)
+		}
+	}()
+	defer grpcServer.Stop()
+
+	owner := os.Getenv("ORCHESTRATOR_ID")
+	if owner == "" {
+		hostname, _ := os.Hostname()
+		owner = fmt.Sprintf("%s-%d", hostname, os.Getpid())
+	}
+	queue := orchestrator.NewQueue(dbConn, orch, owner, logr)
+	if ms, _ := strconv.Atoi(os.Getenv("EXPRESSION_LEASE_TIMEOUT_MS")); ms > 0 {
+		queue.SetLease(time.Duration(ms) * time.Millisecond)
+	}
+	if ms, _ := strconv.Atoi(os.Getenv("EXPRESSION_SWEEP_INTERVAL_MS")); ms > 0 {
+		queue.SetSweepInterval(time.Duration(ms) * time.Millisecond)
+	}
+	if n, _ := strconv.Atoi(os.Getenv("EXPRESSION_WORKERS")); n > 0 {
+		queue.SetWorkers(n)
+	}
+	calcService.SetQueue(queue)
+	calcService.SetEventBus(bus)
+	go queue.Run(context.Background())
+
+	srv := server.NewServer(":8080", logr)
+	srv.AddRoute("/api/v1/register", http.HandlerFunc(authService.RegisterHandler), "POST")
+	srv.AddRoute("/api/v1/login", http.HandlerFunc(authService.LoginHandler), "POST")
+	srv.AddRoute("/api/v1/calculate", authService.JWTMiddleware(http.HandlerFunc(calcService.CalculateHandler), authService), "POST")
+	srv.AddRoute("/api/v1/calculate/batch", authService.JWTMiddleware(http.HandlerFunc(calcService.CalculateBatchHandler), authService), "POST")
+	srv.AddRoute("/api/v1/batch/{id}", authService.JWTMiddleware(http.HandlerFunc(calcService.GetBatchHandler), authService), "GET")
+	srv.AddRoute("/api/v1/batch/{id}", authService.JWTMiddleware(http.HandlerFunc(calcService.CancelBatchHandler), authService), "DELETE")
+	srv.AddRoute("/api/v1/expressions", authService.JWTMiddleware(http.HandlerFunc(calcService.ListExpressionsHandler), authService), "GET")
+	srv.AddRoute("/api/v1/expressions/stream", authService.JWTMiddleware(http.HandlerFunc(calcService.StreamExpressionsHandler), authService), "GET")
+	srv.AddRoute("/api/v1/expression", authService.JWTMiddleware(http.HandlerFunc(calcService.GetExpressionHandler), authService), "GET")
+	srv.AddRoute("/api/v1/expression", authService.JWTMiddleware(http.HandlerFunc(calcService.CancelExpressionHandler), authService), "DELETE")
+	srv.AddRoute("/api/v1/expression/{id}/tasks", authService.JWTMiddleware(http.HandlerFunc(calcService.GetExpressionTasksHandler), authService), "GET")
+	srv.AddRoute("/api/v1/ws", authService.JWTMiddleware(srv.WebSocket(calcService.SessionHandler), authService), "GET")
+	srv.AddRoute("/api/v1/admin/agents", authService.JWTMiddleware(authService.AdminMiddleware(http.HandlerFunc(agentService.ListAgentsHandler)), authService), "GET")
+	srv.AddRoute("/api/v1/task", http.HandlerFunc(taskService.GetTaskHandler), "GET")
+	srv.AddRoute("/api/v1/task/result", http.HandlerFunc(taskService.SubmitTaskResultHandler), "POST")
+
+	if err := srv.Run(); err != nil {
+		logr.Error("Server failed: %v", err)
+	}
+}
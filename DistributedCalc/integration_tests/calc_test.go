@@ -1,67 +1,594 @@
-package integration_tests
-
-import (
-	"DistributedCalc/internal/auth"
-	"DistributedCalc/internal/calculator"
-	"DistributedCalc/internal/storage"
-	"DistributedCalc/pkg/logger"
-	"bytes"
-	"encoding/json"
-	"net/http"
-	"net/http/httptest"
-	"testing"
-)
-
-func TestCalculatorService_Integration(t *testing.T) {
-	logr := logger.NewLogger()
-	dbConn, err := storage.NewSQLiteDB(":memory:", logr)
-	if err != nil {
-		t.Fatalf("Failed to create DB: %v", err)
-	}
-	defer dbConn.Close()
-
-	authService := auth.NewAuthService(dbConn, logr)
-	calcService := calculator.NewCalculatorService(dbConn, logr)
-
-	// Register user
-	registerBody := `{"login": "testuser", "password": "password123"}`
-	req := httptest.NewRequest("POST", "/api/v1/register", bytes.NewBufferString(registerBody))
-	rr := httptest.NewRecorder()
-	authService.RegisterHandler(rr, req)
-	if rr.Code != http.StatusOK {
-		t.Fatalf("Failed to register user: status %d", rr.Code)
-	}
-
-	// Login user
-	req = httptest.NewRequest("POST", "/api/v1/login", bytes.NewBufferString(registerBody))
-	rr = httptest.NewRecorder()
-	authService.LoginHandler(rr, req)
-	if rr.Code != http.StatusOK {
-		t.Fatalf("Failed to login user: status %d", rr.Code)
-	}
-
-	var loginResp map[string]string
-	if err := json.NewDecoder(rr.Body).Decode(&loginResp); err != nil {
-		t.Fatalf("Failed to decode login response: %v", err)
-	}
-	token := loginResp["token"]
-
-	// Calculate expression
-	calcBody := `{"expression": "2+2"}`
-	req = httptest.NewRequest("POST", "/api/v1/calculate", bytes.NewBufferString(calcBody))
-	req.Header.Set("Authorization", "Bearer "+token)
-	rr = httptest.NewRecorder()
-	handler := auth.JWTMiddleware(http.HandlerFunc(calcService.CalculateHandler), authService)
-	handler.ServeHTTP(rr, req)
-	if rr.Code != http.StatusCreated {
-		t.Fatalf("Failed to calculate expression: status %d", rr.Code)
-	}
-
-	var calcResp calculator.CalcResponse
-	if err := json.NewDecoder(rr.Body).Decode(&calcResp); err != nil {
-		t.Fatalf("Failed to decode calc response: %v", err)
-	}
-	if calcResp.ID <= 0 {
-		t.Errorf("Expected positive expression ID, got %d", calcResp.ID)
-	}
-}
+package integration_tests
+
+import (
+	"DistributedCalc/internal/auth"
+	"DistributedCalc/internal/calculator"
+	"DistributedCalc/internal/events"
+	"DistributedCalc/internal/numeric"
+	"DistributedCalc/internal/orchestrator"
+	"DistributedCalc/internal/storage"
+	"DistributedCalc/pkg/errors"
+	"DistributedCalc/pkg/logger"
+	"DistributedCalc/pkg/server"
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+)
+
+func TestCalculatorService_Integration(t *testing.T) {
+	logr := logger.NewLogger()
+	dbConn, err := storage.NewSQLiteDB(":memory:", logr)
+	if err != nil {
+		t.Fatalf("Failed to create DB: %v", err)
+	}
+	defer dbConn.Close()
+
+	authService := auth.NewAuthService(dbConn, logr)
+	calcService := calculator.NewCalculatorService(dbConn, logr)
+
+	// Register user
+	registerBody := `{"login": "testuser", "password": "password123"}`
+	req := httptest.NewRequest("POST", "/api/v1/register", bytes.NewBufferString(registerBody))
+	rr := httptest.NewRecorder()
+	authService.RegisterHandler(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Failed to register user: status %d", rr.Code)
+	}
+
+	// Login user
+	req = httptest.NewRequest("POST", "/api/v1/login", bytes.NewBufferString(registerBody))
+	rr = httptest.NewRecorder()
+	authService.LoginHandler(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Failed to login user: status %d", rr.Code)
+	}
+
+	var loginResp map[string]string
+	if err := json.NewDecoder(rr.Body).Decode(&loginResp); err != nil {
+		t.Fatalf("Failed to decode login response: %v", err)
+	}
+	token := loginResp["token"]
+
+	// Calculate expression
+	calcBody := `{"expression": "rate*hours+pi", "variables": {"rate": 40, "hours": 7.5}}`
+	req = httptest.NewRequest("POST", "/api/v1/calculate", bytes.NewBufferString(calcBody))
+	req.Header.Set("Authorization", "Bearer "+token)
+	rr = httptest.NewRecorder()
+	handler := authService.JWTMiddleware(http.HandlerFunc(calcService.CalculateHandler), authService)
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Failed to calculate expression: status %d", rr.Code)
+	}
+
+	var calcResp calculator.CalcResponse
+	if err := json.NewDecoder(rr.Body).Decode(&calcResp); err != nil {
+		t.Fatalf("Failed to decode calc response: %v", err)
+	}
+	if calcResp.ID <= 0 {
+		t.Errorf("Expected positive expression ID, got %d", calcResp.ID)
+	}
+
+	exprs, err := dbConn.GetPendingExpressions()
+	if err != nil || len(exprs) != 1 {
+		t.Fatalf("Expected one pending expression, got %v (%v)", exprs, err)
+	}
+	if exprs[0].Variables["rate"] != 40 || exprs[0].Variables["hours"] != 7.5 {
+		t.Errorf("Expected variables to be stored, got %v", exprs[0].Variables)
+	}
+
+	// Variables must not shadow built-in names
+	calcBody = `{"expression": "pi*2", "variables": {"pi": 3}}`
+	req = httptest.NewRequest("POST", "/api/v1/calculate", bytes.NewBufferString(calcBody))
+	req.Header.Set("Authorization", "Bearer "+token)
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for reserved variable name, got %d", rr.Code)
+	}
+
+	// Expressions that do not parse are rejected with their position
+	for expr, message := range map[string]string{
+		"":       "empty expression, expected a number, variable, function call or '('",
+		"2+a":    "undefined variable 'a' at column 3",
+		"2+2)*2": "unbalanced ')' at column 4",
+		"2+":     "unexpected end of expression at column 3, expected a number, variable, function call or '('",
+		"max(1,": "unexpected end of expression at column 7, expected a number, variable, function call or '('",
+	} {
+		calcBody, _ := json.Marshal(calculator.CalcRequest{Expression: expr})
+		req = httptest.NewRequest("POST", "/api/v1/calculate", bytes.NewBuffer(calcBody))
+		req.Header.Set("Authorization", "Bearer "+token)
+		rr = httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		var appErr struct {
+			Message string
+			Details orchestrator.SyntaxError
+		}
+		json.NewDecoder(rr.Body).Decode(&appErr)
+		if rr.Code != http.StatusUnprocessableEntity || appErr.Message != message || appErr.Details.Column == 0 {
+			t.Errorf("Expected status 422 with %q for %q, got %d %+v", message, expr, rr.Code, appErr)
+		}
+	}
+
+	// A negative timeout is rejected
+	calcBody = `{"expression": "2+2", "timeout_ms": -1}`
+	req = httptest.NewRequest("POST", "/api/v1/calculate", bytes.NewBufferString(calcBody))
+	req.Header.Set("Authorization", "Bearer "+token)
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for a negative timeout, got %d", rr.Code)
+	}
+
+	// Callbacks are rejected unless they are enabled, and then must be
+	// absolute http or https URLs of public hosts
+	for _, tt := range []struct {
+		enabled  bool
+		callback string
+		err      error
+	}{
+		{false, "https://billing.example.com/results", calculator.NewCallbacksDisabledError()},
+		{true, "ftp://billing.example.com/results", calculator.NewInvalidCallbackURLError()},
+		{true, "http://localhost:8080/api/v1/task/result", calculator.NewPrivateCallbackURLError()},
+		{true, "http://127.0.0.1:8080/api/v1/task/result", calculator.NewPrivateCallbackURLError()},
+		{true, "http://10.0.0.7/results", calculator.NewPrivateCallbackURLError()},
+		{true, "http://[::1]/results", calculator.NewPrivateCallbackURLError()},
+	} {
+		calcService.SetCallbacksEnabled(tt.enabled)
+		calcBody, _ := json.Marshal(calculator.CalcRequest{Expression: "2+2", CallbackURL: tt.callback})
+		req = httptest.NewRequest("POST", "/api/v1/calculate", bytes.NewBuffer(calcBody))
+		req.Header.Set("Authorization", "Bearer "+token)
+		rr = httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		var appErr errors.AppError
+		json.NewDecoder(rr.Body).Decode(&appErr)
+		if rr.Code != http.StatusBadRequest || appErr.Message != tt.err.Error() {
+			t.Errorf("Expected status 400 with %q for %s, got %d %q", tt.err, tt.callback, rr.Code, appErr.Message)
+		}
+	}
+	calcService.SetCallbacksEnabled(false)
+
+	// The precision is stored with the expression, unknown modes are rejected
+	calcBody = `{"expression": "0.1+0.2", "precision": {"mode": "decimal", "scale": 4}}`
+	req = httptest.NewRequest("POST", "/api/v1/calculate", bytes.NewBufferString(calcBody))
+	req.Header.Set("Authorization", "Bearer "+token)
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	var decimalResp calculator.CalcResponse
+	json.NewDecoder(rr.Body).Decode(&decimalResp)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Failed to submit a decimal expression: status %d", rr.Code)
+	}
+	exprs, _ = dbConn.GetPendingExpressions()
+	want := numeric.Precision{Mode: numeric.ModeDecimal, Scale: 4, Rounding: numeric.DefaultRounding}
+	if len(exprs) != 2 || exprs[1].ID != decimalResp.ID || exprs[1].Precision != want {
+		t.Errorf("Expected the expression to be stored with %+v, got %+v", want, exprs)
+	}
+	calcBody = `{"expression": "2+2", "precision": {"mode": "binary"}}`
+	req = httptest.NewRequest("POST", "/api/v1/calculate", bytes.NewBufferString(calcBody))
+	req.Header.Set("Authorization", "Bearer "+token)
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for an unknown precision mode, got %d", rr.Code)
+	}
+	calcBody = `{"expression": "2.5*2", "precision": {"mode": "bigint"}}`
+	req = httptest.NewRequest("POST", "/api/v1/calculate", bytes.NewBufferString(calcBody))
+	req.Header.Set("Authorization", "Bearer "+token)
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for a fraction in bigint precision, got %d", rr.Code)
+	}
+	calcBody = `{"expression": "sqrt(-4)*(3+4i)"}`
+	req = httptest.NewRequest("POST", "/api/v1/calculate", bytes.NewBufferString(calcBody))
+	req.Header.Set("Authorization", "Bearer "+token)
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Failed to submit a complex expression: status %d", rr.Code)
+	}
+	exprs, _ = dbConn.GetPendingExpressions()
+	if len(exprs) != 3 || exprs[2].Precision.Mode != numeric.ModeComplex {
+		t.Errorf("Expected imaginary numbers to select complex precision, got %+v", exprs)
+	}
+
+	// Only the owner can cancel, and only while the expression is unfinished
+	cancelHandler := authService.JWTMiddleware(http.HandlerFunc(calcService.CancelExpressionHandler), authService)
+	otherBody := `{"login": "otheruser", "password": "password123"}`
+	rr = httptest.NewRecorder()
+	authService.RegisterHandler(rr, httptest.NewRequest("POST", "/api/v1/register", bytes.NewBufferString(otherBody)))
+	rr = httptest.NewRecorder()
+	authService.LoginHandler(rr, httptest.NewRequest("POST", "/api/v1/login", bytes.NewBufferString(otherBody)))
+	var otherLogin map[string]string
+	json.NewDecoder(rr.Body).Decode(&otherLogin)
+
+	cancel := func(token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("DELETE", fmt.Sprintf("/api/v1/expression?id=%d", calcResp.ID), nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rr := httptest.NewRecorder()
+		cancelHandler.ServeHTTP(rr, req)
+		return rr
+	}
+	if rr = cancel(otherLogin["token"]); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404 when cancelling another user's expression, got %d", rr.Code)
+	}
+	if rr = cancel(token); rr.Code != http.StatusOK {
+		t.Fatalf("Failed to cancel expression: status %d", rr.Code)
+	}
+	var cancelled storage.Expression
+	json.NewDecoder(rr.Body).Decode(&cancelled)
+	if cancelled.Status != storage.ExpressionCancelled {
+		t.Errorf("Expected the expression to be cancelled, got %+v", cancelled)
+	}
+	if rr = cancel(token); rr.Code != http.StatusConflict {
+		t.Errorf("Expected status 409 when cancelling twice, got %d", rr.Code)
+	}
+}
+
+func TestExpressionStream_Integration(t *testing.T) {
+	logr := logger.NewLogger()
+	dbConn, err := storage.NewSQLiteDB(":memory:", logr)
+	if err != nil {
+		t.Fatalf("Failed to create DB: %v", err)
+	}
+	defer dbConn.Close()
+	bus := events.NewBus()
+	dbConn.SetPublisher(bus)
+
+	authService := auth.NewAuthService(dbConn, logr)
+	calcService := calculator.NewCalculatorService(dbConn, logr)
+	calcService.SetEventBus(bus)
+
+	body := `{"login": "testuser", "password": "password123"}`
+	authService.RegisterHandler(httptest.NewRecorder(), httptest.NewRequest("POST", "/api/v1/register", bytes.NewBufferString(body)))
+	rr := httptest.NewRecorder()
+	authService.LoginHandler(rr, httptest.NewRequest("POST", "/api/v1/login", bytes.NewBufferString(body)))
+	var loginResp map[string]string
+	json.NewDecoder(rr.Body).Decode(&loginResp)
+	user, _ := dbConn.GetUser("testuser")
+	otherID, _ := dbConn.CreateUser("otheruser", "hashedpassword")
+
+	srv := httptest.NewServer(authService.JWTMiddleware(http.HandlerFunc(calcService.StreamExpressionsHandler), authService))
+	defer srv.Close()
+	req, _ := http.NewRequest("GET", srv.URL, nil)
+	req.Header.Set("Authorization", "Bearer "+loginResp["token"])
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Failed to open the stream: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("Expected an event stream, got status %d and %s", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+
+	// Events of other users must not show up in the stream.
+	dbConn.SaveExpression(otherID, "1+1", nil, time.Time{}, "")
+	exprID, _ := dbConn.SaveExpression(user.ID, "2+2", nil, time.Time{}, "")
+	dbConn.ClaimExpression(exprID, "orch-1", time.Minute)
+	taskID, _ := dbConn.SaveTask(exprID, 1, []string{"2", "2"}, numeric.Precision{}, "+", 100)
+	dbConn.LeaseTask(taskID, "orch-1", 0)
+	dbConn.CompleteTask(taskID, "orch-1", "4", "completed", "")
+	dbConn.CompleteExpression(exprID, "orch-1", "4")
+
+	expected := []events.Event{
+		{Type: events.TypeExpression, ExpressionID: exprID, Status: storage.ExpressionPending},
+		{Type: events.TypeExpression, ExpressionID: exprID, Status: storage.ExpressionProcessing},
+		{Type: events.TypeTask, ExpressionID: exprID, TaskID: taskID, Operator: "+", Status: "completed", Result: 4, Value: "4"},
+		{Type: events.TypeExpression, ExpressionID: exprID, Status: storage.ExpressionCompleted, Result: 4, Value: "4"},
+	}
+	reader := bufio.NewReader(resp.Body)
+	for _, want := range expected {
+		var name string
+		var got events.Event
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				t.Fatalf("Failed to read the stream: %v", err)
+			}
+			line = strings.TrimSpace(line)
+			if strings.HasPrefix(line, "event: ") {
+				name = strings.TrimPrefix(line, "event: ")
+			}
+			if strings.HasPrefix(line, "data: ") {
+				json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &got)
+				break
+			}
+		}
+		if name != want.Type || got != want {
+			t.Errorf("Expected %s event %+v, got %s event %+v", want.Type, want, name, got)
+		}
+	}
+}
+
+func TestCalculationSession_Integration(t *testing.T) {
+	logr := logger.NewLogger()
+	dbConn, err := storage.NewSQLiteDB(":memory:", logr)
+	if err != nil {
+		t.Fatalf("Failed to create DB: %v", err)
+	}
+	defer dbConn.Close()
+	bus := events.NewBus()
+	dbConn.SetPublisher(bus)
+
+	authService := auth.NewAuthService(dbConn, logr)
+	calcService := calculator.NewCalculatorService(dbConn, logr)
+	calcService.SetEventBus(bus)
+
+	body := `{"login": "testuser", "password": "password123"}`
+	authService.RegisterHandler(httptest.NewRecorder(), httptest.NewRequest("POST", "/api/v1/register", bytes.NewBufferString(body)))
+	rr := httptest.NewRecorder()
+	authService.LoginHandler(rr, httptest.NewRequest("POST", "/api/v1/login", bytes.NewBufferString(body)))
+	var loginResp map[string]string
+	json.NewDecoder(rr.Body).Decode(&loginResp)
+
+	ws := server.NewServer("", logr).WebSocket(calcService.SessionHandler)
+	srv := httptest.NewServer(authService.JWTMiddleware(ws, authService))
+	defer srv.Close()
+
+	// Browsers pass the token in the query.
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"?token="+loginResp["token"], nil)
+	if err != nil {
+		t.Fatalf("Failed to open the session: %v", err)
+	}
+	defer conn.Close()
+
+	conn.WriteJSON(map[string]any{"ref": "a", "expression": "2+2"})
+	conn.WriteJSON(map[string]any{"ref": "b", "expression": "1/0"})
+	conn.WriteJSON(map[string]any{"ref": "c", "expression": "pi*2", "variables": map[string]float64{"pi": 3}})
+
+	ids := make(map[string]int64)
+	for i := 0; i < 3; i++ {
+		var msg calculator.SessionMessage
+		if err := conn.ReadJSON(&msg); err != nil {
+			t.Fatalf("Failed to read message: %v", err)
+		}
+		switch {
+		case msg.Type == calculator.SessionAccepted && msg.ID > 0:
+			ids[msg.Ref] = msg.ID
+		case msg.Type == calculator.SessionError && msg.Ref == "c" && msg.Code == http.StatusBadRequest:
+		default:
+			t.Errorf("Unexpected message %+v", msg)
+		}
+	}
+	if len(ids) != 2 {
+		t.Fatalf("Expected two accepted expressions, got %v", ids)
+	}
+
+	// Finish them in the opposite order.
+	dbConn.ClaimExpressions("orch-1", time.Minute, 10)
+	dbConn.FailExpression(ids["b"], "orch-1", "division by zero")
+	dbConn.CompleteExpression(ids["a"], "orch-1", "4")
+
+	var msg calculator.SessionMessage
+	if err := conn.ReadJSON(&msg); err != nil || msg.Type != calculator.SessionResult || msg.Ref != "b" || msg.ID != ids["b"] || msg.Status != storage.ExpressionError || msg.Error != "division by zero" {
+		t.Errorf("Expected expression %d to fail, got %+v (%v)", ids["b"], msg, err)
+	}
+	if err := conn.ReadJSON(&msg); err != nil || msg.Type != calculator.SessionResult || msg.ID != ids["a"] || msg.Result == nil || *msg.Result != 4 {
+		t.Errorf("Expected expression %d to be 4, got %+v (%v)", ids["a"], msg, err)
+	}
+}
+
+func TestCalculationSession_TokenExpires(t *testing.T) {
+	logr := logger.NewLogger()
+	dbConn, err := storage.NewSQLiteDB(":memory:", logr)
+	if err != nil {
+		t.Fatalf("Failed to create DB: %v", err)
+	}
+	defer dbConn.Close()
+	calcService := calculator.NewCalculatorService(dbConn, logr)
+	calcService.SetEventBus(events.NewBus())
+
+	ws := server.NewServer("", logr).WebSocket(calcService.SessionHandler)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), auth.UserIDKey, int64(1))
+		ctx = context.WithValue(ctx, auth.TokenExpiresKey, time.Now().Add(200*time.Millisecond))
+		ws.ServeHTTP(w, r.WithContext(ctx))
+	}))
+	defer srv.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("Failed to open the session: %v", err)
+	}
+	defer conn.Close()
+
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, _, err = conn.ReadMessage()
+	if !websocket.IsCloseError(err, 4001) {
+		t.Errorf("Expected the session to close when the token expires, got %v", err)
+	}
+}
+
+func TestExpressionTasks_Integration(t *testing.T) {
+	logr := logger.NewLogger()
+	dbConn, err := storage.NewSQLiteDB(":memory:", logr)
+	if err != nil {
+		t.Fatalf("Failed to create DB: %v", err)
+	}
+	defer dbConn.Close()
+
+	authService := auth.NewAuthService(dbConn, logr)
+	calcService := calculator.NewCalculatorService(dbConn, logr)
+	body := `{"login": "testuser", "password": "password123"}`
+	authService.RegisterHandler(httptest.NewRecorder(), httptest.NewRequest("POST", "/api/v1/register", bytes.NewBufferString(body)))
+	rr := httptest.NewRecorder()
+	authService.LoginHandler(rr, httptest.NewRequest("POST", "/api/v1/login", bytes.NewBufferString(body)))
+	var loginResp map[string]string
+	json.NewDecoder(rr.Body).Decode(&loginResp)
+
+	// (1+2)*(3+x): 1+2 is done, 3+x has been claimed by an HTTP agent and the
+	// multiplication waits for both.
+	user, _ := dbConn.GetUser("testuser")
+	exprID, _ := dbConn.SaveExpression(user.ID, "(1+2)*(3+x)", map[string]float64{"x": 4}, time.Time{}, "")
+	dbConn.ClaimExpression(exprID, "orch-1", time.Minute)
+	doneID, _ := dbConn.SaveTask(exprID, 2, []string{"1", "2"}, numeric.Precision{}, "+", 100)
+	dbConn.LeaseTask(doneID, "orchestrator", 0)
+	dbConn.CompleteTask(doneID, "orchestrator", "3", "completed", "")
+	claimedID, _ := dbConn.SaveTask(exprID, 3, []string{"3", "4"}, numeric.Precision{}, "+", 100)
+	dbConn.ClaimTask("agent-7", time.Minute)
+
+	router := mux.NewRouter()
+	router.Handle("/api/v1/expression/{id}/tasks", authService.JWTMiddleware(http.HandlerFunc(calcService.GetExpressionTasksHandler), authService))
+	get := func(id int64) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", fmt.Sprintf("/api/v1/expression/%d/tasks", id), nil)
+		req.Header.Set("Authorization", "Bearer "+loginResp["token"])
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr = get(exprID)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Failed to get tasks: status %d", rr.Code)
+	}
+	var progress calculator.ExpressionProgress
+	if err := json.NewDecoder(rr.Body).Decode(&progress); err != nil {
+		t.Fatalf("Failed to decode progress: %v", err)
+	}
+	if progress.Status != storage.ExpressionProcessing || progress.TotalTasks != 3 || progress.CompletedTasks != 1 || progress.PercentComplete != 33.3 {
+		t.Errorf("Expected 1 of 3 tasks done, got %+v", progress)
+	}
+	if len(progress.Tasks) != 3 {
+		t.Fatalf("Expected 3 tasks, got %+v", progress.Tasks)
+	}
+
+	root, done, claimed := progress.Tasks[0], progress.Tasks[1], progress.Tasks[2]
+	if root.Operator != "*" || root.Status != calculator.TaskWaiting || root.TaskID != 0 || fmt.Sprint(root.Children) != "[2 3]" || root.Args[0] != nil || root.Args[1] != nil {
+		t.Errorf("Expected the multiplication to wait for nodes 2 and 3, got %+v", root)
+	}
+	if done.TaskID != doneID || done.Parent != 1 || done.Status != "completed" || done.Result == nil || *done.Result != 3 || done.StartedAt == nil || done.FinishedAt == nil {
+		t.Errorf("Expected task %d to be completed with 3, got %+v", doneID, done)
+	}
+	if claimed.TaskID != claimedID || claimed.Status != "in_progress" || claimed.Agent != "agent-7" || claimed.FinishedAt != nil || *claimed.Args[1] != 4 || *claimed.Operands[1] != "4" {
+		t.Errorf("Expected task %d to be computed by agent-7, got %+v", claimedID, claimed)
+	}
+
+	// Exact operands are shown as they are, without float64 args.
+	exactID, _ := dbConn.SaveNewExpression(user.ID, storage.NewExpression{Expression: "(2^70+1)*0.1", Precision: numeric.Precision{Mode: numeric.ModeRational}})
+	rr = get(exactID)
+	progress = calculator.ExpressionProgress{}
+	json.NewDecoder(rr.Body).Decode(&progress)
+	if len(progress.Tasks) != 3 {
+		t.Fatalf("Expected 3 tasks, got %+v", progress.Tasks)
+	}
+	if root, power := progress.Tasks[0], progress.Tasks[2]; *root.Operands[1] != "0.1" || root.Args != nil || *power.Operands[1] != "70" || power.Args != nil {
+		t.Errorf("Expected exact operands only, got %+v", progress.Tasks)
+	}
+
+	// The identical subtrees of (1+2)*(1+2) are one task used twice.
+	sharedExprID, _ := dbConn.SaveExpression(user.ID, "(1+2)*(1+2)", nil, time.Time{}, "")
+	rr = get(sharedExprID)
+	progress = calculator.ExpressionProgress{}
+	json.NewDecoder(rr.Body).Decode(&progress)
+	if progress.TotalTasks != 2 || len(progress.Tasks) != 2 {
+		t.Fatalf("Expected 2 tasks, got %+v", progress)
+	}
+	if root, sum := progress.Tasks[0], progress.Tasks[1]; fmt.Sprint(root.Children) != "[2]" || sum.Node != 2 || fmt.Sprint(sum.Shared) != "[3]" {
+		t.Errorf("Expected node 2 to stand for node 3 as well, got %+v", progress.Tasks)
+	}
+
+	if rr = get(sharedExprID + 1); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404 for an unknown expression, got %d", rr.Code)
+	}
+}
+
+func TestBatch_Integration(t *testing.T) {
+	logr := logger.NewLogger()
+	dbConn, err := storage.NewSQLiteDB(":memory:", logr)
+	if err != nil {
+		t.Fatalf("Failed to create DB: %v", err)
+	}
+	defer dbConn.Close()
+
+	authService := auth.NewAuthService(dbConn, logr)
+	calcService := calculator.NewCalculatorService(dbConn, logr)
+	body := `{"login": "testuser", "password": "password123"}`
+	authService.RegisterHandler(httptest.NewRecorder(), httptest.NewRequest("POST", "/api/v1/register", bytes.NewBufferString(body)))
+	rr := httptest.NewRecorder()
+	authService.LoginHandler(rr, httptest.NewRequest("POST", "/api/v1/login", bytes.NewBufferString(body)))
+	var loginResp map[string]string
+	json.NewDecoder(rr.Body).Decode(&loginResp)
+
+	router := mux.NewRouter()
+	router.Handle("/api/v1/calculate/batch", authService.JWTMiddleware(http.HandlerFunc(calcService.CalculateBatchHandler), authService)).Methods("POST")
+	router.Handle("/api/v1/batch/{id}", authService.JWTMiddleware(http.HandlerFunc(calcService.GetBatchHandler), authService)).Methods("GET")
+	router.Handle("/api/v1/batch/{id}", authService.JWTMiddleware(http.HandlerFunc(calcService.CancelBatchHandler), authService)).Methods("DELETE")
+	send := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+		req.Header.Set("Authorization", "Bearer "+loginResp["token"])
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr = send("POST", "/api/v1/calculate/batch", `[
+		{"expression": "1+1"},
+		{"expression": "pi*2", "variables": {"pi": 3}},
+		{"expression": "x*y", "variables": {"x": 2, "y": 3}},
+		{"expression": "2+2", "timeout_ms": -5}
+	]`)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Failed to submit batch: status %d", rr.Code)
+	}
+	var batchResp calculator.BatchResponse
+	json.NewDecoder(rr.Body).Decode(&batchResp)
+	if batchResp.BatchID <= 0 || len(batchResp.Items) != 4 {
+		t.Fatalf("Expected a batch with 4 items, got %+v", batchResp)
+	}
+	for i, item := range batchResp.Items {
+		valid := i == 0 || i == 2
+		if item.Index != i || (item.ID > 0) != valid || (item.Error == nil) != valid {
+			t.Errorf("Unexpected outcome of item %d: %+v", i, item)
+		}
+	}
+	if item := batchResp.Items[1]; item.Error == nil || item.Error.Code != http.StatusBadRequest {
+		t.Errorf("Expected a 400 for the reserved variable name, got %+v", item)
+	}
+
+	// Expressions of the batch can be finished one by one, the rest cancelled together.
+	dbConn.ClaimExpression(batchResp.Items[0].ID, "orch-1", time.Minute)
+	dbConn.CompleteExpression(batchResp.Items[0].ID, "orch-1", "2")
+	path := fmt.Sprintf("/api/v1/batch/%d", batchResp.BatchID)
+	var batch calculator.Batch
+	rr = send("DELETE", path, "")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Failed to cancel batch: status %d", rr.Code)
+	}
+	json.NewDecoder(rr.Body).Decode(&batch)
+	if len(batch.Expressions) != 2 || batch.Statuses[storage.ExpressionCompleted] != 1 || batch.Statuses[storage.ExpressionCancelled] != 1 {
+		t.Errorf("Expected one completed and one cancelled expression, got %+v", batch)
+	}
+	rr = send("GET", path, "")
+	batch = calculator.Batch{}
+	json.NewDecoder(rr.Body).Decode(&batch)
+	if rr.Code != http.StatusOK || batch.ID != batchResp.BatchID || batch.Expressions[1].Status != storage.ExpressionCancelled {
+		t.Errorf("Expected the cancelled batch, got %d %+v", rr.Code, batch)
+	}
+
+	if rr = send("GET", fmt.Sprintf("/api/v1/batch/%d", batchResp.BatchID+1), ""); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404 for an unknown batch, got %d", rr.Code)
+	}
+	if rr = send("POST", "/api/v1/calculate/batch", `[]`); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for an empty batch, got %d", rr.Code)
+	}
+	tooLarge := "[" + strings.Repeat(`{"expression": "1+1"},`, 10000) + `{"expression": "1+1"}]`
+	if rr = send("POST", "/api/v1/calculate/batch", tooLarge); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for a batch of more than 10000 expressions, got %d", rr.Code)
+	}
+	rr = send("POST", "/api/v1/calculate/batch", `[{"expression": "2+2", "timeout_ms": -1}]`)
+	batchResp = calculator.BatchResponse{}
+	json.NewDecoder(rr.Body).Decode(&batchResp)
+	if rr.Code != http.StatusBadRequest || batchResp.BatchID != 0 || batchResp.Items[0].Error == nil {
+		t.Errorf("Expected a batch without valid entries to be rejected, got %d %+v", rr.Code, batchResp)
+	}
+}
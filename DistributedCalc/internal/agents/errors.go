@@ -0,0 +1,10 @@
+package agents
+
+import (
+	"DistributedCalc/pkg/errors"
+	"net/http"
+)
+
+func NewAgentListError() *errors.AppError {
+	return &errors.AppError{Code: http.StatusInternalServerError, Message: "failed to list agents"}
+}
@@ -0,0 +1,121 @@
+package agents
+
+import (
+	calcgrpc "DistributedCalc/internal/grpc"
+	"DistributedCalc/internal/storage"
+	"DistributedCalc/pkg/errors"
+	"DistributedCalc/pkg/logger"
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+const (
+	StatusAlive        = "alive"
+	StatusDead         = "dead"
+	StatusDisconnected = "disconnected"
+)
+
+// AgentService keeps the agent registry in storage up to date with the
+// agents connected to the task channel and serves it to administrators.
+type AgentService struct {
+	db   *storage.SQLiteDB
+	logr *logger.Logger
+}
+
+func NewAgentService(db *storage.SQLiteDB, logr *logger.Logger) *AgentService {
+	return &AgentService{db: db, logr: logr}
+}
+
+type Agent struct {
+	ID             string    `json:"id"`
+	Capabilities   []string  `json:"capabilities"`
+	Operators      []string  `json:"operators"`
+	ComputingPower int       `json:"computing_power"`
+	Status         string    `json:"status"`
+	RegisteredAt   time.Time `json:"registered_at"`
+	LastSeen       time.Time `json:"last_seen"`
+	InFlight       int       `json:"in_flight"`
+	Completed      int64     `json:"completed"`
+}
+
+func (s *AgentService) AgentRegistered(info calcgrpc.AgentInfo) {
+	err := s.db.RegisterAgent(storage.Agent{
+		ID:             info.ID,
+		Capabilities:   info.Capabilities,
+		Operators:      info.Operators,
+		ComputingPower: info.ComputingPower,
+		RegisteredAt:   info.ConnectedAt,
+	})
+	if err != nil {
+		s.logr.Error("Failed to register agent %s: %v", info.ID, err)
+	}
+}
+
+func (s *AgentService) AgentHeartbeat(info calcgrpc.AgentInfo) {
+	if err := s.db.TouchAgent(info.ID, info.LastSeen, info.InFlight); err != nil {
+		s.logr.Error("Failed to record heartbeat of agent %s: %v", info.ID, err)
+	}
+}
+
+func (s *AgentService) TaskCompleted(agentID string) {
+	if err := s.db.IncrementAgentCompleted(agentID); err != nil {
+		s.logr.Error("Failed to count completed task of agent %s: %v", agentID, err)
+	}
+}
+
+func (s *AgentService) AgentLeft(agentID string, dead bool) {
+	status := StatusDisconnected
+	if dead {
+		status = StatusDead
+	}
+	if err := s.db.UpdateAgentStatus(agentID, status); err != nil {
+		s.logr.Error("Failed to mark agent %s as %s: %v", agentID, status, err)
+	}
+}
+
+// MonitorLiveness marks agents that have not been seen for timeout as dead,
+// checking every interval until ctx is cancelled. It also catches agents that
+// were alive when the service last stopped and never came back.
+func (s *AgentService) MonitorLiveness(ctx context.Context, timeout, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if n, err := s.db.MarkDeadAgents(time.Now().Add(-timeout)); err != nil {
+			s.logr.Error("Failed to mark dead agents: %v", err)
+		} else if n > 0 {
+			s.logr.Info("Marked %d agents as dead", n)
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (s *AgentService) ListAgentsHandler(w http.ResponseWriter, r *http.Request) {
+	stored, err := s.db.ListAgents()
+	if err != nil {
+		s.logr.Error("Failed to list agents: %v", err)
+		errors.HandleHTTPError(w, NewAgentListError())
+		return
+	}
+
+	agents := make([]Agent, len(stored))
+	for i, agent := range stored {
+		agents[i] = Agent{
+			ID:             agent.ID,
+			Capabilities:   agent.Capabilities,
+			Operators:      agent.Operators,
+			ComputingPower: agent.ComputingPower,
+			Status:         agent.Status,
+			RegisteredAt:   agent.RegisteredAt,
+			LastSeen:       agent.LastSeen,
+			InFlight:       agent.InFlight,
+			Completed:      agent.Completed,
+		}
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"agents": agents})
+}
@@ -0,0 +1,58 @@
+package agents
+
+import (
+	calcgrpc "DistributedCalc/internal/grpc"
+	"DistributedCalc/internal/storage"
+	"DistributedCalc/pkg/logger"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestAgentService_ListAgentsHandler(t *testing.T) {
+	logr := logger.NewLogger()
+	dbConn, err := storage.NewSQLiteDB(":memory:", logr)
+	if err != nil {
+		t.Fatalf("Failed to create DB: %v", err)
+	}
+	defer dbConn.Close()
+
+	service := NewAgentService(dbConn, logr)
+	now := time.Now()
+	service.AgentRegistered(calcgrpc.AgentInfo{ID: "agent-1", ComputingPower: 4, Capabilities: []string{"gpu"}, ConnectedAt: now})
+	service.AgentRegistered(calcgrpc.AgentInfo{ID: "agent-2", ComputingPower: 2, ConnectedAt: now.Add(-time.Minute)})
+	service.AgentHeartbeat(calcgrpc.AgentInfo{ID: "agent-1", InFlight: 3, LastSeen: now})
+	service.TaskCompleted("agent-1")
+	service.TaskCompleted("agent-1")
+
+	// A cancelled context makes MonitorLiveness run a single check.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	service.MonitorLiveness(ctx, 30*time.Second, time.Hour)
+
+	req := httptest.NewRequest("GET", "/api/v1/admin/agents", nil)
+	rr := httptest.NewRecorder()
+	service.ListAgentsHandler(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", rr.Code)
+	}
+
+	var resp struct {
+		Agents []Agent `json:"agents"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(resp.Agents) != 2 {
+		t.Fatalf("Expected 2 agents, got %d", len(resp.Agents))
+	}
+	if a := resp.Agents[0]; a.Status != StatusAlive || a.InFlight != 3 || a.Completed != 2 || a.Capabilities[0] != "gpu" {
+		t.Errorf("Unexpected agent-1: %+v", a)
+	}
+	if a := resp.Agents[1]; a.Status != StatusDead {
+		t.Errorf("Expected agent-2 to be dead, got %+v", a)
+	}
+}
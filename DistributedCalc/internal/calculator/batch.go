@@ -0,0 +1,175 @@
+package calculator
+
+import (
+	"DistributedCalc/internal/auth"
+	"DistributedCalc/internal/storage"
+	"DistributedCalc/pkg/errors"
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// maxBatchSize is the most expressions a single batch may hold.
+const maxBatchSize = 10000
+
+// BatchItem is the outcome of one entry of a batch, in the order of the
+// request: the ID of the saved expression or why the entry was rejected.
+type BatchItem struct {
+	Index int              `json:"index"`
+	ID    int64            `json:"id,omitempty"`
+	Error *errors.AppError `json:"error,omitempty"`
+}
+
+type BatchResponse struct {
+	BatchID int64       `json:"batch_id,omitempty"`
+	Items   []BatchItem `json:"items"`
+}
+
+// Batch is a batch of expressions with how many of them are in each state.
+type Batch struct {
+	ID          int64                `json:"id"`
+	Statuses    map[string]int       `json:"statuses"`
+	Expressions []storage.Expression `json:"expressions"`
+}
+
+// CalculateBatchHandler submits an array of expressions. Every entry is
+// validated first; the valid ones are saved together as one batch and
+// queued, the others are reported with their error. If no entry is valid,
+// nothing is saved and the response is 400.
+func (s *CalculatorService) CalculateBatchHandler(w http.ResponseWriter, r *http.Request) {
+	var reqs []CalcRequest
+	if err := json.NewDecoder(r.Body).Decode(&reqs); err != nil {
+		s.logr.Error("Failed to decode batch: %v", err)
+		errors.HandleHTTPError(w, errors.NewBadRequestError("invalid request body"))
+		return
+	}
+	if len(reqs) == 0 || len(reqs) > maxBatchSize {
+		errors.HandleHTTPError(w, NewInvalidBatchSizeError(maxBatchSize))
+		return
+	}
+
+	userID, ok := r.Context().Value(auth.UserIDKey).(int64)
+	if !ok {
+		s.logr.Error("User ID not found in context")
+		errors.HandleHTTPError(w, errors.NewInternalError("user not authenticated"))
+		return
+	}
+
+	resp := BatchResponse{Items: make([]BatchItem, len(reqs))}
+	var valid []storage.NewExpression
+	var indexes []int
+	for i, req := range reqs {
+		resp.Items[i].Index = i
+		expr, err := s.validate(req)
+		if err != nil {
+			appErr, ok := err.(*errors.AppError)
+			if !ok {
+				appErr = errors.NewBadRequestError(err.Error())
+			}
+			resp.Items[i].Error = appErr
+			continue
+		}
+		valid = append(valid, expr)
+		indexes = append(indexes, i)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if len(valid) == 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	batchID, ids, err := s.db.SaveBatch(userID, valid)
+	if err != nil {
+		s.logr.Error("Failed to save batch: %v", err)
+		errors.HandleHTTPError(w, errors.NewInternalError("failed to save batch"))
+		return
+	}
+	s.logr.Info("Batch %d with %d of %d expressions saved for user %d", batchID, len(ids), len(reqs), userID)
+	resp.BatchID = batchID
+	for i, id := range ids {
+		resp.Items[indexes[i]].ID = id
+		if s.queue != nil {
+			s.queue.Enqueue(id)
+		}
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// GetBatchHandler returns the expressions of a batch of the current user.
+func (s *CalculatorService) GetBatchHandler(w http.ResponseWriter, r *http.Request) {
+	userID, batchID, ok := s.batchRequest(w, r)
+	if !ok {
+		return
+	}
+	s.writeBatch(w, batchID, userID)
+}
+
+// CancelBatchHandler cancels the unfinished expressions of a batch of the
+// current user and returns the batch.
+func (s *CalculatorService) CancelBatchHandler(w http.ResponseWriter, r *http.Request) {
+	userID, batchID, ok := s.batchRequest(w, r)
+	if !ok {
+		return
+	}
+
+	cancelled, err := s.db.CancelBatch(batchID, userID)
+	if err != nil {
+		s.logr.Error("Failed to cancel batch %d: %v", batchID, err)
+		if err.Error() == "batch not found" {
+			errors.HandleHTTPError(w, errors.NewNotFoundError("batch not found"))
+		} else {
+			errors.HandleHTTPError(w, errors.NewInternalError("failed to cancel batch"))
+		}
+		return
+	}
+	if s.queue != nil {
+		for _, id := range cancelled {
+			s.queue.Cancel(id)
+		}
+	}
+
+	s.logr.Info("Batch %d cancelled by user %d, %d expressions stopped", batchID, userID, len(cancelled))
+	s.writeBatch(w, batchID, userID)
+}
+
+func (s *CalculatorService) batchRequest(w http.ResponseWriter, r *http.Request) (int64, int64, bool) {
+	userID, ok := r.Context().Value(auth.UserIDKey).(int64)
+	if !ok {
+		s.logr.Error("User ID not found in context")
+		errors.HandleHTTPError(w, errors.NewInternalError("user not authenticated"))
+		return 0, 0, false
+	}
+
+	batchID, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		s.logr.Error("Invalid batch ID: %v", err)
+		errors.HandleHTTPError(w, errors.NewBadRequestError("invalid batch ID"))
+		return 0, 0, false
+	}
+	return userID, batchID, true
+}
+
+func (s *CalculatorService) writeBatch(w http.ResponseWriter, batchID, userID int64) {
+	exprs, err := s.db.GetBatchExpressions(batchID, userID)
+	if err != nil {
+		s.logr.Error("Failed to get batch %d: %v", batchID, err)
+		if err.Error() == "batch not found" {
+			errors.HandleHTTPError(w, errors.NewNotFoundError("batch not found"))
+		} else {
+			errors.HandleHTTPError(w, errors.NewInternalError("failed to get batch"))
+		}
+		return
+	}
+
+	batch := Batch{ID: batchID, Statuses: make(map[string]int), Expressions: exprs}
+	for _, expr := range exprs {
+		batch.Statuses[expr.Status]++
+	}
+	json.NewEncoder(w).Encode(batch)
+}
@@ -1,133 +1,108 @@
-package calculator
-
-import (
-	"DistributedCalc/internal/orchestrator"
-	"os"
-	"strconv"
-	"strings"
-	"time"
-)
-
-type Calculator struct{}
-
-func NewCalculator() *Calculator {
-	return &Calculator{}
-}
-
-func (c *Calculator) Evaluate(expr string) (float64, error) {
-	expr = strings.ReplaceAll(expr, " ", "")
-	if !orchestrator.IsValidExpression(expr) {
-		return 0, NewInvalidExpressionError()
-	}
-
-	tokens := orchestrator.Tokenize(expr)
-	ops := []string{}
-	vals := []float64{}
-
-	for _, token := range tokens {
-		if orchestrator.IsNumber(token) {
-			num, err := strconv.ParseFloat(token, 64)
-			if err != nil {
-				return 0, NewInvalidTokenError(token)
-			}
-			vals = append(vals, num)
-		} else if token == "(" {
-			ops = append(ops, token)
-		} else if token == ")" {
-			for len(ops) > 0 && ops[len(ops)-1] != "(" {
-				if err := c.applyOp(&vals, &ops); err != nil {
-					return 0, err
-				}
-			}
-			if len(ops) == 0 {
-				return 0, NewInvalidExpressionError()
-			}
-			ops = ops[:len(ops)-1]
-		} else if orchestrator.IsOperator(token) {
-			for len(ops) > 0 && ops[len(ops)-1] != "(" && orchestrator.Precedence(ops[len(ops)-1]) >= orchestrator.Precedence(token) {
-				if err := c.applyOp(&vals, &ops); err != nil {
-					return 0, err
-				}
-			}
-			ops = append(ops, token)
-		} else {
-			return 0, NewInvalidTokenError(token)
-		}
-	}
-
-	for len(ops) > 0 {
-		if ops[len(ops)-1] == "(" {
-			return 0, NewInvalidExpressionError()
-		}
-		if err := c.applyOp(&vals, &ops); err != nil {
-			return 0, err
-		}
-	}
-
-	if len(vals) != 1 {
-		return 0, NewInvalidExpressionError()
-	}
-
-	return vals[0], nil
-}
-
-func (c *Calculator) ComputeTask(arg1, arg2 float64, op string) (float64, error) {
-	var operationTime time.Duration
-	switch op {
-	case "+":
-		operationTime = getOperationTime("TIME_ADDITION_MS")
-	case "-":
-		operationTime = getOperationTime("TIME_SUBTRACTION_MS")
-	case "*":
-		operationTime = getOperationTime("TIME_MULTIPLICATIONS_MS")
-	case "/":
-		operationTime = getOperationTime("TIME_DIVISIONS_MS")
-	default:
-		return 0, NewInvalidOperatorError(op)
-	}
-
-	time.Sleep(operationTime)
-
-	switch op {
-	case "+":
-		return arg1 + arg2, nil
-	case "-":
-		return arg1 - arg2, nil
-	case "*":
-		return arg1 * arg2, nil
-	case "/":
-		if arg2 == 0 {
-			return 0, NewDivisionByZeroError()
-		}
-		return arg1 / arg2, nil
-	default:
-		return 0, NewInvalidOperatorError(op)
-	}
-}
-
-func (c *Calculator) applyOp(vals *[]float64, ops *[]string) error {
-	if len(*vals) < 2 || len(*ops) == 0 {
-		return NewInvalidExpressionError()
-	}
-	op := (*ops)[len(*ops)-1]
-	*ops = (*ops)[:len(*ops)-1]
-	b := (*vals)[len(*vals)-1]
-	*vals = (*vals)[:len(*vals)-1]
-	a := (*vals)[len(*vals)-1]
-	*vals = (*vals)[:len(*vals)-1]
-
-	result, err := c.ComputeTask(a, b, op)
-	if err != nil {
-		return err
-	}
-	*vals = append(*vals, result)
-	return nil
-}
-
-func getOperationTime(envVar string) time.Duration {
-	ms, _ := strconv.Atoi(os.Getenv(envVar))
-	if ms <= 0 {
-		ms = 100 // Default 100ms
-	}
-	return time.Duration(ms) * time.Millisecond
-}
+package calculator
+
+import (
+	"DistributedCalc/internal/numeric"
+	"DistributedCalc/internal/operators"
+	"DistributedCalc/internal/orchestrator"
+	"context"
+)
+
+type Calculator struct{}
+
+func NewCalculator() *Calculator {
+	return &Calculator{}
+}
+
+func (c *Calculator) Evaluate(expr string) (float64, error) {
+	root, err := orchestrator.Parse(expr)
+	if err != nil {
+		return 0, err
+	}
+	return c.eval(context.Background(), root)
+}
+
+// EvaluateExact evaluates expr in precision p and returns the result in text
+// form, exact unless p is float64. Without a mode, expressions with
+// imaginary numbers are evaluated in complex precision.
+func (c *Calculator) EvaluateExact(expr string, p numeric.Precision) (string, error) {
+	root, err := orchestrator.Parse(expr)
+	if err != nil {
+		return "", err
+	}
+	p, err = orchestrator.ResolvePrecision(root, p)
+	if err != nil {
+		return "", err
+	}
+	return c.evalExact(context.Background(), root, p)
+}
+
+// EvaluateComplex evaluates expr in complex precision.
+func (c *Calculator) EvaluateComplex(expr string) (complex128, error) {
+	value, err := c.EvaluateExact(expr, numeric.Precision{Mode: numeric.ModeComplex})
+	if err != nil {
+		return 0, err
+	}
+	return complex(numeric.Float(value), numeric.Imag(value)), nil
+}
+
+// ComputeTask computes a binary or unary task; unary operators ignore arg2.
+func (c *Calculator) ComputeTask(ctx context.Context, arg1, arg2 float64, op string) (float64, error) {
+	if operator, ok := operators.Lookup(op); ok && operator.Unary {
+		return c.Compute(ctx, op, []float64{arg1})
+	}
+	return c.Compute(ctx, op, []float64{arg1, arg2})
+}
+
+// Compute applies an operator or a built-in function to args, unless ctx is
+// done before the operator's delay has passed. It computes with the float64
+// backend; see ComputeExact for the others.
+func (c *Calculator) Compute(ctx context.Context, op string, args []float64) (float64, error) {
+	operands := make([]string, len(args))
+	for i, arg := range args {
+		operands[i] = numeric.FormatFloat(arg)
+	}
+	value, err := c.ComputeExact(ctx, op, operands, numeric.Precision{})
+	if err != nil {
+		return 0, err
+	}
+	return numeric.Float(value), nil
+}
+
+// ComputeExact is Compute for operands in text form, computed with the
+// backend of precision p.
+func (c *Calculator) ComputeExact(ctx context.Context, op string, operands []string, p numeric.Precision) (string, error) {
+	return numeric.Compute(ctx, op, operands, p)
+}
+
+func (c *Calculator) eval(ctx context.Context, n *orchestrator.Node) (float64, error) {
+	if n.IsLeaf() {
+		if numeric.IsImaginary(n.Number()) {
+			return 0, numeric.NewImaginaryNumberError(n.Number())
+		}
+		return n.Value, nil
+	}
+	args := make([]float64, len(n.Args))
+	for i, arg := range n.Args {
+		value, err := c.eval(ctx, arg)
+		if err != nil {
+			return 0, err
+		}
+		args[i] = value
+	}
+	return c.Compute(ctx, n.Op, args)
+}
+
+func (c *Calculator) evalExact(ctx context.Context, n *orchestrator.Node, p numeric.Precision) (string, error) {
+	if n.IsLeaf() {
+		return numeric.Format(n.Number(), p)
+	}
+	operands := make([]string, len(n.Args))
+	for i, arg := range n.Args {
+		value, err := c.evalExact(ctx, arg, p)
+		if err != nil {
+			return "", err
+		}
+		operands[i] = value
+	}
+	return c.ComputeExact(ctx, n.Op, operands, p)
+}
@@ -1,127 +1,374 @@
-package calculator
-
-import (
-	"os"
-	"testing"
-)
-
-func TestCalculator_Evaluate(t *testing.T) {
-	calc := NewCalculator()
-	tests := []struct {
-		name     string
-		expr     string
-		expected float64
-		err      error
-	}{
-		{
-			name:     "Simple addition",
-			expr:     "2+2",
-			expected: 4,
-			err:      nil,
-		},
-		{
-			name:     "Complex expression",
-			expr:     "2+2*2",
-			expected: 6,
-			err:      nil,
-		},
-		{
-			name:     "Parentheses",
-			expr:     "2+2)*2",
-			expected: 0,
-			err:      NewInvalidExpressionError(),
-		},
-		{
-			name: "Division by zero",
-			expr: "2/0",
-			err:  NewDivisionByZeroError(),
-		},
-		{
-			name: "Invalid token",
-			expr: "2+a",
-			err:  NewInvalidTokenError("a"),
-		},
-	}
-
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			result, err := calc.Evaluate(tt.expr)
-			if tt.err != nil {
-				if err == nil || err.Error() != tt.err.Error() {
-					t.Errorf("Expected error %v, got %v", tt.err, err)
-				}
-				return
-			}
-			if err != nil {
-				t.Errorf("Unexpected error: %v", err)
-			}
-			if result != tt.expected {
-				t.Errorf("Expected %f, got %f", tt.expected, result)
-			}
-		})
-	}
-}
-
-func TestCalculator_ComputeTask(t *testing.T) {
-	calc := NewCalculator()
-	os.Setenv("TIME_ADDITION_MS", "10")
-	os.Setenv("TIME_MULTIPLICATIONS_MS", "20")
-
-	tests := []struct {
-		name     string
-		arg1     float64
-		arg2     float64
-		op       string
-		expected float64
-		err      error
-	}{
-		{
-			name:     "Addition",
-			arg1:     2,
-			arg2:     3,
-			op:       "+",
-			expected: 5,
-			err:      nil,
-		},
-		{
-			name:     "Multiplication",
-			arg1:     2,
-			arg2:     3,
-			op:       "*",
-			expected: 6,
-			err:      nil,
-		},
-		{
-			name: "Division by zero",
-			arg1: 2,
-			arg2: 0,
-			op:   "/",
-			err:  NewDivisionByZeroError(),
-		},
-		{
-			name: "Invalid operator",
-			arg1: 2,
-			arg2: 3,
-			op:   "^",
-			err:  NewInvalidOperatorError("^"),
-		},
-	}
-
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			result, err := calc.ComputeTask(tt.arg1, tt.arg2, tt.op)
-			if tt.err != nil {
-				if err == nil || err.Error() != tt.err.Error() {
-					t.Errorf("Expected error %v, got %v", tt.err, err)
-				}
-				return
-			}
-			if err != nil {
-				t.Errorf("Unexpected error: %v", err)
-			}
-			if result != tt.expected {
-				t.Errorf("Expected %f, got %f", tt.expected, result)
-			}
-		})
-	}
-}
+package calculator
+
+import (
+	"DistributedCalc/internal/numeric"
+	"DistributedCalc/internal/operators"
+	"DistributedCalc/internal/orchestrator"
+	"DistributedCalc/pkg/errors"
+	"context"
+	"net/http"
+	"os"
+	"testing"
+)
+
+func TestCalculator_Evaluate(t *testing.T) {
+	calc := NewCalculator()
+	tests := []struct {
+		name     string
+		expr     string
+		expected float64
+		err      error
+	}{
+		{
+			name:     "Simple addition",
+			expr:     "2+2",
+			expected: 4,
+			err:      nil,
+		},
+		{
+			name:     "Complex expression",
+			expr:     "2+2*2",
+			expected: 6,
+			err:      nil,
+		},
+		{
+			name:     "Nested parentheses",
+			expr:     "(1+2)*(3+4)",
+			expected: 21,
+			err:      nil,
+		},
+		{
+			name:     "Unary minus",
+			expr:     "-3+5",
+			expected: 2,
+			err:      nil,
+		},
+		{
+			name:     "Negative operand",
+			expr:     "2*-4",
+			expected: -8,
+			err:      nil,
+		},
+		{
+			name:     "Negated parentheses",
+			expr:     "-(1+2)",
+			expected: -3,
+			err:      nil,
+		},
+		{
+			name:     "Power binds tighter than unary minus",
+			expr:     "-2^2",
+			expected: -4,
+			err:      nil,
+		},
+		{
+			name:     "Right associative power",
+			expr:     "2^3^2",
+			expected: 512,
+			err:      nil,
+		},
+		{
+			name:     "Integer division and modulo",
+			expr:     "7//2*2+7%2",
+			expected: 7,
+			err:      nil,
+		},
+		{
+			name:     "Function calls",
+			expr:     "max(2, sqrt(16)) + abs(-3)",
+			expected: 7,
+			err:      nil,
+		},
+		{
+			name:     "Nested function arguments",
+			expr:     "round(log(8, 2) * min(1.5, 4), 1)",
+			expected: 4.5,
+			err:      nil,
+		},
+		{
+			name: "Unknown function",
+			expr: "foo(1)",
+			err:  orchestrator.NewUnknownFunctionError("foo", 1),
+		},
+		{
+			name: "Wrong number of arguments",
+			expr: "sqrt()",
+			err:  orchestrator.NewFunctionArityError("sqrt", 1, 1, 0, 1),
+		},
+		{
+			name: "Modulo by zero",
+			expr: "5%0",
+			err:  operators.NewModuloByZeroError(),
+		},
+		{
+			name:     "Parentheses",
+			expr:     "2+2)*2",
+			expected: 0,
+			err:      orchestrator.NewUnbalancedParenError(4),
+		},
+		{
+			name: "Division by zero",
+			expr: "2/0",
+			err:  NewDivisionByZeroError(),
+		},
+		{
+			name: "Overflow",
+			expr: "10^400",
+			err:  operators.NewOverflowError(),
+		},
+		{
+			name: "Difference of overflows",
+			expr: "10^400-10^400",
+			err:  operators.NewOverflowError(),
+		},
+		{
+			name:     "Built-in constants",
+			expr:     "round(pi*e, 2)",
+			expected: 8.54,
+			err:      nil,
+		},
+		{
+			name: "Undefined variable",
+			expr: "2+a",
+			err:  orchestrator.NewUndefinedVariableError("a", 3),
+		},
+		{
+			name: "Invalid token",
+			expr: "2+$",
+			err:  orchestrator.NewInvalidCharacterError("$", 3),
+		},
+		{
+			name: "Empty expression",
+			expr: "  ",
+			err:  orchestrator.NewEmptyExpressionError(),
+		},
+		{
+			name: "Missing closing parenthesis",
+			expr: "(2+2",
+			err:  orchestrator.NewUnclosedParenError(1, 5),
+		},
+		{
+			name: "Missing operand",
+			expr: "2*(3+)",
+			err:  orchestrator.NewUnexpectedTokenError(")", 6, "a number, variable, function call or '('"),
+		},
+		{
+			name: "Missing last operand",
+			expr: "2+",
+			err:  orchestrator.NewUnexpectedTokenError("", 3, "a number, variable, function call or '('"),
+		},
+		{
+			name: "Only an opening parenthesis",
+			expr: "(",
+			err:  orchestrator.NewUnexpectedTokenError("", 2, "a number, variable, function call or '('"),
+		},
+		{
+			name: "Only a sign",
+			expr: "-",
+			err:  orchestrator.NewUnexpectedTokenError("", 2, "a number, variable, function call or '('"),
+		},
+		{
+			name: "Missing last argument",
+			expr: "max(1,",
+			err:  orchestrator.NewUnexpectedTokenError("", 7, "a number, variable, function call or '('"),
+		},
+		{
+			name: "Missing operator",
+			expr: "2 3",
+			err:  orchestrator.NewUnexpectedTokenError("3", 3, "an operator"),
+		},
+		{
+			name: "Missing comma",
+			expr: "max(1 2)",
+			err:  orchestrator.NewUnexpectedTokenError("2", 7, "an operator, ',' or ')'"),
+		},
+		{
+			name: "Invalid number",
+			expr: "1.2.3+1",
+			err:  orchestrator.NewInvalidNumberError("1.2.3", 1),
+		},
+		{
+			name: "Columns count characters",
+			expr: "√4×2",
+			err:  orchestrator.NewInvalidCharacterError("√", 1),
+		},
+		{
+			name: "Columns after a multi-byte character",
+			expr: "2+a×2",
+			err:  orchestrator.NewUndefinedVariableError("a", 3),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := calc.Evaluate(tt.expr)
+			if tt.err != nil {
+				if err == nil || err.Error() != tt.err.Error() {
+					t.Errorf("Expected error %v, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("Unexpected error: %v", err)
+			}
+			if result != tt.expected {
+				t.Errorf("Expected %f, got %f", tt.expected, result)
+			}
+		})
+	}
+}
+
+func TestCalculator_ComputeTask(t *testing.T) {
+	calc := NewCalculator()
+	os.Setenv("TIME_ADDITION_MS", "10")
+	os.Setenv("TIME_MULTIPLICATIONS_MS", "20")
+
+	tests := []struct {
+		name     string
+		arg1     float64
+		arg2     float64
+		op       string
+		expected float64
+		err      error
+	}{
+		{
+			name:     "Addition",
+			arg1:     2,
+			arg2:     3,
+			op:       "+",
+			expected: 5,
+			err:      nil,
+		},
+		{
+			name:     "Multiplication",
+			arg1:     2,
+			arg2:     3,
+			op:       "*",
+			expected: 6,
+			err:      nil,
+		},
+		{
+			name: "Division by zero",
+			arg1: 2,
+			arg2: 0,
+			op:   "/",
+			err:  NewDivisionByZeroError(),
+		},
+		{
+			name:     "Negation",
+			arg1:     2,
+			op:       "neg",
+			expected: -2,
+			err:      nil,
+		},
+		{
+			name:     "Power",
+			arg1:     2,
+			arg2:     3,
+			op:       "^",
+			expected: 8,
+			err:      nil,
+		},
+		{
+			name: "Invalid operator",
+			arg1: 2,
+			arg2: 3,
+			op:   "&",
+			err:  NewInvalidOperatorError("&"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := calc.ComputeTask(context.Background(), tt.arg1, tt.arg2, tt.op)
+			if tt.err != nil {
+				if err == nil || err.Error() != tt.err.Error() {
+					t.Errorf("Expected error %v, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("Unexpected error: %v", err)
+			}
+			if result != tt.expected {
+				t.Errorf("Expected %f, got %f", tt.expected, result)
+			}
+		})
+	}
+}
+
+func TestCalculator_EvaluateExact(t *testing.T) {
+	t.Setenv("TIME_ADDITION_MS", "1")
+	t.Setenv("TIME_MULTIPLICATIONS_MS", "1")
+	t.Setenv("TIME_DIVISIONS_MS", "1")
+	calc := NewCalculator()
+	money := numeric.Precision{Mode: numeric.ModeDecimal, Scale: 2, Rounding: numeric.RoundHalfEven}
+	tests := []struct {
+		name      string
+		expr      string
+		precision numeric.Precision
+		expected  string
+	}{
+		{name: "Float64", expr: "0.1+0.2", expected: "0.30000000000000004"},
+		{name: "Decimal", expr: "0.1+0.2", precision: money, expected: "0.3"},
+		{name: "Banker's rounding", expr: "0.125*1+0.01*0", precision: money, expected: "0.12"},
+		{name: "Division", expr: "100/3", precision: money, expected: "33.33"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := calc.EvaluateExact(tt.expr, tt.precision)
+			if err != nil || result != tt.expected {
+				t.Errorf("Expected %s, got %s (%v)", tt.expected, result, err)
+			}
+		})
+	}
+}
+
+func TestCalculator_EvaluateComplex(t *testing.T) {
+	t.Setenv("TIME_ADDITION_MS", "1")
+	t.Setenv("TIME_MULTIPLICATIONS_MS", "1")
+	t.Setenv("TIME_SQRT_MS", "1")
+	t.Setenv("TIME_ABS_MS", "1")
+	t.Setenv("TIME_CONJ_MS", "1")
+	calc := NewCalculator()
+	tests := []struct {
+		expr     string
+		expected complex128
+	}{
+		{expr: "3+4i", expected: 3 + 4i},
+		{expr: "i*i", expected: -1},
+		{expr: "sqrt(-9)", expected: 3i},
+		{expr: "abs(3+4i)", expected: 5},
+		{expr: "(2+i)*conj(2+i)", expected: 5},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			result, err := calc.EvaluateComplex(tt.expr)
+			if err != nil || result != tt.expected {
+				t.Errorf("Expected %v, got %v (%v)", tt.expected, result, err)
+			}
+		})
+	}
+
+	// Evaluate computes in float64, which has no imaginary numbers.
+	if _, err := calc.Evaluate("3+4i"); err == nil || err.Error() != numeric.NewImaginaryNumberError("4i").Error() {
+		t.Errorf("Expected an imaginary number error, got %v", err)
+	}
+}
+
+func TestCalculator_EvaluateDiagnostics(t *testing.T) {
+	_, err := NewCalculator().Evaluate("2+2)*2")
+	appErr, ok := err.(*errors.AppError)
+	if !ok {
+		t.Fatalf("Expected an AppError, got %v", err)
+	}
+	if appErr.Code != http.StatusUnprocessableEntity || appErr.Message != "unbalanced ')' at column 4" {
+		t.Errorf("Unexpected error %d %q", appErr.Code, appErr.Message)
+	}
+	details, ok := appErr.Details.(*orchestrator.SyntaxError)
+	if !ok || details.Column != 4 || details.Token != ")" {
+		t.Errorf("Expected the position of the parenthesis, got %+v", appErr.Details)
+	}
+}
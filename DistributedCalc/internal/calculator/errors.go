@@ -1,23 +1,43 @@
-package calculator
-
-import (
-	"DistributedCalc/pkg/errors"
-	"fmt"
-	"net/http"
-)
-
-func NewInvalidExpressionError() *errors.AppError {
-	return &errors.AppError{Code: http.StatusUnprocessableEntity, Message: "invalid expression"}
-}
-
-func NewDivisionByZeroError() *errors.AppError {
-	return &errors.AppError{Code: http.StatusUnprocessableEntity, Message: "division by zero"}
-}
-
-func NewInvalidTokenError(token string) *errors.AppError {
-	return &errors.AppError{Code: http.StatusUnprocessableEntity, Message: fmt.Sprintf("invalid token: %s", token)}
-}
-
-func NewInvalidOperatorError(op string) *errors.AppError {
-	return &errors.AppError{Code: http.StatusUnprocessableEntity, Message: fmt.Sprintf("invalid operator: %s", op)}
-}
+package calculator
+
+import (
+	"DistributedCalc/pkg/errors"
+	"fmt"
+	"net/http"
+)
+
+func NewInvalidExpressionError() *errors.AppError {
+	return &errors.AppError{Code: http.StatusUnprocessableEntity, Message: "invalid expression"}
+}
+
+func NewDivisionByZeroError() *errors.AppError {
+	return &errors.AppError{Code: http.StatusUnprocessableEntity, Message: "division by zero"}
+}
+
+func NewInvalidTokenError(token string) *errors.AppError {
+	return &errors.AppError{Code: http.StatusUnprocessableEntity, Message: fmt.Sprintf("invalid token: %s", token)}
+}
+
+func NewInvalidOperatorError(op string) *errors.AppError {
+	return &errors.AppError{Code: http.StatusUnprocessableEntity, Message: fmt.Sprintf("invalid operator: %s", op)}
+}
+
+func NewInvalidTimeoutError() *errors.AppError {
+	return &errors.AppError{Code: http.StatusBadRequest, Message: "timeout_ms must not be negative"}
+}
+
+func NewInvalidCallbackURLError() *errors.AppError {
+	return &errors.AppError{Code: http.StatusBadRequest, Message: "callback_url must be an absolute http or https URL"}
+}
+
+func NewPrivateCallbackURLError() *errors.AppError {
+	return &errors.AppError{Code: http.StatusBadRequest, Message: "callback_url must not point to a loopback or private address"}
+}
+
+func NewCallbacksDisabledError() *errors.AppError {
+	return &errors.AppError{Code: http.StatusBadRequest, Message: "callbacks are not enabled on this server"}
+}
+
+func NewInvalidBatchSizeError(max int) *errors.AppError {
+	return &errors.AppError{Code: http.StatusBadRequest, Message: fmt.Sprintf("a batch must hold between 1 and %d expressions", max)}
+}
@@ -0,0 +1,197 @@
+package calculator
+
+import (
+	"DistributedCalc/internal/auth"
+	"DistributedCalc/internal/numeric"
+	"DistributedCalc/internal/orchestrator"
+	"DistributedCalc/internal/storage"
+	"DistributedCalc/pkg/errors"
+	"encoding/json"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// TaskWaiting is the status of a task that has not been created yet because
+// its operands are still being computed, and TaskSkipped of one that never
+// will be because the expression has finished without it.
+const (
+	TaskWaiting = "waiting"
+	TaskSkipped = "skipped"
+)
+
+// TaskProgress is one task of an expression's tree. Nodes are numbered in
+// pre-order starting at 1; Parent is 0 for the root. Operands are in text
+// form, exact like Value, and null while they are still being computed by
+// child tasks; Args repeats them as numbers for expressions computed in
+// float64. A task computed once for identical subtrees lists the nodes of
+// the others in Shared and every node that uses it in Parents.
+type TaskProgress struct {
+	Node       int        `json:"node"`
+	Parent     int        `json:"parent,omitempty"`
+	Parents    []int      `json:"parents,omitempty"`
+	Children   []int      `json:"children,omitempty"`
+	Shared     []int      `json:"shared,omitempty"`
+	TaskID     int64      `json:"task_id,omitempty"`
+	Operator   string     `json:"operator"`
+	Operands   []*string  `json:"operands"`
+	Args       []*float64 `json:"args,omitempty"`
+	Status     string     `json:"status"`
+	Result     *float64   `json:"result,omitempty"`
+	Imag       float64    `json:"imag,omitempty"`
+	Value      string     `json:"value,omitempty"`
+	Agent      string     `json:"agent,omitempty"`
+	CreatedAt  *time.Time `json:"created_at,omitempty"`
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+type ExpressionProgress struct {
+	ID              int64          `json:"id"`
+	Status          string         `json:"status"`
+	PercentComplete float64        `json:"percent_complete"`
+	TotalTasks      int            `json:"total_tasks"`
+	CompletedTasks  int            `json:"completed_tasks"`
+	Tasks           []TaskProgress `json:"tasks"`
+}
+
+// GetExpressionTasksHandler returns the task tree of an expression of the
+// current user with the state of every task and how much of it is done.
+func (s *CalculatorService) GetExpressionTasksHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(auth.UserIDKey).(int64)
+	if !ok {
+		s.logr.Error("User ID not found in context")
+		errors.HandleHTTPError(w, errors.NewInternalError("user not authenticated"))
+		return
+	}
+
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		s.logr.Error("Invalid expression ID: %v", err)
+		errors.HandleHTTPError(w, errors.NewBadRequestError("invalid expression ID"))
+		return
+	}
+
+	expr, err := s.db.GetExpression(id, userID)
+	if err != nil {
+		s.logr.Error("Failed to get expression %d: %v", id, err)
+		if err.Error() == "expression not found" {
+			errors.HandleHTTPError(w, errors.NewNotFoundError("expression not found"))
+		} else {
+			errors.HandleHTTPError(w, errors.NewInternalError("failed to get expression"))
+		}
+		return
+	}
+
+	tasks, err := s.db.GetExpressionTasks(id)
+	if err != nil {
+		s.logr.Error("Failed to get tasks of expression %d: %v", id, err)
+		errors.HandleHTTPError(w, errors.NewInternalError("failed to get tasks"))
+		return
+	}
+
+	// An expression that fails to parse never got any tasks, so the plan is
+	// only missing for it.
+	plan, precision, err := orchestrator.PlanTasks(expr.Expression, expr.Variables, expr.Precision, s.optimize)
+	if err != nil {
+		plan, precision = nil, expr.Precision
+	}
+	json.NewEncoder(w).Encode(buildProgress(expr, plan, tasks, precision.IsFloat64()))
+}
+
+// buildProgress merges the persisted tasks into the planned tree. Tasks that
+// do not belong to the plan, such as ones saved before tasks were numbered,
+// are listed after it. Args are only filled in if float is set.
+func buildProgress(expr storage.Expression, plan []orchestrator.PlannedTask, tasks []storage.Task, float bool) ExpressionProgress {
+	progress := ExpressionProgress{ID: expr.ID, Status: expr.Status, Tasks: []TaskProgress{}}
+	planned := make(map[int]bool, len(plan))
+	for _, task := range plan {
+		planned[task.Node] = true
+	}
+	saved := make(map[int]storage.Task)
+	var extra []storage.Task
+	for _, task := range tasks {
+		if planned[task.Node] {
+			saved[task.Node] = task
+		} else {
+			extra = append(extra, task)
+		}
+	}
+
+	pendingStatus := TaskWaiting
+	if isFinal(expr.Status) {
+		pendingStatus = TaskSkipped
+	}
+	for _, planned := range plan {
+		view := TaskProgress{Node: planned.Node, Operator: planned.Operator, Operands: planned.Operands, Status: pendingStatus}
+		if float {
+			view.Args = floatArgs(planned.Operands)
+		}
+		if task, ok := saved[planned.Node]; ok {
+			view = taskProgress(task, float)
+		}
+		view.Node, view.Parent, view.Children, view.Shared = planned.Node, planned.Parent, planned.Children, planned.Shared
+		if len(planned.Parents) > 1 {
+			view.Parents = planned.Parents
+		}
+		progress.Tasks = append(progress.Tasks, view)
+	}
+	for _, task := range extra {
+		progress.Tasks = append(progress.Tasks, taskProgress(task, float))
+	}
+
+	for _, view := range progress.Tasks {
+		if view.Status == "completed" {
+			progress.CompletedTasks++
+		}
+	}
+	progress.TotalTasks = len(progress.Tasks)
+	switch {
+	case expr.Status == storage.ExpressionCompleted:
+		progress.PercentComplete = 100
+	case progress.TotalTasks > 0:
+		percent := float64(progress.CompletedTasks) / float64(progress.TotalTasks) * 100
+		progress.PercentComplete = math.Round(percent*10) / 10
+	}
+	return progress
+}
+
+func taskProgress(task storage.Task, float bool) TaskProgress {
+	view := TaskProgress{Node: task.Node, TaskID: task.ID, Operator: task.Operator, Status: task.Status, Agent: task.Agent}
+	view.Operands = make([]*string, len(task.Operands))
+	for i := range task.Operands {
+		view.Operands[i] = &task.Operands[i]
+	}
+	if float {
+		view.Args = floatArgs(view.Operands)
+	}
+	if task.Status == "completed" {
+		view.Result, view.Imag, view.Value = &task.Result, task.Imag, task.Value
+	}
+	view.CreatedAt = optionalTime(task.CreatedAt)
+	view.StartedAt = optionalTime(task.StartedAt)
+	view.FinishedAt = optionalTime(task.FinishedAt)
+	return view
+}
+
+// floatArgs converts operands to float64, keeping the unknown ones null.
+func floatArgs(operands []*string) []*float64 {
+	args := make([]*float64, len(operands))
+	for i, operand := range operands {
+		if operand != nil {
+			value := numeric.Float(*operand)
+			args[i] = &value
+		}
+	}
+	return args
+}
+
+func optionalTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
@@ -1,103 +1,321 @@
-package calculator
-
-import (
-	"DistributedCalc/internal/auth"
-	"DistributedCalc/internal/storage"
-	"DistributedCalc/pkg/errors"
-	"DistributedCalc/pkg/logger"
-	"encoding/json"
-	"net/http"
-	"strconv"
-)
-
-type CalculatorService struct {
-	db   *storage.SQLiteDB
-	logr *logger.Logger
-}
-
-func NewCalculatorService(db *storage.SQLiteDB, logr *logger.Logger) *CalculatorService {
-	return &CalculatorService{db: db, logr: logr}
-}
-
-type CalcRequest struct {
-	Expression string `json:"expression"`
-}
-
-type CalcResponse struct {
-	ID int64 `json:"id"`
-}
-
-func (s *CalculatorService) CalculateHandler(w http.ResponseWriter, r *http.Request) {
-	var req CalcRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		s.logr.Error("Failed to decode request: %v", err)
-		errors.HandleHTTPError(w, errors.NewBadRequestError("invalid request body"))
-		return
-	}
-
-	userID, ok := r.Context().Value(auth.UserIDKey).(int64)
-	if !ok {
-		s.logr.Error("User ID not found in context")
-		errors.HandleHTTPError(w, errors.NewInternalError("user not authenticated"))
-		return
-	}
-
-	id, err := s.db.SaveExpression(userID, req.Expression)
-	if err != nil {
-		s.logr.Error("Failed to save expression: %v", err)
-		errors.HandleHTTPError(w, errors.NewInternalError("failed to save expression"))
-		return
-	}
-
-	s.logr.Info("Expression saved with ID %d for user %d", id, userID)
-	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(CalcResponse{ID: id})
-}
-
-func (s *CalculatorService) ListExpressionsHandler(w http.ResponseWriter, r *http.Request) {
-	userID, ok := r.Context().Value(auth.UserIDKey).(int64)
-	if !ok {
-		s.logr.Error("User ID not found in context")
-		errors.HandleHTTPError(w, errors.NewInternalError("user not authenticated"))
-		return
-	}
-
-	exprs, err := s.db.GetUserExpressions(userID)
-	if err != nil {
-		s.logr.Error("Failed to get expressions: %v", err)
-		errors.HandleHTTPError(w, errors.NewInternalError("failed to get expressions"))
-		return
-	}
-
-	json.NewEncoder(w).Encode(exprs)
-}
-
-func (s *CalculatorService) GetExpressionHandler(w http.ResponseWriter, r *http.Request) {
-	userID, ok := r.Context().Value(auth.UserIDKey).(int64)
-	if !ok {
-		s.logr.Error("User ID not found in context")
-		errors.HandleHTTPError(w, errors.NewInternalError("user not authenticated"))
-		return
-	}
-
-	idStr := r.URL.Query().Get("id")
-	id, err := strconv.ParseInt(idStr, 10, 64)
-	if err != nil {
-		s.logr.Error("Invalid expression ID: %v", err)
-		errors.HandleHTTPError(w, errors.NewBadRequestError("invalid expression ID"))
-		return
-	}
-
-	expr, err := s.db.GetExpression(id, userID)
-	if err != nil {
-		s.logr.Error("Failed to get expression %d: %v", id, err)
-		if err.Error() == "expression not found" {
-			errors.HandleHTTPError(w, errors.NewNotFoundError("expression not found"))
-		} else {
-			errors.HandleHTTPError(w, errors.NewInternalError("failed to get expression"))
-		}
-		return
-	}
-
-	json.NewEncoder(w).Encode(expr)
-}
+package calculator
+
+import (
+	"DistributedCalc/internal/auth"
+	"DistributedCalc/internal/events"
+	"DistributedCalc/internal/numeric"
+	"DistributedCalc/internal/orchestrator"
+	"DistributedCalc/internal/storage"
+	"DistributedCalc/internal/webhooks"
+	"DistributedCalc/pkg/errors"
+	"DistributedCalc/pkg/logger"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// streamKeepAlive is how often an idle event stream sends a comment, so that
+// proxies do not close it.
+const streamKeepAlive = 15 * time.Second
+
+// JobQueue accepts saved expressions for processing.
+type JobQueue interface {
+	Enqueue(exprID int64)
+	Cancel(exprID int64)
+}
+
+type CalculatorService struct {
+	db             *storage.SQLiteDB
+	queue          JobQueue
+	bus            *events.Bus
+	defaultTimeout time.Duration
+	optimize       orchestrator.OptimizePolicy
+	callbacks      bool
+	logr           *logger.Logger
+}
+
+func NewCalculatorService(db *storage.SQLiteDB, logr *logger.Logger) *CalculatorService {
+	return &CalculatorService{db: db, logr: logr, optimize: orchestrator.DefaultOptimizePolicy()}
+}
+
+// SetCallbacksEnabled decides whether expressions may have a callback_url.
+// It should only be enabled once a notifier with a secret delivers them.
+func (s *CalculatorService) SetCallbacksEnabled(enabled bool) {
+	s.callbacks = enabled
+}
+
+// SetOptimizePolicy sets the policy GetExpressionTasksHandler plans tasks
+// with. It should be the one the orchestrator optimises expressions with.
+func (s *CalculatorService) SetOptimizePolicy(policy orchestrator.OptimizePolicy) {
+	s.optimize = policy
+}
+
+// SetDefaultTimeout sets the time expressions submitted without timeout_ms
+// have to finish. Zero, the default, lets them run without a deadline.
+func (s *CalculatorService) SetDefaultTimeout(timeout time.Duration) {
+	s.defaultTimeout = timeout
+}
+
+// SetEventBus sets the bus StreamExpressionsHandler subscribes to.
+func (s *CalculatorService) SetEventBus(bus *events.Bus) {
+	s.bus = bus
+}
+
+// SetQueue makes the service hand new expressions to queue right away.
+// Without a queue they wait for the next sweep of pending expressions.
+func (s *CalculatorService) SetQueue(queue JobQueue) {
+	s.queue = queue
+}
+
+type CalcRequest struct {
+	Expression string             `json:"expression"`
+	Variables  map[string]float64 `json:"variables,omitempty"`
+	TimeoutMS  int64              `json:"timeout_ms,omitempty"`
+	// CallbackURL is sent the outcome of the expression once it finishes.
+	CallbackURL string `json:"callback_url,omitempty"`
+	// Precision selects the arithmetic: float64, the default, decimal,
+	// rational, bigint or complex, the default for expressions with
+	// imaginary numbers.
+	Precision numeric.Precision `json:"precision"`
+}
+
+type CalcResponse struct {
+	ID int64 `json:"id"`
+}
+
+func (s *CalculatorService) CalculateHandler(w http.ResponseWriter, r *http.Request) {
+	var req CalcRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.logr.Error("Failed to decode request: %v", err)
+		errors.HandleHTTPError(w, errors.NewBadRequestError("invalid request body"))
+		return
+	}
+
+	userID, ok := r.Context().Value(auth.UserIDKey).(int64)
+	if !ok {
+		s.logr.Error("User ID not found in context")
+		errors.HandleHTTPError(w, errors.NewInternalError("user not authenticated"))
+		return
+	}
+
+	id, err := s.submit(userID, req)
+	if err != nil {
+		errors.HandleHTTPError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(CalcResponse{ID: id})
+}
+
+// submit validates req, saves it for userID and hands it to the queue.
+func (s *CalculatorService) submit(userID int64, req CalcRequest) (int64, error) {
+	expr, err := s.validate(req)
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := s.db.SaveNewExpression(userID, expr)
+	if err != nil {
+		s.logr.Error("Failed to save expression: %v", err)
+		return 0, errors.NewInternalError("failed to save expression")
+	}
+
+	s.logr.Info("Expression saved with ID %d for user %d", id, userID)
+	if s.queue != nil {
+		s.queue.Enqueue(id)
+	}
+	return id, nil
+}
+
+// validate checks req, including that its expression parses with its
+// variables, and turns it into the expression to save, with its timeout
+// resolved to a deadline.
+func (s *CalculatorService) validate(req CalcRequest) (storage.NewExpression, error) {
+	if err := orchestrator.ValidateVariables(req.Variables); err != nil {
+		s.logr.Error("Invalid variables: %v", err)
+		return storage.NewExpression{}, err
+	}
+
+	// Reject what can never be computed now instead of failing it later.
+	root, err := orchestrator.ParseWithVariables(req.Expression, req.Variables)
+	if err != nil {
+		s.logr.Error("Invalid expression %q: %v", req.Expression, err)
+		return storage.NewExpression{}, err
+	}
+	precision, err := orchestrator.ResolvePrecision(root, req.Precision)
+	if err != nil {
+		s.logr.Error("Invalid precision %+v: %v", req.Precision, err)
+		return storage.NewExpression{}, err
+	}
+	if err := orchestrator.ValidateNumbers(root, precision); err != nil {
+		s.logr.Error("Invalid number in %q for %s precision: %v", req.Expression, precision.Mode, err)
+		return storage.NewExpression{}, err
+	}
+
+	if req.TimeoutMS < 0 {
+		s.logr.Error("Invalid timeout: %d", req.TimeoutMS)
+		return storage.NewExpression{}, NewInvalidTimeoutError()
+	}
+
+	if req.CallbackURL != "" {
+		if !s.callbacks {
+			s.logr.Error("Callback URL %s given, but callbacks are disabled", req.CallbackURL)
+			return storage.NewExpression{}, NewCallbacksDisabledError()
+		}
+		callback, err := url.Parse(req.CallbackURL)
+		if err != nil || (callback.Scheme != "http" && callback.Scheme != "https") || callback.Host == "" {
+			s.logr.Error("Invalid callback URL: %s", req.CallbackURL)
+			return storage.NewExpression{}, NewInvalidCallbackURLError()
+		}
+		if !webhooks.IsPublicHost(callback.Hostname()) {
+			s.logr.Error("Callback URL to a private address: %s", req.CallbackURL)
+			return storage.NewExpression{}, NewPrivateCallbackURLError()
+		}
+	}
+
+	timeout := time.Duration(req.TimeoutMS) * time.Millisecond
+	if timeout == 0 {
+		timeout = s.defaultTimeout
+	}
+	var deadline time.Time
+	if timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+	return storage.NewExpression{Expression: req.Expression, Variables: req.Variables, Deadline: deadline, CallbackURL: req.CallbackURL, Precision: precision}, nil
+}
+
+func (s *CalculatorService) ListExpressionsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(auth.UserIDKey).(int64)
+	if !ok {
+		s.logr.Error("User ID not found in context")
+		errors.HandleHTTPError(w, errors.NewInternalError("user not authenticated"))
+		return
+	}
+
+	exprs, err := s.db.GetUserExpressions(userID)
+	if err != nil {
+		s.logr.Error("Failed to get expressions: %v", err)
+		errors.HandleHTTPError(w, errors.NewInternalError("failed to get expressions"))
+		return
+	}
+
+	json.NewEncoder(w).Encode(exprs)
+}
+
+func (s *CalculatorService) GetExpressionHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(auth.UserIDKey).(int64)
+	if !ok {
+		s.logr.Error("User ID not found in context")
+		errors.HandleHTTPError(w, errors.NewInternalError("user not authenticated"))
+		return
+	}
+
+	idStr := r.URL.Query().Get("id")
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		s.logr.Error("Invalid expression ID: %v", err)
+		errors.HandleHTTPError(w, errors.NewBadRequestError("invalid expression ID"))
+		return
+	}
+
+	expr, err := s.db.GetExpression(id, userID)
+	if err != nil {
+		s.logr.Error("Failed to get expression %d: %v", id, err)
+		if err.Error() == "expression not found" {
+			errors.HandleHTTPError(w, errors.NewNotFoundError("expression not found"))
+		} else {
+			errors.HandleHTTPError(w, errors.NewInternalError("failed to get expression"))
+		}
+		return
+	}
+
+	json.NewEncoder(w).Encode(expr)
+}
+
+// CancelExpressionHandler cancels an expression of the current user that
+// has not finished yet and stops its computation.
+func (s *CalculatorService) CancelExpressionHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(auth.UserIDKey).(int64)
+	if !ok {
+		s.logr.Error("User ID not found in context")
+		errors.HandleHTTPError(w, errors.NewInternalError("user not authenticated"))
+		return
+	}
+
+	id, err := strconv.ParseInt(r.URL.Query().Get("id"), 10, 64)
+	if err != nil {
+		s.logr.Error("Invalid expression ID: %v", err)
+		errors.HandleHTTPError(w, errors.NewBadRequestError("invalid expression ID"))
+		return
+	}
+
+	if err := s.db.CancelExpression(id, userID); err != nil {
+		s.logr.Error("Failed to cancel expression %d: %v", id, err)
+		if err.Error() == "expression not found" {
+			errors.HandleHTTPError(w, errors.NewNotFoundError("expression not found"))
+		} else {
+			errors.HandleHTTPError(w, err)
+		}
+		return
+	}
+	if s.queue != nil {
+		s.queue.Cancel(id)
+	}
+
+	s.logr.Info("Expression %d cancelled by user %d", id, userID)
+	expr, err := s.db.GetExpression(id, userID)
+	if err != nil {
+		errors.HandleHTTPError(w, errors.NewInternalError("failed to get expression"))
+		return
+	}
+	json.NewEncoder(w).Encode(expr)
+}
+
+// StreamExpressionsHandler streams the status changes and finished tasks of
+// the current user's expressions as server-sent events until the client
+// disconnects.
+func (s *CalculatorService) StreamExpressionsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(auth.UserIDKey).(int64)
+	if !ok {
+		s.logr.Error("User ID not found in context")
+		errors.HandleHTTPError(w, errors.NewInternalError("user not authenticated"))
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok || s.bus == nil {
+		s.logr.Error("Event stream is not available")
+		errors.HandleHTTPError(w, errors.NewInternalError("streaming is not supported"))
+		return
+	}
+
+	sub := s.bus.Subscribe(userID)
+	defer sub.Close()
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	s.logr.Info("User %d subscribed to expression events", userID)
+
+	keepAlive := time.NewTicker(streamKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case event := <-sub.C:
+			data, err := json.Marshal(event)
+			if err != nil {
+				s.logr.Error("Failed to encode event: %v", err)
+				continue
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data)
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+		case <-r.Context().Done():
+			return
+		}
+		flusher.Flush()
+	}
+}
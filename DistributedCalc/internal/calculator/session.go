@@ -0,0 +1,156 @@
+package calculator
+
+import (
+	"DistributedCalc/internal/auth"
+	"DistributedCalc/internal/events"
+	"DistributedCalc/internal/storage"
+	"DistributedCalc/pkg/errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Message types sent over a calculation session.
+const (
+	SessionAccepted = "accepted"
+	SessionResult   = "result"
+	SessionError    = "error"
+)
+
+const (
+	// closeTokenExpired is the close code sent when the token of a session
+	// expires.
+	closeTokenExpired = 4001
+	maxSessionMessage = 64 << 10
+)
+
+// SessionRequest is an expression a client submits over a calculation
+// session. Ref is echoed back so the client can match the assigned ID.
+type SessionRequest struct {
+	Ref string `json:"ref,omitempty"`
+	CalcRequest
+}
+
+// SessionMessage is sent to the client: accepted with the ID of a saved
+// expression, result once it is finished, or error if it was rejected.
+type SessionMessage struct {
+	Type   string   `json:"type"`
+	Ref    string   `json:"ref,omitempty"`
+	ID     int64    `json:"id,omitempty"`
+	Status string   `json:"status,omitempty"`
+	Result *float64 `json:"result,omitempty"`
+	Imag   float64  `json:"imag,omitempty"`
+	Value  string   `json:"value,omitempty"`
+	Code   int      `json:"code,omitempty"`
+	Error  string   `json:"error,omitempty"`
+}
+
+// SessionHandler serves a calculation session over a WebSocket. Every
+// message the client sends is submitted like a POST to /api/v1/calculate;
+// the client gets the ID right away and the outcome once the expression is
+// finished. Any number of expressions may be in flight. The session ends
+// when the token it was opened with expires.
+func (s *CalculatorService) SessionHandler(conn *websocket.Conn, r *http.Request) {
+	userID, ok := r.Context().Value(auth.UserIDKey).(int64)
+	if !ok || s.bus == nil {
+		s.logr.Error("Calculation session is not available")
+		conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseInternalServerErr, "session is not available"))
+		return
+	}
+
+	var writeMu sync.Mutex
+	send := func(msg SessionMessage) {
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		if err := conn.WriteJSON(msg); err != nil {
+			s.logr.Error("Failed to send session message: %v", err)
+		}
+	}
+	closeWith := func(code int, reason string) {
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), time.Now().Add(time.Second))
+		conn.Close()
+	}
+
+	// Subscribe before the first expression is saved, so no outcome is missed.
+	sub := s.bus.Subscribe(userID)
+	defer sub.Close()
+	var mu sync.Mutex
+	inFlight := make(map[int64]string)
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		var expired <-chan time.Time
+		if expiresAt, ok := r.Context().Value(auth.TokenExpiresKey).(time.Time); ok {
+			timer := time.NewTimer(time.Until(expiresAt))
+			defer timer.Stop()
+			expired = timer.C
+		}
+		for {
+			select {
+			case event := <-sub.C:
+				if event.Type != events.TypeExpression || !isFinal(event.Status) {
+					continue
+				}
+				mu.Lock()
+				ref, ours := inFlight[event.ExpressionID]
+				delete(inFlight, event.ExpressionID)
+				mu.Unlock()
+				if !ours {
+					continue
+				}
+				msg := SessionMessage{Type: SessionResult, Ref: ref, ID: event.ExpressionID, Status: event.Status, Error: event.Error}
+				if event.Status == storage.ExpressionCompleted {
+					result := event.Result
+					msg.Result, msg.Imag, msg.Value = &result, event.Imag, event.Value
+				}
+				send(msg)
+			case <-expired:
+				s.logr.Info("Token of user %d expired, closing calculation session", userID)
+				closeWith(closeTokenExpired, "token expired")
+				return
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	s.logr.Info("User %d opened a calculation session", userID)
+	conn.SetReadLimit(maxSessionMessage)
+	for {
+		var req SessionRequest
+		if err := conn.ReadJSON(&req); err != nil {
+			s.logr.Info("Calculation session of user %d ended: %v", userID, err)
+			return
+		}
+
+		// An expression may finish before submit returns. Holding mu until
+		// it is registered and acknowledged makes its outcome wait for that.
+		mu.Lock()
+		id, err := s.submit(userID, req.CalcRequest)
+		if err != nil {
+			mu.Unlock()
+			msg := SessionMessage{Type: SessionError, Ref: req.Ref, Code: http.StatusInternalServerError, Error: err.Error()}
+			if appErr, ok := err.(*errors.AppError); ok {
+				msg.Code = appErr.Code
+			}
+			send(msg)
+			continue
+		}
+		inFlight[id] = req.Ref
+		send(SessionMessage{Type: SessionAccepted, Ref: req.Ref, ID: id, Status: storage.ExpressionPending})
+		mu.Unlock()
+	}
+}
+
+func isFinal(status string) bool {
+	switch status {
+	case storage.ExpressionCompleted, storage.ExpressionError, storage.ExpressionCancelled, storage.ExpressionTimeout:
+		return true
+	}
+	return false
+}
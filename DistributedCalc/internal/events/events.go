@@ -0,0 +1,95 @@
+package events
+
+import "sync"
+
+const (
+	TypeExpression = "expression"
+	TypeTask       = "task"
+)
+
+// subscriberBuffer is how many events a subscriber may fall behind before
+// new events for it are dropped.
+const subscriberBuffer = 64
+
+// Event reports a status change of an expression or a finished task of one.
+// Value is the result in text form, exact unless computed in float64 or
+// complex; Result is its real part and Imag its imaginary part.
+type Event struct {
+	Type         string  `json:"type"`
+	UserID       int64   `json:"-"`
+	ExpressionID int64   `json:"expression_id"`
+	TaskID       int64   `json:"task_id,omitempty"`
+	Operator     string  `json:"operator,omitempty"`
+	Status       string  `json:"status"`
+	Result       float64 `json:"result"`
+	Imag         float64 `json:"imag,omitempty"`
+	Value        string  `json:"value,omitempty"`
+	Error        string  `json:"error,omitempty"`
+}
+
+// Publisher accepts events. It is implemented by Bus.
+type Publisher interface {
+	Publish(event Event)
+}
+
+// Publishers passes every event to each of its publishers in turn.
+type Publishers []Publisher
+
+func (p Publishers) Publish(event Event) {
+	for _, publisher := range p {
+		publisher.Publish(event)
+	}
+}
+
+// Subscription receives the events of one user until it is closed.
+type Subscription struct {
+	C      <-chan Event
+	userID int64
+	ch     chan Event
+	bus    *Bus
+}
+
+// Close stops the subscription and closes C.
+func (s *Subscription) Close() {
+	s.bus.mu.Lock()
+	defer s.bus.mu.Unlock()
+	if _, ok := s.bus.subs[s]; ok {
+		delete(s.bus.subs, s)
+		close(s.ch)
+	}
+}
+
+// Bus is an in-process publish/subscribe hub for events. Publishing never
+// blocks: a subscriber that does not keep up misses events.
+type Bus struct {
+	mu   sync.Mutex
+	subs map[*Subscription]struct{}
+}
+
+func NewBus() *Bus {
+	return &Bus{subs: make(map[*Subscription]struct{})}
+}
+
+// Subscribe returns a subscription to the events of userID's expressions.
+func (b *Bus) Subscribe(userID int64) *Subscription {
+	ch := make(chan Event, subscriberBuffer)
+	sub := &Subscription{C: ch, userID: userID, ch: ch, bus: b}
+	b.mu.Lock()
+	b.subs[sub] = struct{}{}
+	b.mu.Unlock()
+	return sub
+}
+
+func (b *Bus) Publish(event Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for sub := range b.subs {
+		if sub.userID != event.UserID {
+			continue
+		}
+		select {
+		case sub.ch <- event:
+		default:
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package events
+
+import "testing"
+
+func TestBus(t *testing.T) {
+	bus := NewBus()
+	alice := bus.Subscribe(1)
+	bob := bus.Subscribe(2)
+	defer bob.Close()
+
+	bus.Publish(Event{Type: TypeExpression, UserID: 1, ExpressionID: 10, Status: "processing"})
+	bus.Publish(Event{Type: TypeTask, UserID: 2, ExpressionID: 20, TaskID: 5, Status: "completed", Result: 4})
+
+	if event := <-alice.C; event.ExpressionID != 10 || event.Status != "processing" {
+		t.Errorf("Expected the event of expression 10, got %+v", event)
+	}
+	if event := <-bob.C; event.TaskID != 5 || event.Result != 4 {
+		t.Errorf("Expected the event of task 5, got %+v", event)
+	}
+	select {
+	case event := <-alice.C:
+		t.Errorf("Expected no events of other users, got %+v", event)
+	default:
+	}
+
+	alice.Close()
+	alice.Close()
+	if _, ok := <-alice.C; ok {
+		t.Error("Expected a closed subscription to close its channel")
+	}
+	bus.Publish(Event{Type: TypeExpression, UserID: 1, ExpressionID: 11})
+}
+
+func TestBus_SlowSubscriber(t *testing.T) {
+	bus := NewBus()
+	sub := bus.Subscribe(1)
+	defer sub.Close()
+
+	// Publishing must not block on a subscriber that does not read.
+	for i := 0; i < subscriberBuffer*2; i++ {
+		bus.Publish(Event{Type: TypeExpression, UserID: 1, ExpressionID: int64(i)})
+	}
+	if len(sub.C) != subscriberBuffer {
+		t.Errorf("Expected %d buffered events, got %d", subscriberBuffer, len(sub.C))
+	}
+	if event := <-sub.C; event.ExpressionID != 0 {
+		t.Errorf("Expected the oldest events to be kept, got %+v", event)
+	}
+}
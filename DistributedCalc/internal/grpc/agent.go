@@ -0,0 +1,144 @@
+package grpc
+
+import (
+	"DistributedCalc/internal/operators"
+	"DistributedCalc/pkg/logger"
+	"context"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+const (
+	minReconnectDelay        = 100 * time.Millisecond
+	maxReconnectDelay        = 5 * time.Second
+	defaultHeartbeatInterval = time.Second
+)
+
+// Agent computes tasks pushed by the orchestrator over the task channel.
+type Agent struct {
+	id                string
+	computingPower    int
+	capabilities      []string
+	heartbeatInterval time.Duration
+	server            *Server
+	logr              *logger.Logger
+}
+
+func NewAgent(id string, computingPower int, logr *logger.Logger) *Agent {
+	return &Agent{
+		id:                id,
+		computingPower:    computingPower,
+		heartbeatInterval: defaultHeartbeatInterval,
+		server:            NewServer(logr),
+		logr:              logr,
+	}
+}
+
+// SetCapabilities sets the free-form labels the agent registers with.
+func (a *Agent) SetCapabilities(capabilities []string) {
+	a.capabilities = capabilities
+}
+
+func (a *Agent) SetHeartbeatInterval(interval time.Duration) {
+	a.heartbeatInterval = interval
+}
+
+// Run keeps a task channel open to the orchestrator at addr, reconnecting
+// with backoff, until ctx is cancelled.
+func (a *Agent) Run(ctx context.Context, addr string) error {
+	conn, err := grpc.NewClient(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	client := NewTaskChannelClient(conn)
+
+	delay := minReconnectDelay
+	for {
+		start := time.Now()
+		err := a.serve(ctx, client)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if time.Since(start) > maxReconnectDelay {
+			delay = minReconnectDelay
+		}
+		a.logr.Error("Task channel to %s closed: %v, reconnecting in %v", addr, err, delay)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return nil
+		}
+		delay = min(delay*2, maxReconnectDelay)
+	}
+}
+
+func (a *Agent) serve(ctx context.Context, client TaskChannelClient) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stream, err := client.Connect(ctx)
+	if err != nil {
+		return err
+	}
+	hello := &AgentMessage{
+		AgentId:        a.id,
+		ComputingPower: int32(a.computingPower),
+		Operators:      operators.Symbols(),
+		Capabilities:   a.capabilities,
+	}
+	if err := stream.Send(hello); err != nil {
+		return err
+	}
+	a.logr.Info("Agent %s connected to the task channel", a.id)
+
+	var (
+		sendMu sync.Mutex
+		wg     sync.WaitGroup
+	)
+	defer wg.Wait()
+	// Cancel before waiting, so that the heartbeat loop stops.
+	defer cancel()
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(a.heartbeatInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				sendMu.Lock()
+				err := stream.Send(&AgentMessage{AgentId: a.id, Heartbeat: true})
+				sendMu.Unlock()
+				if err != nil {
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	sem := make(chan struct{}, a.computingPower)
+	for {
+		req, err := stream.Recv()
+		if err != nil {
+			return err
+		}
+		sem <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			resp, _ := a.server.CalculateTask(ctx, req)
+			sendMu.Lock()
+			defer sendMu.Unlock()
+			if err := stream.Send(&AgentMessage{AgentId: a.id, Result: resp}); err != nil {
+				a.logr.Error("Failed to send result of task %d: %v", req.TaskId, err)
+			}
+		}()
+	}
+}
@@ -21,9 +21,171 @@ const (
 	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
 )
 
+type Operator int32
+
+const (
+	Operator_OPERATOR_UNSPECIFIED    Operator = 0
+	Operator_OPERATOR_ADD            Operator = 1
+	Operator_OPERATOR_SUBTRACT       Operator = 2
+	Operator_OPERATOR_MULTIPLY       Operator = 3
+	Operator_OPERATOR_DIVIDE         Operator = 4
+	Operator_OPERATOR_INTEGER_DIVIDE Operator = 5
+	Operator_OPERATOR_MODULO         Operator = 6
+	Operator_OPERATOR_POWER          Operator = 7
+	Operator_OPERATOR_NEGATE         Operator = 8
+	Operator_OPERATOR_SQRT           Operator = 9
+	Operator_OPERATOR_ABS            Operator = 10
+	Operator_OPERATOR_MIN            Operator = 11
+	Operator_OPERATOR_MAX            Operator = 12
+	Operator_OPERATOR_LOG            Operator = 13
+	Operator_OPERATOR_ROUND          Operator = 14
+	Operator_OPERATOR_CONJ           Operator = 15
+	Operator_OPERATOR_RE             Operator = 16
+	Operator_OPERATOR_IM             Operator = 17
+	Operator_OPERATOR_ARG            Operator = 18
+)
+
+// Enum value maps for Operator.
+var (
+	Operator_name = map[int32]string{
+		0:  "OPERATOR_UNSPECIFIED",
+		1:  "OPERATOR_ADD",
+		2:  "OPERATOR_SUBTRACT",
+		3:  "OPERATOR_MULTIPLY",
+		4:  "OPERATOR_DIVIDE",
+		5:  "OPERATOR_INTEGER_DIVIDE",
+		6:  "OPERATOR_MODULO",
+		7:  "OPERATOR_POWER",
+		8:  "OPERATOR_NEGATE",
+		9:  "OPERATOR_SQRT",
+		10: "OPERATOR_ABS",
+		11: "OPERATOR_MIN",
+		12: "OPERATOR_MAX",
+		13: "OPERATOR_LOG",
+		14: "OPERATOR_ROUND",
+		15: "OPERATOR_CONJ",
+		16: "OPERATOR_RE",
+		17: "OPERATOR_IM",
+		18: "OPERATOR_ARG",
+	}
+	Operator_value = map[string]int32{
+		"OPERATOR_UNSPECIFIED":    0,
+		"OPERATOR_ADD":            1,
+		"OPERATOR_SUBTRACT":       2,
+		"OPERATOR_MULTIPLY":       3,
+		"OPERATOR_DIVIDE":         4,
+		"OPERATOR_INTEGER_DIVIDE": 5,
+		"OPERATOR_MODULO":         6,
+		"OPERATOR_POWER":          7,
+		"OPERATOR_NEGATE":         8,
+		"OPERATOR_SQRT":           9,
+		"OPERATOR_ABS":            10,
+		"OPERATOR_MIN":            11,
+		"OPERATOR_MAX":            12,
+		"OPERATOR_LOG":            13,
+		"OPERATOR_ROUND":          14,
+		"OPERATOR_CONJ":           15,
+		"OPERATOR_RE":             16,
+		"OPERATOR_IM":             17,
+		"OPERATOR_ARG":            18,
+	}
+)
+
+func (x Operator) Enum() *Operator {
+	p := new(Operator)
+	*p = x
+	return p
+}
+
+func (x Operator) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (Operator) Descriptor() protoreflect.EnumDescriptor {
+	return file_internal_grpc_calc_proto_enumTypes[0].Descriptor()
+}
+
+func (Operator) Type() protoreflect.EnumType {
+	return &file_internal_grpc_calc_proto_enumTypes[0]
+}
+
+func (x Operator) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use Operator.Descriptor instead.
+func (Operator) EnumDescriptor() ([]byte, []int) {
+	return file_internal_grpc_calc_proto_rawDescGZIP(), []int{0}
+}
+
+type ErrorCode int32
+
+const (
+	ErrorCode_ERROR_CODE_NONE              ErrorCode = 0
+	ErrorCode_ERROR_CODE_INVALID_OPERATOR  ErrorCode = 1
+	ErrorCode_ERROR_CODE_INVALID_ARGUMENT  ErrorCode = 2
+	ErrorCode_ERROR_CODE_DIVISION_BY_ZERO  ErrorCode = 3
+	ErrorCode_ERROR_CODE_DOMAIN_ERROR      ErrorCode = 4
+	ErrorCode_ERROR_CODE_DEADLINE_EXCEEDED ErrorCode = 5
+)
+
+// Enum value maps for ErrorCode.
+var (
+	ErrorCode_name = map[int32]string{
+		0: "ERROR_CODE_NONE",
+		1: "ERROR_CODE_INVALID_OPERATOR",
+		2: "ERROR_CODE_INVALID_ARGUMENT",
+		3: "ERROR_CODE_DIVISION_BY_ZERO",
+		4: "ERROR_CODE_DOMAIN_ERROR",
+		5: "ERROR_CODE_DEADLINE_EXCEEDED",
+	}
+	ErrorCode_value = map[string]int32{
+		"ERROR_CODE_NONE":              0,
+		"ERROR_CODE_INVALID_OPERATOR":  1,
+		"ERROR_CODE_INVALID_ARGUMENT":  2,
+		"ERROR_CODE_DIVISION_BY_ZERO":  3,
+		"ERROR_CODE_DOMAIN_ERROR":      4,
+		"ERROR_CODE_DEADLINE_EXCEEDED": 5,
+	}
+)
+
+func (x ErrorCode) Enum() *ErrorCode {
+	p := new(ErrorCode)
+	*p = x
+	return p
+}
+
+func (x ErrorCode) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (ErrorCode) Descriptor() protoreflect.EnumDescriptor {
+	return file_internal_grpc_calc_proto_enumTypes[1].Descriptor()
+}
+
+func (ErrorCode) Type() protoreflect.EnumType {
+	return &file_internal_grpc_calc_proto_enumTypes[1]
+}
+
+func (x ErrorCode) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use ErrorCode.Descriptor instead.
+func (ErrorCode) EnumDescriptor() ([]byte, []int) {
+	return file_internal_grpc_calc_proto_rawDescGZIP(), []int{1}
+}
+
 type CalcRequest struct {
-	state         protoimpl.MessageState `protogen:"open.v1"`
-	Expression    string                 `protobuf:"bytes,1,opt,name=expression,proto3" json:"expression,omitempty"`
+	state      protoimpl.MessageState `protogen:"open.v1"`
+	Expression string                 `protobuf:"bytes,1,opt,name=expression,proto3" json:"expression,omitempty"`
+	// When set, the task is computed from operator and args instead of
+	// parsing expression. Functions take any number of args.
+	Operator string    `protobuf:"bytes,2,opt,name=operator,proto3" json:"operator,omitempty"`
+	Args     []float64 `protobuf:"fixed64,3,rep,packed,name=args,proto3" json:"args,omitempty"`
+	// Operands in text form. When set they are used instead of args.
+	ExactOperands []string   `protobuf:"bytes,4,rep,name=exact_operands,json=exactOperands,proto3" json:"exact_operands,omitempty"`
+	Precision     *Precision `protobuf:"bytes,5,opt,name=precision,proto3" json:"precision,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
@@ -65,10 +227,40 @@ func (x *CalcRequest) GetExpression() string {
 	return ""
 }
 
+func (x *CalcRequest) GetOperator() string {
+	if x != nil {
+		return x.Operator
+	}
+	return ""
+}
+
+func (x *CalcRequest) GetArgs() []float64 {
+	if x != nil {
+		return x.Args
+	}
+	return nil
+}
+
+func (x *CalcRequest) GetExactOperands() []string {
+	if x != nil {
+		return x.ExactOperands
+	}
+	return nil
+}
+
+func (x *CalcRequest) GetPrecision() *Precision {
+	if x != nil {
+		return x.Precision
+	}
+	return nil
+}
+
 type CalcResponse struct {
-	state         protoimpl.MessageState `protogen:"open.v1"`
-	Result        float64                `protobuf:"fixed64,1,opt,name=result,proto3" json:"result,omitempty"`
-	Error         string                 `protobuf:"bytes,2,opt,name=error,proto3" json:"error,omitempty"`
+	state  protoimpl.MessageState `protogen:"open.v1"`
+	Result float64                `protobuf:"fixed64,1,opt,name=result,proto3" json:"result,omitempty"`
+	Error  string                 `protobuf:"bytes,2,opt,name=error,proto3" json:"error,omitempty"`
+	// The result in text form, exact unless computed in float64 or complex.
+	Value         string `protobuf:"bytes,3,opt,name=value,proto3" json:"value,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
@@ -117,20 +309,450 @@ func (x *CalcResponse) GetError() string {
 	return ""
 }
 
+func (x *CalcResponse) GetValue() string {
+	if x != nil {
+		return x.Value
+	}
+	return ""
+}
+
+// Precision is how a task is computed. Unset, or mode "float64", computes
+// in float64. Mode "decimal" computes exactly and rounds the result to scale
+// decimal places with the rounding mode, such as "half_even" or "down".
+// Mode "rational" computes with exact fractions, written like "1/3", mode
+// "bigint" with integers of any size and mode "complex" with complex numbers
+// of two float64, written like "1-2i". Agents must accept every mode.
+type Precision struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Mode          string                 `protobuf:"bytes,1,opt,name=mode,proto3" json:"mode,omitempty"`
+	Scale         int32                  `protobuf:"varint,2,opt,name=scale,proto3" json:"scale,omitempty"`
+	Rounding      string                 `protobuf:"bytes,3,opt,name=rounding,proto3" json:"rounding,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Precision) Reset() {
+	*x = Precision{}
+	mi := &file_internal_grpc_calc_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Precision) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Precision) ProtoMessage() {}
+
+func (x *Precision) ProtoReflect() protoreflect.Message {
+	mi := &file_internal_grpc_calc_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Precision.ProtoReflect.Descriptor instead.
+func (*Precision) Descriptor() ([]byte, []int) {
+	return file_internal_grpc_calc_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *Precision) GetMode() string {
+	if x != nil {
+		return x.Mode
+	}
+	return ""
+}
+
+func (x *Precision) GetScale() int32 {
+	if x != nil {
+		return x.Scale
+	}
+	return 0
+}
+
+func (x *Precision) GetRounding() string {
+	if x != nil {
+		return x.Rounding
+	}
+	return ""
+}
+
+type TaskRequest struct {
+	state        protoimpl.MessageState `protogen:"open.v1"`
+	TaskId       int64                  `protobuf:"varint,1,opt,name=task_id,json=taskId,proto3" json:"task_id,omitempty"`
+	ExpressionId int64                  `protobuf:"varint,2,opt,name=expression_id,json=expressionId,proto3" json:"expression_id,omitempty"`
+	Operator     Operator               `protobuf:"varint,3,opt,name=operator,proto3,enum=Operator" json:"operator,omitempty"`
+	Arg1         float64                `protobuf:"fixed64,4,opt,name=arg1,proto3" json:"arg1,omitempty"`
+	Arg2         float64                `protobuf:"fixed64,5,opt,name=arg2,proto3" json:"arg2,omitempty"`
+	// Operands of functions that take other than two arguments. When empty,
+	// arg1 and arg2 are used (arg1 alone for unary operators).
+	Args []float64 `protobuf:"fixed64,6,rep,packed,name=args,proto3" json:"args,omitempty"`
+	// Unix time in milliseconds after which the result is no longer needed.
+	// Zero means no deadline.
+	DeadlineUnixMs int64 `protobuf:"varint,7,opt,name=deadline_unix_ms,json=deadlineUnixMs,proto3" json:"deadline_unix_ms,omitempty"`
+	// Operands in text form. When set they are used instead of arg1,
+	// arg2 and args, which then only approximate them.
+	ExactOperands []string   `protobuf:"bytes,8,rep,name=exact_operands,json=exactOperands,proto3" json:"exact_operands,omitempty"`
+	Precision     *Precision `protobuf:"bytes,9,opt,name=precision,proto3" json:"precision,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *TaskRequest) Reset() {
+	*x = TaskRequest{}
+	mi := &file_internal_grpc_calc_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *TaskRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*TaskRequest) ProtoMessage() {}
+
+func (x *TaskRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_internal_grpc_calc_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use TaskRequest.ProtoReflect.Descriptor instead.
+func (*TaskRequest) Descriptor() ([]byte, []int) {
+	return file_internal_grpc_calc_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *TaskRequest) GetTaskId() int64 {
+	if x != nil {
+		return x.TaskId
+	}
+	return 0
+}
+
+func (x *TaskRequest) GetExpressionId() int64 {
+	if x != nil {
+		return x.ExpressionId
+	}
+	return 0
+}
+
+func (x *TaskRequest) GetOperator() Operator {
+	if x != nil {
+		return x.Operator
+	}
+	return Operator_OPERATOR_UNSPECIFIED
+}
+
+func (x *TaskRequest) GetArg1() float64 {
+	if x != nil {
+		return x.Arg1
+	}
+	return 0
+}
+
+func (x *TaskRequest) GetArg2() float64 {
+	if x != nil {
+		return x.Arg2
+	}
+	return 0
+}
+
+func (x *TaskRequest) GetArgs() []float64 {
+	if x != nil {
+		return x.Args
+	}
+	return nil
+}
+
+func (x *TaskRequest) GetDeadlineUnixMs() int64 {
+	if x != nil {
+		return x.DeadlineUnixMs
+	}
+	return 0
+}
+
+func (x *TaskRequest) GetExactOperands() []string {
+	if x != nil {
+		return x.ExactOperands
+	}
+	return nil
+}
+
+func (x *TaskRequest) GetPrecision() *Precision {
+	if x != nil {
+		return x.Precision
+	}
+	return nil
+}
+
+type TaskResponse struct {
+	state     protoimpl.MessageState `protogen:"open.v1"`
+	TaskId    int64                  `protobuf:"varint,1,opt,name=task_id,json=taskId,proto3" json:"task_id,omitempty"`
+	Result    float64                `protobuf:"fixed64,2,opt,name=result,proto3" json:"result,omitempty"`
+	ErrorCode ErrorCode              `protobuf:"varint,3,opt,name=error_code,json=errorCode,proto3,enum=ErrorCode" json:"error_code,omitempty"`
+	Error     string                 `protobuf:"bytes,4,opt,name=error,proto3" json:"error,omitempty"`
+	// Agent that computed the task. Set by the orchestrator for results that
+	// arrive over the task channel.
+	AgentId string `protobuf:"bytes,5,opt,name=agent_id,json=agentId,proto3" json:"agent_id,omitempty"`
+	// The result in text form, exact unless computed in float64 or complex.
+	Value         string `protobuf:"bytes,6,opt,name=value,proto3" json:"value,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *TaskResponse) Reset() {
+	*x = TaskResponse{}
+	mi := &file_internal_grpc_calc_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *TaskResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*TaskResponse) ProtoMessage() {}
+
+func (x *TaskResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_internal_grpc_calc_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use TaskResponse.ProtoReflect.Descriptor instead.
+func (*TaskResponse) Descriptor() ([]byte, []int) {
+	return file_internal_grpc_calc_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *TaskResponse) GetTaskId() int64 {
+	if x != nil {
+		return x.TaskId
+	}
+	return 0
+}
+
+func (x *TaskResponse) GetResult() float64 {
+	if x != nil {
+		return x.Result
+	}
+	return 0
+}
+
+func (x *TaskResponse) GetErrorCode() ErrorCode {
+	if x != nil {
+		return x.ErrorCode
+	}
+	return ErrorCode_ERROR_CODE_NONE
+}
+
+func (x *TaskResponse) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
+func (x *TaskResponse) GetAgentId() string {
+	if x != nil {
+		return x.AgentId
+	}
+	return ""
+}
+
+func (x *TaskResponse) GetValue() string {
+	if x != nil {
+		return x.Value
+	}
+	return ""
+}
+
+// AgentMessage is sent by an agent over the task channel. The first message
+// registers the agent, every following one is either a heartbeat or carries
+// the result of a task.
+type AgentMessage struct {
+	state          protoimpl.MessageState `protogen:"open.v1"`
+	AgentId        string                 `protobuf:"bytes,1,opt,name=agent_id,json=agentId,proto3" json:"agent_id,omitempty"`
+	ComputingPower int32                  `protobuf:"varint,2,opt,name=computing_power,json=computingPower,proto3" json:"computing_power,omitempty"`
+	Result         *TaskResponse          `protobuf:"bytes,3,opt,name=result,proto3" json:"result,omitempty"`
+	// Operators and functions the agent can compute. Empty means all.
+	Operators     []string `protobuf:"bytes,4,rep,name=operators,proto3" json:"operators,omitempty"`
+	Capabilities  []string `protobuf:"bytes,5,rep,name=capabilities,proto3" json:"capabilities,omitempty"`
+	Heartbeat     bool     `protobuf:"varint,6,opt,name=heartbeat,proto3" json:"heartbeat,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *AgentMessage) Reset() {
+	*x = AgentMessage{}
+	mi := &file_internal_grpc_calc_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *AgentMessage) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*AgentMessage) ProtoMessage() {}
+
+func (x *AgentMessage) ProtoReflect() protoreflect.Message {
+	mi := &file_internal_grpc_calc_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use AgentMessage.ProtoReflect.Descriptor instead.
+func (*AgentMessage) Descriptor() ([]byte, []int) {
+	return file_internal_grpc_calc_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *AgentMessage) GetAgentId() string {
+	if x != nil {
+		return x.AgentId
+	}
+	return ""
+}
+
+func (x *AgentMessage) GetComputingPower() int32 {
+	if x != nil {
+		return x.ComputingPower
+	}
+	return 0
+}
+
+func (x *AgentMessage) GetResult() *TaskResponse {
+	if x != nil {
+		return x.Result
+	}
+	return nil
+}
+
+func (x *AgentMessage) GetOperators() []string {
+	if x != nil {
+		return x.Operators
+	}
+	return nil
+}
+
+func (x *AgentMessage) GetCapabilities() []string {
+	if x != nil {
+		return x.Capabilities
+	}
+	return nil
+}
+
+func (x *AgentMessage) GetHeartbeat() bool {
+	if x != nil {
+		return x.Heartbeat
+	}
+	return false
+}
+
 var File_internal_grpc_calc_proto protoreflect.FileDescriptor
 
 const file_internal_grpc_calc_proto_rawDesc = "" +
 	"\n" +
-	"\x18internal/grpc/calc.proto\"-\n" +
+	"\x18internal/grpc/calc.proto\"\xae\x01\n" +
 	"\vCalcRequest\x12\x1e\n" +
 	"\n" +
 	"expression\x18\x01 \x01(\tR\n" +
-	"expression\"<\n" +
+	"expression\x12\x1a\n" +
+	"\boperator\x18\x02 \x01(\tR\boperator\x12\x12\n" +
+	"\x04args\x18\x03 \x03(\x01R\x04args\x12%\n" +
+	"\x0eexact_operands\x18\x04 \x03(\tR\rexactOperands\x12(\n" +
+	"\tprecision\x18\x05 \x01(\v2\n" +
+	".PrecisionR\tprecision\"R\n" +
 	"\fCalcResponse\x12\x16\n" +
 	"\x06result\x18\x01 \x01(\x01R\x06result\x12\x14\n" +
-	"\x05error\x18\x02 \x01(\tR\x05error27\n" +
+	"\x05error\x18\x02 \x01(\tR\x05error\x12\x14\n" +
+	"\x05value\x18\x03 \x01(\tR\x05value\"Q\n" +
+	"\tPrecision\x12\x12\n" +
+	"\x04mode\x18\x01 \x01(\tR\x04mode\x12\x14\n" +
+	"\x05scale\x18\x02 \x01(\x05R\x05scale\x12\x1a\n" +
+	"\brounding\x18\x03 \x01(\tR\brounding\"\xa9\x02\n" +
+	"\vTaskRequest\x12\x17\n" +
+	"\atask_id\x18\x01 \x01(\x03R\x06taskId\x12#\n" +
+	"\rexpression_id\x18\x02 \x01(\x03R\fexpressionId\x12%\n" +
+	"\boperator\x18\x03 \x01(\x0e2\t.OperatorR\boperator\x12\x12\n" +
+	"\x04arg1\x18\x04 \x01(\x01R\x04arg1\x12\x12\n" +
+	"\x04arg2\x18\x05 \x01(\x01R\x04arg2\x12\x12\n" +
+	"\x04args\x18\x06 \x03(\x01R\x04args\x12(\n" +
+	"\x10deadline_unix_ms\x18\a \x01(\x03R\x0edeadlineUnixMs\x12%\n" +
+	"\x0eexact_operands\x18\b \x03(\tR\rexactOperands\x12(\n" +
+	"\tprecision\x18\t \x01(\v2\n" +
+	".PrecisionR\tprecision\"\xb1\x01\n" +
+	"\fTaskResponse\x12\x17\n" +
+	"\atask_id\x18\x01 \x01(\x03R\x06taskId\x12\x16\n" +
+	"\x06result\x18\x02 \x01(\x01R\x06result\x12)\n" +
+	"\n" +
+	"error_code\x18\x03 \x01(\x0e2\n" +
+	".ErrorCodeR\terrorCode\x12\x14\n" +
+	"\x05error\x18\x04 \x01(\tR\x05error\x12\x19\n" +
+	"\bagent_id\x18\x05 \x01(\tR\aagentId\x12\x14\n" +
+	"\x05value\x18\x06 \x01(\tR\x05value\"\xd9\x01\n" +
+	"\fAgentMessage\x12\x19\n" +
+	"\bagent_id\x18\x01 \x01(\tR\aagentId\x12'\n" +
+	"\x0fcomputing_power\x18\x02 \x01(\x05R\x0ecomputingPower\x12%\n" +
+	"\x06result\x18\x03 \x01(\v2\r.TaskResponseR\x06result\x12\x1c\n" +
+	"\toperators\x18\x04 \x03(\tR\toperators\x12\"\n" +
+	"\fcapabilities\x18\x05 \x03(\tR\fcapabilities\x12\x1c\n" +
+	"\theartbeat\x18\x06 \x01(\bR\theartbeat*\x8a\x03\n" +
+	"\bOperator\x12\x18\n" +
+	"\x14OPERATOR_UNSPECIFIED\x10\x00\x12\x10\n" +
+	"\fOPERATOR_ADD\x10\x01\x12\x15\n" +
+	"\x11OPERATOR_SUBTRACT\x10\x02\x12\x15\n" +
+	"\x11OPERATOR_MULTIPLY\x10\x03\x12\x13\n" +
+	"\x0fOPERATOR_DIVIDE\x10\x04\x12\x1b\n" +
+	"\x17OPERATOR_INTEGER_DIVIDE\x10\x05\x12\x13\n" +
+	"\x0fOPERATOR_MODULO\x10\x06\x12\x12\n" +
+	"\x0eOPERATOR_POWER\x10\a\x12\x13\n" +
+	"\x0fOPERATOR_NEGATE\x10\b\x12\x11\n" +
+	"\rOPERATOR_SQRT\x10\t\x12\x10\n" +
+	"\fOPERATOR_ABS\x10\n" +
+	"\x12\x10\n" +
+	"\fOPERATOR_MIN\x10\v\x12\x10\n" +
+	"\fOPERATOR_MAX\x10\f\x12\x10\n" +
+	"\fOPERATOR_LOG\x10\r\x12\x12\n" +
+	"\x0eOPERATOR_ROUND\x10\x0e\x12\x11\n" +
+	"\rOPERATOR_CONJ\x10\x0f\x12\x0f\n" +
+	"\vOPERATOR_RE\x10\x10\x12\x0f\n" +
+	"\vOPERATOR_IM\x10\x11\x12\x10\n" +
+	"\fOPERATOR_ARG\x10\x12*\xc2\x01\n" +
+	"\tErrorCode\x12\x13\n" +
+	"\x0fERROR_CODE_NONE\x10\x00\x12\x1f\n" +
+	"\x1bERROR_CODE_INVALID_OPERATOR\x10\x01\x12\x1f\n" +
+	"\x1bERROR_CODE_INVALID_ARGUMENT\x10\x02\x12\x1f\n" +
+	"\x1bERROR_CODE_DIVISION_BY_ZERO\x10\x03\x12\x1b\n" +
+	"\x17ERROR_CODE_DOMAIN_ERROR\x10\x04\x12 \n" +
+	"\x1cERROR_CODE_DEADLINE_EXCEEDED\x10\x052e\n" +
 	"\vCalcService\x12(\n" +
-	"\tCalculate\x12\f.CalcRequest\x1a\r.CalcResponseB\x1fZ\x1dDistributedCalc/internal/grpcb\x06proto3"
+	"\tCalculate\x12\f.CalcRequest\x1a\r.CalcResponse\x12,\n" +
+	"\rCalculateTask\x12\f.TaskRequest\x1a\r.TaskResponse29\n" +
+	"\vTaskChannel\x12*\n" +
+	"\aConnect\x12\r.AgentMessage\x1a\f.TaskRequest(\x010\x01B\x1fZ\x1dDistributedCalc/internal/grpcb\x06proto3"
 
 var (
 	file_internal_grpc_calc_proto_rawDescOnce sync.Once
@@ -144,19 +766,35 @@ func file_internal_grpc_calc_proto_rawDescGZIP() []byte {
 	return file_internal_grpc_calc_proto_rawDescData
 }
 
-var file_internal_grpc_calc_proto_msgTypes = make([]protoimpl.MessageInfo, 2)
+var file_internal_grpc_calc_proto_enumTypes = make([]protoimpl.EnumInfo, 2)
+var file_internal_grpc_calc_proto_msgTypes = make([]protoimpl.MessageInfo, 6)
 var file_internal_grpc_calc_proto_goTypes = []any{
-	(*CalcRequest)(nil),  // 0: CalcRequest
-	(*CalcResponse)(nil), // 1: CalcResponse
+	(Operator)(0),        // 0: Operator
+	(ErrorCode)(0),       // 1: ErrorCode
+	(*CalcRequest)(nil),  // 2: CalcRequest
+	(*CalcResponse)(nil), // 3: CalcResponse
+	(*Precision)(nil),    // 4: Precision
+	(*TaskRequest)(nil),  // 5: TaskRequest
+	(*TaskResponse)(nil), // 6: TaskResponse
+	(*AgentMessage)(nil), // 7: AgentMessage
 }
 var file_internal_grpc_calc_proto_depIdxs = []int32{
-	0, // 0: CalcService.Calculate:input_type -> CalcRequest
-	1, // 1: CalcService.Calculate:output_type -> CalcResponse
-	1, // [1:2] is the sub-list for method output_type
-	0, // [0:1] is the sub-list for method input_type
-	0, // [0:0] is the sub-list for extension type_name
-	0, // [0:0] is the sub-list for extension extendee
-	0, // [0:0] is the sub-list for field type_name
+	4, // 0: CalcRequest.precision:type_name -> Precision
+	0, // 1: TaskRequest.operator:type_name -> Operator
+	4, // 2: TaskRequest.precision:type_name -> Precision
+	1, // 3: TaskResponse.error_code:type_name -> ErrorCode
+	6, // 4: AgentMessage.result:type_name -> TaskResponse
+	2, // 5: CalcService.Calculate:input_type -> CalcRequest
+	5, // 6: CalcService.CalculateTask:input_type -> TaskRequest
+	7, // 7: TaskChannel.Connect:input_type -> AgentMessage
+	3, // 8: CalcService.Calculate:output_type -> CalcResponse
+	6, // 9: CalcService.CalculateTask:output_type -> TaskResponse
+	5, // 10: TaskChannel.Connect:output_type -> TaskRequest
+	8, // [8:11] is the sub-list for method output_type
+	5, // [5:8] is the sub-list for method input_type
+	5, // [5:5] is the sub-list for extension type_name
+	5, // [5:5] is the sub-list for extension extendee
+	0, // [0:5] is the sub-list for field type_name
 }
 
 func init() { file_internal_grpc_calc_proto_init() }
@@ -169,13 +807,14 @@ func file_internal_grpc_calc_proto_init() {
 		File: protoimpl.DescBuilder{
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: unsafe.Slice(unsafe.StringData(file_internal_grpc_calc_proto_rawDesc), len(file_internal_grpc_calc_proto_rawDesc)),
-			NumEnums:      0,
-			NumMessages:   2,
+			NumEnums:      2,
+			NumMessages:   6,
 			NumExtensions: 0,
-			NumServices:   1,
+			NumServices:   2,
 		},
 		GoTypes:           file_internal_grpc_calc_proto_goTypes,
 		DependencyIndexes: file_internal_grpc_calc_proto_depIdxs,
+		EnumInfos:         file_internal_grpc_calc_proto_enumTypes,
 		MessageInfos:      file_internal_grpc_calc_proto_msgTypes,
 	}.Build()
 	File_internal_grpc_calc_proto = out.File
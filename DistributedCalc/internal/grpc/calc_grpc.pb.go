@@ -19,14 +19,18 @@ import (
 const _ = grpc.SupportPackageIsVersion9
 
 const (
-	CalcService_Calculate_FullMethodName = "/CalcService/Calculate"
+	CalcService_Calculate_FullMethodName     = "/CalcService/Calculate"
+	CalcService_CalculateTask_FullMethodName = "/CalcService/CalculateTask"
 )
 
 // CalcServiceClient is the client API for CalcService service.
 //
 // For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
 type CalcServiceClient interface {
+	// Calculate is the original contract. Prefer CalculateTask.
 	Calculate(ctx context.Context, in *CalcRequest, opts ...grpc.CallOption) (*CalcResponse, error)
+	// CalculateTask computes a single task from typed operands.
+	CalculateTask(ctx context.Context, in *TaskRequest, opts ...grpc.CallOption) (*TaskResponse, error)
 }
 
 type calcServiceClient struct {
@@ -47,11 +51,24 @@ func (c *calcServiceClient) Calculate(ctx context.Context, in *CalcRequest, opts
 	return out, nil
 }
 
+func (c *calcServiceClient) CalculateTask(ctx context.Context, in *TaskRequest, opts ...grpc.CallOption) (*TaskResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(TaskResponse)
+	err := c.cc.Invoke(ctx, CalcService_CalculateTask_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // CalcServiceServer is the server API for CalcService service.
 // All implementations must embed UnimplementedCalcServiceServer
 // for forward compatibility.
 type CalcServiceServer interface {
+	// Calculate is the original contract. Prefer CalculateTask.
 	Calculate(context.Context, *CalcRequest) (*CalcResponse, error)
+	// CalculateTask computes a single task from typed operands.
+	CalculateTask(context.Context, *TaskRequest) (*TaskResponse, error)
 	mustEmbedUnimplementedCalcServiceServer()
 }
 
@@ -65,6 +82,9 @@ type UnimplementedCalcServiceServer struct{}
 func (UnimplementedCalcServiceServer) Calculate(context.Context, *CalcRequest) (*CalcResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method Calculate not implemented")
 }
+func (UnimplementedCalcServiceServer) CalculateTask(context.Context, *TaskRequest) (*TaskResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CalculateTask not implemented")
+}
 func (UnimplementedCalcServiceServer) mustEmbedUnimplementedCalcServiceServer() {}
 func (UnimplementedCalcServiceServer) testEmbeddedByValue()                     {}
 
@@ -104,6 +124,24 @@ func _CalcService_Calculate_Handler(srv interface{}, ctx context.Context, dec fu
 	return interceptor(ctx, in, info, handler)
 }
 
+func _CalcService_CalculateTask_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(TaskRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CalcServiceServer).CalculateTask(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: CalcService_CalculateTask_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CalcServiceServer).CalculateTask(ctx, req.(*TaskRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // CalcService_ServiceDesc is the grpc.ServiceDesc for CalcService service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -115,7 +153,113 @@ var CalcService_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "Calculate",
 			Handler:    _CalcService_Calculate_Handler,
 		},
+		{
+			MethodName: "CalculateTask",
+			Handler:    _CalcService_CalculateTask_Handler,
+		},
 	},
 	Streams:  []grpc.StreamDesc{},
 	Metadata: "internal/grpc/calc.proto",
 }
+
+const (
+	TaskChannel_Connect_FullMethodName = "/TaskChannel/Connect"
+)
+
+// TaskChannelClient is the client API for TaskChannel service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// TaskChannel is served by the orchestrator. Agents keep a Connect stream
+// open, receive tasks pushed over it and stream the results back.
+type TaskChannelClient interface {
+	Connect(ctx context.Context, opts ...grpc.CallOption) (grpc.BidiStreamingClient[AgentMessage, TaskRequest], error)
+}
+
+type taskChannelClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewTaskChannelClient(cc grpc.ClientConnInterface) TaskChannelClient {
+	return &taskChannelClient{cc}
+}
+
+func (c *taskChannelClient) Connect(ctx context.Context, opts ...grpc.CallOption) (grpc.BidiStreamingClient[AgentMessage, TaskRequest], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &TaskChannel_ServiceDesc.Streams[0], TaskChannel_Connect_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[AgentMessage, TaskRequest]{ClientStream: stream}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type TaskChannel_ConnectClient = grpc.BidiStreamingClient[AgentMessage, TaskRequest]
+
+// TaskChannelServer is the server API for TaskChannel service.
+// All implementations must embed UnimplementedTaskChannelServer
+// for forward compatibility.
+//
+// TaskChannel is served by the orchestrator. Agents keep a Connect stream
+// open, receive tasks pushed over it and stream the results back.
+type TaskChannelServer interface {
+	Connect(grpc.BidiStreamingServer[AgentMessage, TaskRequest]) error
+	mustEmbedUnimplementedTaskChannelServer()
+}
+
+// UnimplementedTaskChannelServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedTaskChannelServer struct{}
+
+func (UnimplementedTaskChannelServer) Connect(grpc.BidiStreamingServer[AgentMessage, TaskRequest]) error {
+	return status.Errorf(codes.Unimplemented, "method Connect not implemented")
+}
+func (UnimplementedTaskChannelServer) mustEmbedUnimplementedTaskChannelServer() {}
+func (UnimplementedTaskChannelServer) testEmbeddedByValue()                     {}
+
+// UnsafeTaskChannelServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to TaskChannelServer will
+// result in compilation errors.
+type UnsafeTaskChannelServer interface {
+	mustEmbedUnimplementedTaskChannelServer()
+}
+
+func RegisterTaskChannelServer(s grpc.ServiceRegistrar, srv TaskChannelServer) {
+	// If the following call pancis, it indicates UnimplementedTaskChannelServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&TaskChannel_ServiceDesc, srv)
+}
+
+func _TaskChannel_Connect_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(TaskChannelServer).Connect(&grpc.GenericServerStream[AgentMessage, TaskRequest]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type TaskChannel_ConnectServer = grpc.BidiStreamingServer[AgentMessage, TaskRequest]
+
+// TaskChannel_ServiceDesc is the grpc.ServiceDesc for TaskChannel service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var TaskChannel_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "TaskChannel",
+	HandlerType: (*TaskChannelServer)(nil),
+	Methods:     []grpc.MethodDesc{},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Connect",
+			Handler:       _TaskChannel_Connect_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+	},
+	Metadata: "internal/grpc/calc.proto",
+}
@@ -0,0 +1,288 @@
+package grpc
+
+import (
+	"DistributedCalc/pkg/logger"
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const defaultHeartbeatTimeout = 5 * time.Second
+
+var errAgentDisconnected = errors.New("agent disconnected")
+
+// AgentInfo is a snapshot of an agent connected to the task channel.
+type AgentInfo struct {
+	ID             string    `json:"id"`
+	ComputingPower int       `json:"computing_power"`
+	Operators      []string  `json:"operators"`
+	Capabilities   []string  `json:"capabilities"`
+	InFlight       int       `json:"in_flight"`
+	ConnectedAt    time.Time `json:"connected_at"`
+	LastSeen       time.Time `json:"last_seen"`
+}
+
+// Supports reports whether the agent can compute op.
+func (a AgentInfo) Supports(op string) bool {
+	if len(a.Operators) == 0 {
+		return true
+	}
+	for _, supported := range a.Operators {
+		if supported == op {
+			return true
+		}
+	}
+	return false
+}
+
+// AgentObserver is notified about the life cycle of connected agents.
+type AgentObserver interface {
+	AgentRegistered(info AgentInfo)
+	AgentHeartbeat(info AgentInfo)
+	TaskCompleted(agentID string)
+	// AgentLeft is called when the stream of an agent ends; dead is set if
+	// it was closed because the agent missed its heartbeats.
+	AgentLeft(agentID string, dead bool)
+}
+
+type agentConn struct {
+	info    AgentInfo
+	stream  TaskChannel_ConnectServer
+	sendMu  sync.Mutex
+	pending map[int64]chan *TaskResponse
+}
+
+// AgentHub serves the task channel. It pushes tasks to connected agents, at
+// most COMPUTING_POWER at a time per agent, and routes the results back to
+// the callers of CalculateTask.
+type AgentHub struct {
+	UnimplementedTaskChannelServer
+	logr             *logger.Logger
+	observer         AgentObserver
+	heartbeatTimeout time.Duration
+
+	mu      sync.Mutex
+	agents  map[string]*agentConn
+	changed chan struct{}
+}
+
+func NewAgentHub(logr *logger.Logger) *AgentHub {
+	return &AgentHub{
+		logr:             logr,
+		heartbeatTimeout: defaultHeartbeatTimeout,
+		agents:           make(map[string]*agentConn),
+		changed:          make(chan struct{}),
+	}
+}
+
+func (h *AgentHub) SetObserver(observer AgentObserver) {
+	h.observer = observer
+}
+
+// SetHeartbeatTimeout sets how long an agent may stay silent before its
+// stream is closed and it is considered dead.
+func (h *AgentHub) SetHeartbeatTimeout(timeout time.Duration) {
+	h.heartbeatTimeout = timeout
+}
+
+// Connect handles the stream of one agent until it disconnects.
+func (h *AgentHub) Connect(stream TaskChannel_ConnectServer) error {
+	hello, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	if hello.AgentId == "" || hello.ComputingPower <= 0 {
+		return status.Error(codes.InvalidArgument, "agent must send its ID and computing power first")
+	}
+
+	now := time.Now()
+	agent := &agentConn{
+		info: AgentInfo{
+			ID:             hello.AgentId,
+			ComputingPower: int(hello.ComputingPower),
+			Operators:      hello.Operators,
+			Capabilities:   hello.Capabilities,
+			ConnectedAt:    now,
+			LastSeen:       now,
+		},
+		stream:  stream,
+		pending: make(map[int64]chan *TaskResponse),
+	}
+	h.mu.Lock()
+	if _, ok := h.agents[agent.info.ID]; ok {
+		h.mu.Unlock()
+		return status.Errorf(codes.AlreadyExists, "agent %s is already connected", agent.info.ID)
+	}
+	info := agent.info
+	h.agents[info.ID] = agent
+	h.notifyLocked()
+	h.mu.Unlock()
+	h.logr.Info("Agent %s connected with computing power %d", info.ID, info.ComputingPower)
+	if h.observer != nil {
+		h.observer.AgentRegistered(info)
+	}
+
+	messages := make(chan *AgentMessage)
+	recvErr := make(chan error, 1)
+	go func() {
+		for {
+			msg, err := stream.Recv()
+			if err != nil {
+				recvErr <- err
+				return
+			}
+			select {
+			case messages <- msg:
+			case <-stream.Context().Done():
+				return
+			}
+		}
+	}()
+
+	timer := time.NewTimer(h.heartbeatTimeout)
+	defer timer.Stop()
+	for {
+		select {
+		case msg := <-messages:
+			timer.Reset(h.heartbeatTimeout)
+			h.handleMessage(agent, msg)
+		case err := <-recvErr:
+			h.logr.Info("Agent %s disconnected: %v", agent.info.ID, err)
+			h.disconnect(agent, false)
+			return nil
+		case <-timer.C:
+			h.logr.Error("Agent %s missed its heartbeats", agent.info.ID)
+			h.disconnect(agent, true)
+			return status.Error(codes.DeadlineExceeded, "heartbeat timeout")
+		}
+	}
+}
+
+func (h *AgentHub) handleMessage(agent *agentConn, msg *AgentMessage) {
+	h.mu.Lock()
+	agent.info.LastSeen = time.Now()
+	info := agent.info
+	var ch chan *TaskResponse
+	var ok bool
+	if msg.Result != nil {
+		ch, ok = agent.pending[msg.Result.TaskId]
+		delete(agent.pending, msg.Result.TaskId)
+	}
+	h.mu.Unlock()
+
+	if msg.Heartbeat && h.observer != nil {
+		h.observer.AgentHeartbeat(info)
+	}
+	if msg.Result == nil {
+		return
+	}
+	if !ok {
+		h.logr.Error("Agent %s sent a result for unknown task %d", agent.info.ID, msg.Result.TaskId)
+		return
+	}
+	msg.Result.AgentId = agent.info.ID
+	ch <- msg.Result
+	if h.observer != nil {
+		h.observer.TaskCompleted(agent.info.ID)
+	}
+}
+
+func (h *AgentHub) disconnect(agent *agentConn, dead bool) {
+	h.mu.Lock()
+	delete(h.agents, agent.info.ID)
+	for taskID, ch := range agent.pending {
+		close(ch)
+		delete(agent.pending, taskID)
+	}
+	h.notifyLocked()
+	h.mu.Unlock()
+	if h.observer != nil {
+		h.observer.AgentLeft(agent.info.ID, dead)
+	}
+}
+
+// notifyLocked wakes up callers waiting for a free agent.
+func (h *AgentHub) notifyLocked() {
+	close(h.changed)
+	h.changed = make(chan struct{})
+}
+
+// CalculateTask sends req to the least loaded agent with free capacity,
+// waiting for one if all of them are busy, and returns its result.
+func (h *AgentHub) CalculateTask(ctx context.Context, req *TaskRequest) (*TaskResponse, error) {
+	agent, ch, err := h.acquire(ctx, req.TaskId, req.Operator.Symbol())
+	if err != nil {
+		return nil, err
+	}
+	defer h.release(agent, req.TaskId)
+
+	agent.sendMu.Lock()
+	err = agent.stream.Send(req)
+	agent.sendMu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	select {
+	case resp, ok := <-ch:
+		if !ok {
+			return nil, errAgentDisconnected
+		}
+		return resp, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (h *AgentHub) acquire(ctx context.Context, taskID int64, op string) (*agentConn, chan *TaskResponse, error) {
+	for {
+		h.mu.Lock()
+		var best *agentConn
+		for _, agent := range h.agents {
+			if agent.info.InFlight >= agent.info.ComputingPower || !agent.info.Supports(op) {
+				continue
+			}
+			if best == nil || agent.info.InFlight*best.info.ComputingPower < best.info.InFlight*agent.info.ComputingPower {
+				best = agent
+			}
+		}
+		if best != nil {
+			ch := make(chan *TaskResponse, 1)
+			best.pending[taskID] = ch
+			best.info.InFlight++
+			h.mu.Unlock()
+			return best, ch, nil
+		}
+		changed := h.changed
+		h.mu.Unlock()
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return nil, nil, ctx.Err()
+		}
+	}
+}
+
+func (h *AgentHub) release(agent *agentConn, taskID int64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(agent.pending, taskID)
+	agent.info.InFlight--
+	h.notifyLocked()
+}
+
+// Agents lists the agents that are currently connected.
+func (h *AgentHub) Agents() []AgentInfo {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	agents := make([]AgentInfo, 0, len(h.agents))
+	for _, agent := range h.agents {
+		agents = append(agents, agent.info)
+	}
+	return agents
+}
@@ -0,0 +1,170 @@
+package grpc
+
+import (
+	"DistributedCalc/pkg/logger"
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+func startHub(t *testing.T) (*AgentHub, string) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	hub := NewAgentHub(logger.NewLogger())
+	server := grpc.NewServer()
+	RegisterTaskChannelServer(server, hub)
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+	return hub, listener.Addr().String()
+}
+
+func TestAgentHub_CalculateTask(t *testing.T) {
+	t.Setenv("TIME_ADDITION_MS", "20")
+	hub, addr := startHub(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go NewAgent("agent-1", 2, logger.NewLogger()).Run(ctx, addr)
+
+	var wg sync.WaitGroup
+	for i := 1; i <= 5; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			taskCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+			defer cancel()
+			resp, err := hub.CalculateTask(taskCtx, NewTaskRequest(int64(i), 1, "+", []float64{float64(i), 0.5}))
+			if err != nil {
+				t.Errorf("Task %d failed: %v", i, err)
+				return
+			}
+			if resp.TaskId != int64(i) || resp.Result != float64(i)+0.5 {
+				t.Errorf("Expected %f for task %d, got %+v", float64(i)+0.5, i, resp)
+			}
+			if agents := hub.Agents(); len(agents) != 1 || agents[0].InFlight > 2 {
+				t.Errorf("Expected one agent with at most 2 tasks in flight, got %+v", agents)
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestAgentHub_AgentDisconnects(t *testing.T) {
+	t.Setenv("TIME_MULTIPLICATIONS_MS", "5000")
+	hub, addr := startHub(t)
+
+	agentCtx, stopAgent := context.WithCancel(context.Background())
+	go NewAgent("agent-1", 1, logger.NewLogger()).Run(agentCtx, addr)
+
+	go func() {
+		for len(hub.Agents()) == 0 || hub.Agents()[0].InFlight == 0 {
+			time.Sleep(10 * time.Millisecond)
+		}
+		stopAgent()
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	_, err := hub.CalculateTask(ctx, NewTaskRequest(1, 1, "*", []float64{2, 3}))
+	if err != errAgentDisconnected {
+		t.Errorf("Expected %v, got %v", errAgentDisconnected, err)
+	}
+	if agents := hub.Agents(); len(agents) != 0 {
+		t.Errorf("Expected no connected agents, got %+v", agents)
+	}
+}
+
+type observerMock struct {
+	mu         sync.Mutex
+	registered []AgentInfo
+	heartbeats int
+	completed  int
+	left       map[string]bool
+}
+
+func (o *observerMock) AgentRegistered(info AgentInfo) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.registered = append(o.registered, info)
+}
+
+func (o *observerMock) AgentHeartbeat(info AgentInfo) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.heartbeats++
+}
+
+func (o *observerMock) TaskCompleted(agentID string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.completed++
+}
+
+func (o *observerMock) AgentLeft(agentID string, dead bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.left[agentID] = dead
+}
+
+func TestAgentHub_Heartbeats(t *testing.T) {
+	t.Setenv("TIME_ADDITION_MS", "1")
+	hub, addr := startHub(t)
+	observer := &observerMock{left: make(map[string]bool)}
+	hub.SetObserver(observer)
+	hub.SetHeartbeatTimeout(200 * time.Millisecond)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	agent := NewAgent("agent-1", 1, logger.NewLogger())
+	agent.SetCapabilities([]string{"fast"})
+	agent.SetHeartbeatInterval(50 * time.Millisecond)
+	go agent.Run(ctx, addr)
+
+	conn, err := grpc.NewClient(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	defer conn.Close()
+	silent, err := NewTaskChannelClient(conn).Connect(ctx)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	silent.Send(&AgentMessage{AgentId: "silent", ComputingPower: 1, Operators: []string{"*"}})
+
+	taskCtx, taskCancel := context.WithTimeout(ctx, 5*time.Second)
+	defer taskCancel()
+	// Only agent-1 can compute additions, so the silent agent gets nothing.
+	if _, err := hub.CalculateTask(taskCtx, NewTaskRequest(1, 1, "+", []float64{1, 2})); err != nil {
+		t.Fatalf("Task failed: %v", err)
+	}
+
+	time.Sleep(500 * time.Millisecond)
+	if _, err := silent.Recv(); err == nil {
+		t.Error("Expected the silent agent to be disconnected")
+	}
+
+	observer.mu.Lock()
+	defer observer.mu.Unlock()
+	if dead, ok := observer.left["silent"]; !ok || !dead {
+		t.Errorf("Expected the silent agent to be reported dead, got %v", observer.left)
+	}
+	if _, ok := observer.left["agent-1"]; ok {
+		t.Error("Expected agent-1 to stay connected")
+	}
+	if len(observer.registered) != 2 || observer.heartbeats == 0 || observer.completed != 1 {
+		t.Errorf("Unexpected observations: %+v", observer)
+	}
+	for _, info := range observer.registered {
+		if info.ID == "agent-1" && (len(info.Capabilities) != 1 || !info.Supports("sqrt")) {
+			t.Errorf("Expected agent-1 to register its capabilities and operators, got %+v", info)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package grpc
+
+import (
+	"DistributedCalc/internal/numeric"
+	"DistributedCalc/internal/operators"
+	"DistributedCalc/pkg/errors"
+	"net/http"
+)
+
+var operatorSymbols = map[Operator]string{
+	Operator_OPERATOR_ADD:            operators.Add,
+	Operator_OPERATOR_SUBTRACT:       operators.Subtract,
+	Operator_OPERATOR_MULTIPLY:       operators.Multiply,
+	Operator_OPERATOR_DIVIDE:         operators.Divide,
+	Operator_OPERATOR_INTEGER_DIVIDE: operators.IntegerDivide,
+	Operator_OPERATOR_MODULO:         operators.Modulo,
+	Operator_OPERATOR_POWER:          operators.Power,
+	Operator_OPERATOR_NEGATE:         operators.Negate,
+	Operator_OPERATOR_SQRT:           operators.Sqrt,
+	Operator_OPERATOR_ABS:            operators.Abs,
+	Operator_OPERATOR_MIN:            operators.Min,
+	Operator_OPERATOR_MAX:            operators.Max,
+	Operator_OPERATOR_LOG:            operators.Log,
+	Operator_OPERATOR_ROUND:          operators.Round,
+	Operator_OPERATOR_CONJ:           operators.Conj,
+	Operator_OPERATOR_RE:             operators.Re,
+	Operator_OPERATOR_IM:             operators.Im,
+	Operator_OPERATOR_ARG:            operators.Arg,
+}
+
+// OperatorFromSymbol returns the wire value of an operator or function name
+// from the operators table, or OPERATOR_UNSPECIFIED if there is none.
+func OperatorFromSymbol(symbol string) Operator {
+	for op, s := range operatorSymbols {
+		if s == symbol {
+			return op
+		}
+	}
+	return Operator_OPERATOR_UNSPECIFIED
+}
+
+// Symbol is the name of the operator in the operators table.
+func (x Operator) Symbol() string {
+	return operatorSymbols[x]
+}
+
+// NewTaskRequest builds a v2 request for op applied to args. Arg1 and Arg2
+// are filled for unary and binary operators, Args for everything else.
+func NewTaskRequest(taskID, exprID int64, op string, args []float64) *TaskRequest {
+	req := &TaskRequest{TaskId: taskID, ExpressionId: exprID, Operator: OperatorFromSymbol(op)}
+	operator, _ := operators.Lookup(op)
+	switch {
+	case !operator.Function && len(args) == 1:
+		req.Arg1 = args[0]
+	case !operator.Function && len(args) == 2:
+		req.Arg1, req.Arg2 = args[0], args[1]
+	default:
+		req.Args = args
+	}
+	return req
+}
+
+// NewExactTaskRequest is NewTaskRequest for operands in text form. They are
+// sent as they are, with the precision to compute them in, and as float64
+// for agents that only read the typed operands.
+func NewExactTaskRequest(taskID, exprID int64, op string, operands []string, precision numeric.Precision) *TaskRequest {
+	args := make([]float64, len(operands))
+	for i, operand := range operands {
+		args[i] = numeric.Float(operand)
+	}
+	req := NewTaskRequest(taskID, exprID, op, args)
+	req.ExactOperands = operands
+	req.Precision = NewPrecision(precision)
+	return req
+}
+
+// Operands returns the arguments carried by the request.
+func (x *TaskRequest) Operands() []float64 {
+	if len(x.GetArgs()) > 0 {
+		return x.Args
+	}
+	operator, ok := operators.Lookup(x.GetOperator().Symbol())
+	if ok && operator.MaxArgs == 1 {
+		return []float64{x.GetArg1()}
+	}
+	return []float64{x.GetArg1(), x.GetArg2()}
+}
+
+// Values returns the arguments carried by the request in text form.
+func (x *TaskRequest) Values() []string {
+	if len(x.GetExactOperands()) > 0 {
+		return x.ExactOperands
+	}
+	return formatArgs(x.Operands())
+}
+
+func NewPrecision(p numeric.Precision) *Precision {
+	return &Precision{Mode: p.Mode, Scale: int32(p.Scale), Rounding: p.Rounding}
+}
+
+// Numeric converts the precision of a request; nil is float64.
+func (x *Precision) Numeric() numeric.Precision {
+	if x == nil {
+		return numeric.Precision{Mode: numeric.ModeFloat64}
+	}
+	return numeric.Precision{Mode: x.Mode, Scale: int(x.Scale), Rounding: x.Rounding}
+}
+
+func formatArgs(args []float64) []string {
+	values := make([]string, len(args))
+	for i, arg := range args {
+		values[i] = numeric.FormatFloat(arg)
+	}
+	return values
+}
+
+func errorCode(err error) ErrorCode {
+	if appErr, ok := err.(*errors.AppError); ok && appErr.Code == http.StatusBadRequest {
+		return ErrorCode_ERROR_CODE_INVALID_ARGUMENT
+	}
+	switch err.Error() {
+	case operators.NewDivisionByZeroError().Error(),
+		operators.NewIntegerDivisionByZeroError().Error(),
+		operators.NewModuloByZeroError().Error():
+		return ErrorCode_ERROR_CODE_DIVISION_BY_ZERO
+	}
+	return ErrorCode_ERROR_CODE_DOMAIN_ERROR
+}
@@ -1,83 +1,121 @@
-package grpc
-
-import (
-	"DistributedCalc/pkg/logger"
-	"context"
-	"os"
-	"strconv"
-	"strings"
-	"time"
-)
-
-type Server struct {
-	UnimplementedCalcServiceServer
-	logr *logger.Logger
-}
-
-func NewServer(logr *logger.Logger) *Server {
-	return &Server{logr: logr}
-}
-
-func (s *Server) Calculate(ctx context.Context, req *CalcRequest) (*CalcResponse, error) {
-	s.logr.Info("Received gRPC request: %s", req.Expression)
-	tokens := strings.Split(req.Expression, "")
-	if len(tokens) != 3 {
-		s.logr.Error("Invalid gRPC expression format: %s", req.Expression)
-		return &CalcResponse{Error: "invalid expression"}, nil
-	}
-
-	arg1, err := strconv.ParseFloat(tokens[0], 64)
-	if err != nil {
-		s.logr.Error("Invalid first argument: %s", tokens[0])
-		return &CalcResponse{Error: "invalid argument"}, nil
-	}
-	op := tokens[1]
-	arg2, err := strconv.ParseFloat(tokens[2], 64)
-	if err != nil {
-		s.logr.Error("Invalid second argument: %s", tokens[2])
-		return &CalcResponse{Error: "invalid argument"}, nil
-	}
-
-	var operationTime time.Duration
-	switch op {
-	case "+":
-		operationTime = getOperationTime("TIME_ADDITION_MS")
-	case "-":
-		operationTime = getOperationTime("TIME_SUBTRACTION_MS")
-	case "*":
-		operationTime = getOperationTime("TIME_MULTIPLICATIONS_MS")
-	case "/":
-		operationTime = getOperationTime("TIME_DIVISIONS_MS")
-	default:
-		s.logr.Error("Invalid operator: %s", op)
-		return &CalcResponse{Error: "invalid operator"}, nil
-	}
-
-	time.Sleep(operationTime)
-
-	switch op {
-	case "+":
-		return &CalcResponse{Result: arg1 + arg2}, nil
-	case "-":
-		return &CalcResponse{Result: arg1 - arg2}, nil
-	case "*":
-		return &CalcResponse{Result: arg1 * arg2}, nil
-	case "/":
-		if arg2 == 0 {
-			s.logr.Error("Division by zero")
-			return &CalcResponse{Error: "division by zero"}, nil
-		}
-		return &CalcResponse{Result: arg1 / arg2}, nil
-	default:
-		s.logr.Error("Invalid operator: %s", op)
-		return &CalcResponse{Error: "invalid operator"}, nil
-	}
-}
-
-func getOperationTime(envVar string) time.Duration {
-	ms, _ := strconv.Atoi(os.Getenv(envVar))
-	if ms <= 0 {
-		ms = 100
-	}
-	return time.Duration(ms) * time.Millisecond
-}
+package grpc
+
+import (
+	"DistributedCalc/internal/numeric"
+	"DistributedCalc/internal/operators"
+	"DistributedCalc/pkg/logger"
+	"context"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Server struct {
+	UnimplementedCalcServiceServer
+	logr *logger.Logger
+}
+
+func NewServer(logr *logger.Logger) *Server {
+	return &Server{logr: logr}
+}
+
+// Calculate serves the original string based contract. New callers should
+// use CalculateTask.
+func (s *Server) Calculate(ctx context.Context, req *CalcRequest) (*CalcResponse, error) {
+	s.logr.Info("Received gRPC request: %s", req.Expression)
+	op, args := req.Operator, req.Args
+	if op == "" {
+		var ok bool
+		op, args, ok = parseLegacyExpression(req.Expression)
+		if !ok {
+			s.logr.Error("Invalid gRPC expression format: %s", req.Expression)
+			return &CalcResponse{Error: "invalid expression"}, nil
+		}
+	}
+	operands := req.ExactOperands
+	if len(operands) == 0 {
+		operands = formatArgs(args)
+	}
+
+	value, _, err := s.compute(ctx, op, operands, req.Precision.Numeric(), time.Time{})
+	if err != nil {
+		s.logr.Error("Failed to compute %s: %v", req.Expression, err)
+		return &CalcResponse{Error: err.Error()}, nil
+	}
+	return &CalcResponse{Result: numeric.Float(value), Value: value}, nil
+}
+
+// CalculateTask computes a single task from typed operands. Failures are
+// reported through the error code of the response, not as gRPC errors.
+func (s *Server) CalculateTask(ctx context.Context, req *TaskRequest) (*TaskResponse, error) {
+	s.logr.Info("Received gRPC task %d of expression %d: %s", req.TaskId, req.ExpressionId, req.Operator)
+	var deadline time.Time
+	if req.DeadlineUnixMs > 0 {
+		deadline = time.UnixMilli(req.DeadlineUnixMs)
+	}
+
+	value, code, err := s.compute(ctx, req.Operator.Symbol(), req.Values(), req.Precision.Numeric(), deadline)
+	if err != nil {
+		s.logr.Error("Failed to compute task %d: %v", req.TaskId, err)
+		return &TaskResponse{TaskId: req.TaskId, ErrorCode: code, Error: err.Error()}, nil
+	}
+	return &TaskResponse{TaskId: req.TaskId, Result: numeric.Float(value), Value: value}, nil
+}
+
+func (s *Server) compute(ctx context.Context, op string, operands []string, precision numeric.Precision, deadline time.Time) (string, ErrorCode, error) {
+	operator, ok := operators.Lookup(op)
+	if !ok {
+		return "", ErrorCode_ERROR_CODE_INVALID_OPERATOR, operators.NewInvalidOperatorError(op)
+	}
+	if !operator.AcceptsArgs(len(operands)) {
+		return "", ErrorCode_ERROR_CODE_INVALID_ARGUMENT, operators.NewArgumentCountError(op, len(operands))
+	}
+	precision, err := precision.Normalize()
+	if err != nil {
+		return "", ErrorCode_ERROR_CODE_INVALID_ARGUMENT, err
+	}
+
+	if !deadline.IsZero() {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, deadline)
+		defer cancel()
+	}
+	if err := operator.Wait(ctx); err != nil {
+		return "", ErrorCode_ERROR_CODE_DEADLINE_EXCEEDED, err
+	}
+
+	value, err := numeric.Apply(op, operands, precision)
+	if err != nil {
+		return "", errorCode(err), err
+	}
+	return value, ErrorCode_ERROR_CODE_NONE, nil
+}
+
+// parseLegacyExpression splits a task sent as text, such as "2.000000+3.000000"
+// or "(-2.000000)^(0.500000)", into its operator and operands.
+func parseLegacyExpression(expr string) (string, []float64, bool) {
+	expr = strings.ReplaceAll(expr, " ", "")
+	if strings.HasPrefix(expr, "-(") && strings.HasSuffix(expr, ")") {
+		arg, err := strconv.ParseFloat(expr[2:len(expr)-1], 64)
+		return operators.Negate, []float64{arg}, err == nil
+	}
+	// The operator follows the first operand, so start after its sign.
+	for i := 1; i < len(expr); i++ {
+		for _, op := range []string{operators.IntegerDivide, operators.Add, operators.Subtract, operators.Multiply, operators.Divide, operators.Modulo, operators.Power} {
+			if !strings.HasPrefix(expr[i:], op) || !endsOperand(expr[i-1]) {
+				continue
+			}
+			arg1, err1 := strconv.ParseFloat(strings.Trim(expr[:i], "()"), 64)
+			arg2, err2 := strconv.ParseFloat(strings.Trim(expr[i+len(op):], "()"), 64)
+			if err1 != nil || err2 != nil {
+				return "", nil, false
+			}
+			return op, []float64{arg1, arg2}, true
+		}
+	}
+	return "", nil, false
+}
+
+func endsOperand(ch byte) bool {
+	return ch >= '0' && ch <= '9' || ch == '.' || ch == ')'
+}
@@ -0,0 +1,146 @@
+package grpc
+
+import (
+	"DistributedCalc/internal/numeric"
+	"DistributedCalc/pkg/logger"
+	"context"
+	"testing"
+	"time"
+)
+
+func TestServer_CalculateTask(t *testing.T) {
+	t.Setenv("TIME_ADDITION_MS", "1")
+	t.Setenv("TIME_DIVISIONS_MS", "1")
+	t.Setenv("TIME_NEGATION_MS", "1")
+	t.Setenv("TIME_MAX_MS", "1")
+	t.Setenv("TIME_SQRT_MS", "1")
+	t.Setenv("TIME_MULTIPLICATIONS_MS", "1000")
+	server := NewServer(logger.NewLogger())
+
+	tests := []struct {
+		name     string
+		req      *TaskRequest
+		expected float64
+		value    string
+		code     ErrorCode
+	}{
+		{
+			name:     "Binary operator",
+			req:      NewTaskRequest(1, 1, "+", []float64{-2.5, 0.125}),
+			expected: -2.375,
+		},
+		{
+			name:     "Unary operator",
+			req:      NewTaskRequest(2, 1, "neg", []float64{3}),
+			expected: -3,
+		},
+		{
+			name:     "Function",
+			req:      NewTaskRequest(3, 1, "max", []float64{1, 5, 3}),
+			expected: 5,
+		},
+		{
+			name: "Division by zero",
+			req:  NewTaskRequest(4, 1, "/", []float64{1, 0}),
+			code: ErrorCode_ERROR_CODE_DIVISION_BY_ZERO,
+		},
+		{
+			name: "Domain error",
+			req:  NewTaskRequest(5, 1, "sqrt", []float64{-1}),
+			code: ErrorCode_ERROR_CODE_DOMAIN_ERROR,
+		},
+		{
+			name: "Unspecified operator",
+			req:  &TaskRequest{TaskId: 6, Arg1: 1, Arg2: 2},
+			code: ErrorCode_ERROR_CODE_INVALID_OPERATOR,
+		},
+		{
+			name: "Deadline exceeded",
+			req: &TaskRequest{TaskId: 7, Operator: Operator_OPERATOR_MULTIPLY, Arg1: 2, Arg2: 3,
+				DeadlineUnixMs: time.Now().Add(10 * time.Millisecond).UnixMilli()},
+			code: ErrorCode_ERROR_CODE_DEADLINE_EXCEEDED,
+		},
+		{
+			name:     "Decimal operands",
+			req:      NewExactTaskRequest(8, 1, "+", []string{"0.1", "0.2"}, numeric.Precision{Mode: numeric.ModeDecimal, Scale: 10}),
+			expected: 0.3,
+			value:    "0.3",
+		},
+		{
+			name: "Invalid decimal operand",
+			req:  NewExactTaskRequest(9, 1, "+", []string{"1", "one"}, numeric.Precision{Mode: numeric.ModeDecimal, Scale: 10}),
+			code: ErrorCode_ERROR_CODE_INVALID_ARGUMENT,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := server.CalculateTask(context.Background(), tt.req)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if resp.TaskId != tt.req.TaskId {
+				t.Errorf("Expected task ID %d, got %d", tt.req.TaskId, resp.TaskId)
+			}
+			if resp.ErrorCode != tt.code {
+				t.Errorf("Expected error code %s, got %s (%s)", tt.code, resp.ErrorCode, resp.Error)
+			}
+			if tt.code == ErrorCode_ERROR_CODE_NONE && resp.Result != tt.expected {
+				t.Errorf("Expected %f, got %f", tt.expected, resp.Result)
+			}
+			if tt.value != "" && resp.Value != tt.value {
+				t.Errorf("Expected value %s, got %s", tt.value, resp.Value)
+			}
+		})
+	}
+}
+
+func TestServer_CalculateLegacy(t *testing.T) {
+	t.Setenv("TIME_SUBTRACTION_MS", "1")
+	t.Setenv("TIME_INTEGER_DIVISIONS_MS", "1")
+	t.Setenv("TIME_NEGATION_MS", "1")
+	server := NewServer(logger.NewLogger())
+
+	tests := []struct {
+		name     string
+		expr     string
+		expected float64
+		err      string
+	}{
+		{
+			name:     "Formatted floats",
+			expr:     "2.500000-3.000000",
+			expected: -0.5,
+		},
+		{
+			name:     "Negative operands",
+			expr:     "(-7.000000)//(2.000000)",
+			expected: -4,
+		},
+		{
+			name:     "Negation",
+			expr:     "-(1.500000)",
+			expected: -1.5,
+		},
+		{
+			name: "Malformed",
+			expr: "2+",
+			err:  "invalid expression",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := server.Calculate(context.Background(), &CalcRequest{Expression: tt.expr})
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if resp.Error != tt.err {
+				t.Errorf("Expected error %q, got %q", tt.err, resp.Error)
+			}
+			if tt.err == "" && resp.Result != tt.expected {
+				t.Errorf("Expected %f, got %f", tt.expected, resp.Result)
+			}
+		})
+	}
+}
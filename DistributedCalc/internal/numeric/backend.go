@@ -0,0 +1,86 @@
+package numeric
+
+import "DistributedCalc/internal/operators"
+
+// Backend computes operators on one type of number. Operands and results
+// cross it in text form, so the rest of the system, from storage to the
+// agents, does not depend on the type.
+type Backend interface {
+	// Apply applies an operator or built-in function to operands.
+	Apply(op string, operands []string) (string, error)
+	// Format writes value the way the backend writes its results, or fails
+	// if value is not a number of the backend's type.
+	Format(value string) (string, error)
+}
+
+// NewBackend returns the backend of a normalized precision.
+func NewBackend(p Precision) Backend {
+	switch p.Mode {
+	case ModeDecimal:
+		return newDecimal(p)
+	case ModeRational:
+		return rational
+	case ModeBigInt:
+		return bigInt
+	case ModeComplex:
+		return complexNumbers
+	default:
+		return float
+	}
+}
+
+// backend implements Backend for numbers of type T. ops holds the one
+// implementation of every operator the type supports; the others are
+// rejected.
+type backend[T any] struct {
+	mode   string
+	parse  func(value string) (T, error)
+	format func(value T) string
+	ops    map[string]func(args []T) (T, error)
+}
+
+func (b backend[T]) Apply(op string, operands []string) (string, error) {
+	apply, ok := b.ops[op]
+	if !ok {
+		return "", NewUnsupportedOperatorError(op, b.mode)
+	}
+	args := make([]T, len(operands))
+	for i, operand := range operands {
+		arg, err := b.parse(operand)
+		if err != nil {
+			return "", err
+		}
+		args[i] = arg
+	}
+	result, err := apply(args)
+	if err != nil {
+		return "", err
+	}
+	return b.format(result), nil
+}
+
+func (b backend[T]) Format(value string) (string, error) {
+	v, err := b.parse(value)
+	if err != nil {
+		return "", err
+	}
+	return b.format(v), nil
+}
+
+// float computes in float64 with the implementations of the operators
+// table.
+var float = backend[float64]{
+	mode:   ModeFloat64,
+	parse:  parseFloat,
+	format: FormatFloat,
+	ops:    floatOps(),
+}
+
+func floatOps() map[string]func(args []float64) (float64, error) {
+	ops := make(map[string]func(args []float64) (float64, error))
+	for _, symbol := range operators.Symbols() {
+		operator, _ := operators.Lookup(symbol)
+		ops[symbol] = operator.Apply
+	}
+	return ops
+}
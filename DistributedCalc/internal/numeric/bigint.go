@@ -0,0 +1,139 @@
+package numeric
+
+import (
+	"DistributedCalc/internal/operators"
+	"math/big"
+)
+
+// bigInt computes with integers of any size. Operands must be integers, and
+// operators whose result would not be one, like 7/2, fail instead of
+// truncating; integer division is //.
+var bigInt = backend[*big.Int]{
+	mode:   ModeBigInt,
+	parse:  parseInt,
+	format: (*big.Int).String,
+	ops: map[string]func(args []*big.Int) (*big.Int, error){
+		operators.Add: func(args []*big.Int) (*big.Int, error) {
+			return new(big.Int).Add(args[0], args[1]), nil
+		},
+		operators.Subtract: func(args []*big.Int) (*big.Int, error) {
+			return new(big.Int).Sub(args[0], args[1]), nil
+		},
+		operators.Multiply: func(args []*big.Int) (*big.Int, error) {
+			return new(big.Int).Mul(args[0], args[1]), nil
+		},
+		operators.Divide: func(args []*big.Int) (*big.Int, error) {
+			if args[1].Sign() == 0 {
+				return nil, operators.NewDivisionByZeroError()
+			}
+			q, m := floorDivMod(args[0], args[1])
+			if m.Sign() != 0 {
+				return nil, NewInexactResultError(operators.Divide, ModeBigInt)
+			}
+			return q, nil
+		},
+		operators.IntegerDivide: func(args []*big.Int) (*big.Int, error) {
+			if args[1].Sign() == 0 {
+				return nil, operators.NewIntegerDivisionByZeroError()
+			}
+			q, _ := floorDivMod(args[0], args[1])
+			return q, nil
+		},
+		operators.Modulo: func(args []*big.Int) (*big.Int, error) {
+			if args[1].Sign() == 0 {
+				return nil, operators.NewModuloByZeroError()
+			}
+			_, m := floorDivMod(args[0], args[1])
+			return m, nil
+		},
+		operators.Power: func(args []*big.Int) (*big.Int, error) {
+			base, exp := args[0], args[1]
+			if exp.Sign() < 0 {
+				if base.Sign() == 0 {
+					return nil, operators.NewZeroToNegativePowerError()
+				}
+				return nil, NewInexactResultError(operators.Power, ModeBigInt)
+			}
+			if !exp.IsInt64() || exp.Int64() > maxExponent {
+				return nil, NewExponentTooLargeError(maxExponent)
+			}
+			if err := checkPowerSize(base.BitLen(), exp.Int64()); err != nil {
+				return nil, err
+			}
+			return new(big.Int).Exp(base, exp, nil), nil
+		},
+		operators.Negate: func(args []*big.Int) (*big.Int, error) {
+			return new(big.Int).Neg(args[0]), nil
+		},
+		operators.Sqrt: func(args []*big.Int) (*big.Int, error) {
+			if args[0].Sign() < 0 {
+				return nil, operators.NewNegativeSqrtError()
+			}
+			root, ok := sqrtInt(args[0])
+			if !ok {
+				return nil, NewInexactResultError(operators.Sqrt, ModeBigInt)
+			}
+			return root, nil
+		},
+		operators.Abs: func(args []*big.Int) (*big.Int, error) {
+			return new(big.Int).Abs(args[0]), nil
+		},
+		operators.Min: func(args []*big.Int) (*big.Int, error) {
+			result := args[0]
+			for _, arg := range args[1:] {
+				if arg.Cmp(result) < 0 {
+					result = arg
+				}
+			}
+			return result, nil
+		},
+		operators.Max: func(args []*big.Int) (*big.Int, error) {
+			result := args[0]
+			for _, arg := range args[1:] {
+				if arg.Cmp(result) > 0 {
+					result = arg
+				}
+			}
+			return result, nil
+		},
+		// round only changes integers when rounding to tens, hundreds and
+		// so on, with a negative number of digits.
+		operators.Round: func(args []*big.Int) (*big.Int, error) {
+			rats := make([]*big.Rat, len(args))
+			for i, arg := range args {
+				rats[i] = new(big.Rat).SetInt(arg)
+			}
+			r, err := roundRat(rats, RoundHalfUp)
+			if err != nil {
+				return nil, err
+			}
+			return new(big.Int).Set(r.Num()), nil
+		},
+	},
+}
+
+// parseInt reads an integer. Integers written with an exponent, as float64
+// results are, are accepted too.
+func parseInt(value string) (*big.Int, error) {
+	if n, ok := new(big.Int).SetString(value, 10); ok {
+		return n, nil
+	}
+	if r, ok := new(big.Rat).SetString(value); ok && r.IsInt() {
+		return new(big.Int).Set(r.Num()), nil
+	}
+	if _, err := parseRat(value); err != nil {
+		return nil, err
+	}
+	return nil, NewNotIntegerError(value)
+}
+
+// floorDivMod divides a by b rounding the quotient down, so the remainder
+// takes the sign of b, as with the other backends.
+func floorDivMod(a, b *big.Int) (*big.Int, *big.Int) {
+	q, m := new(big.Int).QuoRem(a, b, new(big.Int))
+	if m.Sign() != 0 && m.Sign() != b.Sign() {
+		q.Sub(q, big.NewInt(1))
+		m.Add(m, b)
+	}
+	return q, m
+}
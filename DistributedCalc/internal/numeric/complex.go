@@ -0,0 +1,183 @@
+package numeric
+
+import (
+	"DistributedCalc/internal/operators"
+	"math"
+	"math/cmplx"
+	"strconv"
+	"strings"
+)
+
+// complexNumbers computes in complex128. Results are written like 3+4i, or
+// like a float64 when they are real. Operators that need an order, such as
+// max or %, only accept real operands.
+var complexNumbers = backend[complex128]{
+	mode:   ModeComplex,
+	parse:  parseComplex,
+	format: FormatComplex,
+	ops: finiteOps(withOps(realOps(), map[string]func(args []complex128) (complex128, error){
+		operators.Add: func(args []complex128) (complex128, error) {
+			return args[0] + args[1], nil
+		},
+		operators.Subtract: func(args []complex128) (complex128, error) {
+			return args[0] - args[1], nil
+		},
+		operators.Multiply: func(args []complex128) (complex128, error) {
+			return args[0] * args[1], nil
+		},
+		operators.Divide: func(args []complex128) (complex128, error) {
+			if args[1] == 0 {
+				return 0, operators.NewDivisionByZeroError()
+			}
+			return args[0] / args[1], nil
+		},
+		operators.Power: func(args []complex128) (complex128, error) {
+			a, b := args[0], args[1]
+			if a == 0 && real(b) < 0 {
+				return 0, operators.NewZeroToNegativePowerError()
+			}
+			// math.Pow and repeated multiplication are exact where
+			// cmplx.Pow rounds, as for 2^2 or i^2.
+			if isReal(a) && isReal(b) && (real(a) >= 0 || real(b) == math.Trunc(real(b))) {
+				return complex(math.Pow(real(a), real(b)), 0), nil
+			}
+			if isReal(b) && real(b) == math.Trunc(real(b)) && math.Abs(real(b)) <= maxExponent {
+				return powInt(a, int(real(b))), nil
+			}
+			return cmplx.Pow(a, b), nil
+		},
+		operators.Negate: func(args []complex128) (complex128, error) {
+			return -args[0], nil
+		},
+		// The square root of a negative number is imaginary.
+		operators.Sqrt: func(args []complex128) (complex128, error) {
+			if isReal(args[0]) && real(args[0]) >= 0 {
+				return complex(math.Sqrt(real(args[0])), 0), nil
+			}
+			return cmplx.Sqrt(args[0]), nil
+		},
+		operators.Abs: func(args []complex128) (complex128, error) {
+			return complex(cmplx.Abs(args[0]), 0), nil
+		},
+		// log is the principal logarithm, which exists for every number
+		// but zero.
+		operators.Log: func(args []complex128) (complex128, error) {
+			if args[0] == 0 {
+				return 0, operators.NewNonPositiveLogError()
+			}
+			result := logComplex(args[0])
+			if len(args) == 1 {
+				return result, nil
+			}
+			if args[1] == 0 || args[1] == 1 {
+				return 0, operators.NewInvalidLogBaseError()
+			}
+			return result / logComplex(args[1]), nil
+		},
+		operators.Conj: func(args []complex128) (complex128, error) {
+			return cmplx.Conj(args[0]), nil
+		},
+		operators.Re: func(args []complex128) (complex128, error) {
+			return complex(real(args[0]), 0), nil
+		},
+		operators.Im: func(args []complex128) (complex128, error) {
+			return complex(imag(args[0]), 0), nil
+		},
+		operators.Arg: func(args []complex128) (complex128, error) {
+			return complex(cmplx.Phase(args[0]), 0), nil
+		},
+	})),
+}
+
+// finiteOps rejects results whose real or imaginary part overflows, as the
+// operators table does for float64.
+func finiteOps(ops map[string]func(args []complex128) (complex128, error)) map[string]func(args []complex128) (complex128, error) {
+	for symbol, apply := range ops {
+		apply := apply
+		ops[symbol] = func(args []complex128) (complex128, error) {
+			result, err := apply(args)
+			if err == nil && (cmplx.IsInf(result) || cmplx.IsNaN(result)) {
+				return 0, operators.NewOverflowError()
+			}
+			return result, err
+		}
+	}
+	return ops
+}
+
+// realOps applies the float64 implementation of every operator to the real
+// parts of operands that have no imaginary part.
+func realOps() map[string]func(args []complex128) (complex128, error) {
+	ops := make(map[string]func(args []complex128) (complex128, error))
+	for symbol, apply := range floatOps() {
+		symbol, apply := symbol, apply
+		ops[symbol] = func(args []complex128) (complex128, error) {
+			reals := make([]float64, len(args))
+			for i, arg := range args {
+				if !isReal(arg) {
+					return 0, NewNotRealError(symbol)
+				}
+				reals[i] = real(arg)
+			}
+			result, err := apply(reals)
+			return complex(result, 0), err
+		}
+	}
+	return ops
+}
+
+// powInt raises c to the integer power n by squaring.
+func powInt(c complex128, n int) complex128 {
+	if n < 0 {
+		return 1 / powInt(c, -n)
+	}
+	result := complex128(1)
+	for ; n > 0; n >>= 1 {
+		if n&1 == 1 {
+			result *= c
+		}
+		c *= c
+	}
+	return result
+}
+
+func logComplex(c complex128) complex128 {
+	if isReal(c) && real(c) > 0 {
+		return complex(math.Log(real(c)), 0)
+	}
+	return cmplx.Log(c)
+}
+
+func isReal(c complex128) bool {
+	return imag(c) == 0
+}
+
+func parseComplex(value string) (complex128, error) {
+	c, err := strconv.ParseComplex(value, 128)
+	if err != nil {
+		return 0, NewInvalidNumberError(value)
+	}
+	return c, nil
+}
+
+// FormatComplex writes c like 3+4i, -2i or, if c is real, 3.
+func FormatComplex(c complex128) string {
+	re, im := real(c), imag(c)
+	if im == 0 {
+		return FormatFloat(re)
+	}
+	if re == 0 {
+		return FormatFloat(im) + "i"
+	}
+	sign := "+"
+	if math.Signbit(im) || math.IsInf(im, 1) {
+		sign = ""
+	}
+	return FormatFloat(re) + sign + FormatFloat(im) + "i"
+}
+
+// IsImaginary reports whether value, a number in text form, has an
+// imaginary part, which only complex precision can compute with.
+func IsImaginary(value string) bool {
+	return strings.HasSuffix(value, "i")
+}
@@ -0,0 +1,133 @@
+package numeric
+
+import (
+	"DistributedCalc/internal/operators"
+	"math/big"
+	"strings"
+)
+
+// newDecimal returns the backend of a decimal precision. It computes with
+// exact fractions and rounds every result to the scale of p. Operators that
+// are not exact, such as log, and powers with a fractional exponent are
+// computed in float64 and only then rounded.
+func newDecimal(p Precision) Backend {
+	return backend[*big.Rat]{
+		mode:  ModeDecimal,
+		parse: parseRat,
+		format: func(r *big.Rat) string {
+			return formatDecimal(roundDecimal(r, p.Scale, p.Rounding), p.Scale)
+		},
+		ops: withOps(ratOps, decimalOps(p)),
+	}
+}
+
+func decimalOps(p Precision) map[string]func(args []*big.Rat) (*big.Rat, error) {
+	ops := map[string]func(args []*big.Rat) (*big.Rat, error){
+		operators.Power: func(args []*big.Rat) (*big.Rat, error) {
+			if !args[1].IsInt() {
+				if args[0].Sign() == 0 && args[1].Sign() < 0 {
+					return nil, operators.NewZeroToNegativePowerError()
+				}
+				return approximate(operators.Power, args)
+			}
+			return powRat(args[0], args[1])
+		},
+		operators.Sqrt: func(args []*big.Rat) (*big.Rat, error) {
+			if args[0].Sign() < 0 {
+				return nil, operators.NewNegativeSqrtError()
+			}
+			// A few more bits than the scale needs keep the rounding correct.
+			prec := uint(p.Scale)*4 + 64
+			root := new(big.Float).SetPrec(prec).Sqrt(new(big.Float).SetPrec(prec).SetRat(args[0]))
+			result, _ := root.Rat(nil)
+			return result, nil
+		},
+		// round uses the rounding mode of the precision instead of always
+		// rounding half away from zero.
+		operators.Round: func(args []*big.Rat) (*big.Rat, error) {
+			return roundRat(args, p.Rounding)
+		},
+	}
+	for _, symbol := range operators.Symbols() {
+		if _, ok := ratOps[symbol]; !ok && ops[symbol] == nil {
+			symbol := symbol
+			ops[symbol] = func(args []*big.Rat) (*big.Rat, error) {
+				return approximate(symbol, args)
+			}
+		}
+	}
+	return ops
+}
+
+// approximate computes op in float64.
+func approximate(op string, args []*big.Rat) (*big.Rat, error) {
+	operator, _ := operators.Lookup(op)
+	floats := make([]float64, len(args))
+	for i, arg := range args {
+		floats[i], _ = arg.Float64()
+	}
+	result, err := operator.Apply(floats)
+	if err != nil {
+		return nil, err
+	}
+	r := new(big.Rat).SetFloat64(result)
+	if r == nil {
+		return nil, NewNotFiniteError()
+	}
+	return r, nil
+}
+
+// roundDecimal rounds r to scale decimal places; a negative scale rounds to
+// tens, hundreds and so on.
+func roundDecimal(r *big.Rat, scale int, rounding string) *big.Rat {
+	unit := new(big.Rat).SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(abs(int64(scale))), nil))
+	scaled := new(big.Rat).Set(r)
+	if scale >= 0 {
+		scaled.Mul(scaled, unit)
+	} else {
+		scaled.Quo(scaled, unit)
+	}
+
+	q, m := new(big.Int).QuoRem(scaled.Num(), scaled.Denom(), new(big.Int))
+	if m.Sign() != 0 {
+		// Compare the dropped fraction with one half.
+		half := new(big.Int).Lsh(new(big.Int).Abs(m), 1).Cmp(scaled.Denom())
+		var away bool
+		switch rounding {
+		case RoundUp:
+			away = true
+		case RoundDown:
+			away = false
+		case RoundFloor:
+			away = r.Sign() < 0
+		case RoundCeiling:
+			away = r.Sign() > 0
+		case RoundHalfEven:
+			away = half > 0 || half == 0 && q.Bit(0) == 1
+		default:
+			away = half >= 0
+		}
+		if away {
+			q.Add(q, big.NewInt(int64(r.Sign())))
+		}
+	}
+
+	result := new(big.Rat).SetInt(q)
+	if scale >= 0 {
+		return result.Quo(result, unit)
+	}
+	return result.Mul(result, unit)
+}
+
+// formatDecimal writes r, which has at most scale decimal places, without
+// trailing zeros.
+func formatDecimal(r *big.Rat, scale int) string {
+	if scale < 0 {
+		scale = 0
+	}
+	s := r.FloatString(scale)
+	if strings.Contains(s, ".") {
+		s = strings.TrimRight(strings.TrimRight(s, "0"), ".")
+	}
+	return s
+}
@@ -0,0 +1,55 @@
+package numeric
+
+import (
+	"DistributedCalc/pkg/errors"
+	"fmt"
+	"net/http"
+)
+
+func NewInvalidNumberError(value string) *errors.AppError {
+	return &errors.AppError{Code: http.StatusBadRequest, Message: fmt.Sprintf("invalid number: %s", value)}
+}
+
+func NewUnknownModeError(mode string) *errors.AppError {
+	return &errors.AppError{Code: http.StatusBadRequest, Message: fmt.Sprintf("unknown precision mode: %s", mode)}
+}
+
+func NewInvalidScaleError(max int) *errors.AppError {
+	return &errors.AppError{Code: http.StatusBadRequest, Message: fmt.Sprintf("precision scale must be between 0 and %d", max)}
+}
+
+func NewUnknownRoundingError(rounding string) *errors.AppError {
+	return &errors.AppError{Code: http.StatusBadRequest, Message: fmt.Sprintf("unknown rounding mode: %s", rounding)}
+}
+
+func NewExponentTooLargeError(max int) *errors.AppError {
+	return &errors.AppError{Code: http.StatusUnprocessableEntity, Message: fmt.Sprintf("exponent too large, at most %d in exact precision", max)}
+}
+
+func NewResultTooLargeError(maxBits int) *errors.AppError {
+	return &errors.AppError{Code: http.StatusUnprocessableEntity, Message: fmt.Sprintf("result too large, at most %d bits in exact precision", maxBits)}
+}
+
+func NewNotFiniteError() *errors.AppError {
+	return &errors.AppError{Code: http.StatusUnprocessableEntity, Message: "result is not a finite number"}
+}
+
+func NewNotIntegerError(value string) *errors.AppError {
+	return &errors.AppError{Code: http.StatusBadRequest, Message: fmt.Sprintf("not an integer: %s", value)}
+}
+
+func NewUnsupportedOperatorError(op, mode string) *errors.AppError {
+	return &errors.AppError{Code: http.StatusUnprocessableEntity, Message: fmt.Sprintf("operator %s is not supported in %s precision", op, mode)}
+}
+
+func NewInexactResultError(op, mode string) *errors.AppError {
+	return &errors.AppError{Code: http.StatusUnprocessableEntity, Message: fmt.Sprintf("result of %s is not exact in %s precision", op, mode)}
+}
+
+func NewImaginaryNumberError(value string) *errors.AppError {
+	return &errors.AppError{Code: http.StatusBadRequest, Message: fmt.Sprintf("imaginary number %s needs complex precision", value)}
+}
+
+func NewNotRealError(op string) *errors.AppError {
+	return &errors.AppError{Code: http.StatusUnprocessableEntity, Message: fmt.Sprintf("operator %s needs real operands", op)}
+}
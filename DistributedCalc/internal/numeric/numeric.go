@@ -0,0 +1,205 @@
+package numeric
+
+import (
+	"DistributedCalc/internal/operators"
+	"context"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"strconv"
+)
+
+// Precision modes, each computed by its own Backend. Float64 is the
+// default. Decimal computes exactly and rounds every result to Scale decimal
+// places. Rational keeps exact fractions, BigInt integers of any size and
+// Complex complex numbers of two float64.
+const (
+	ModeFloat64  = "float64"
+	ModeDecimal  = "decimal"
+	ModeRational = "rational"
+	ModeBigInt   = "bigint"
+	ModeComplex  = "complex"
+)
+
+// Rounding modes of decimal results.
+const (
+	RoundHalfUp   = "half_up"   // ties away from zero
+	RoundHalfEven = "half_even" // ties to the even neighbour
+	RoundDown     = "down"      // toward zero
+	RoundUp       = "up"        // away from zero
+	RoundFloor    = "floor"     // toward negative infinity
+	RoundCeiling  = "ceiling"   // toward positive infinity
+)
+
+var roundings = map[string]bool{
+	RoundHalfUp:   true,
+	RoundHalfEven: true,
+	RoundDown:     true,
+	RoundUp:       true,
+	RoundFloor:    true,
+	RoundCeiling:  true,
+}
+
+const (
+	DefaultScale    = 10
+	MaxScale        = 100
+	DefaultRounding = RoundHalfEven
+)
+
+// Precision is how the operations of an expression are computed. A decimal
+// Precision with neither Scale nor Rounding set is normalized to
+// DefaultScale; to round to whole numbers, set Rounding as well.
+type Precision struct {
+	Mode     string `json:"mode"`
+	Scale    int    `json:"scale"`
+	Rounding string `json:"rounding,omitempty"`
+}
+
+// UnmarshalJSON defaults the scale to DefaultScale when it is left out, so
+// that {"mode": "decimal"} is enough to compute exactly, and the rounding to
+// DefaultRounding, so that an explicit "scale": 0 is kept.
+func (p *Precision) UnmarshalJSON(data []byte) error {
+	var fields struct {
+		Mode     string `json:"mode"`
+		Scale    *int   `json:"scale"`
+		Rounding string `json:"rounding"`
+	}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+	*p = Precision{Mode: fields.Mode, Scale: DefaultScale, Rounding: fields.Rounding}
+	if fields.Scale != nil {
+		p.Scale = *fields.Scale
+	}
+	if p.Rounding == "" {
+		p.Rounding = DefaultRounding
+	}
+	return nil
+}
+
+// Normalize checks p and fills in its defaults. The zero Precision is
+// float64. Scale and rounding only apply to decimal; a decimal Precision
+// without a rounding gets DefaultScale in place of a zero scale.
+func (p Precision) Normalize() (Precision, error) {
+	switch p.Mode {
+	case "", ModeFloat64:
+		return Precision{Mode: ModeFloat64}, nil
+	case ModeRational, ModeBigInt, ModeComplex:
+		return Precision{Mode: p.Mode}, nil
+	case ModeDecimal:
+	default:
+		return Precision{}, NewUnknownModeError(p.Mode)
+	}
+	if p.Scale < 0 || p.Scale > MaxScale {
+		return Precision{}, NewInvalidScaleError(MaxScale)
+	}
+	if p.Rounding == "" {
+		if p.Scale == 0 {
+			p.Scale = DefaultScale
+		}
+		p.Rounding = DefaultRounding
+	}
+	if !roundings[p.Rounding] {
+		return Precision{}, NewUnknownRoundingError(p.Rounding)
+	}
+	return p, nil
+}
+
+// IsFloat64 reports whether p computes in float64, the only mode whose
+// results a float64 holds completely.
+func (p Precision) IsFloat64() bool {
+	return p.Mode == "" || p.Mode == ModeFloat64
+}
+
+// Compute waits for the operator's duration and applies it to operands, as
+// Apply does. It gives up with ctx's error if ctx is done first.
+func Compute(ctx context.Context, op string, operands []string, p Precision) (string, error) {
+	operator, ok := operators.Lookup(op)
+	if !ok {
+		return "", operators.NewInvalidOperatorError(op)
+	}
+	if !operator.AcceptsArgs(len(operands)) {
+		return "", operators.NewArgumentCountError(op, len(operands))
+	}
+	if err := operator.Wait(ctx); err != nil {
+		return "", err
+	}
+	return Apply(op, operands, p)
+}
+
+// Apply applies an operator or built-in function to operands written as
+// numbers in text, computing in precision p with its Backend. The result is
+// text as well: a float64 in its shortest form, a decimal rounded to the
+// scale of p without trailing zeros, a fraction in lowest terms, an integer
+// or a complex number.
+func Apply(op string, operands []string, p Precision) (string, error) {
+	operator, ok := operators.Lookup(op)
+	if !ok {
+		return "", operators.NewInvalidOperatorError(op)
+	}
+	if !operator.AcceptsArgs(len(operands)) {
+		return "", operators.NewArgumentCountError(op, len(operands))
+	}
+	return NewBackend(p).Apply(op, operands)
+}
+
+// Format writes value the way a result computed in precision p is written.
+func Format(value string, p Precision) (string, error) {
+	return NewBackend(p).Format(value)
+}
+
+// FormatFloat writes f in the shortest form that reads back as f.
+func FormatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+func parseFloat(value string) (float64, error) {
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, invalidNumber(value)
+	}
+	return f, nil
+}
+
+// invalidNumber is the error for a value that a backend for real numbers
+// cannot read.
+func invalidNumber(value string) error {
+	if IsImaginary(value) {
+		return NewImaginaryNumberError(value)
+	}
+	return NewInvalidNumberError(value)
+}
+
+// Float returns the float64 closest to value, or to its real part if it is
+// complex, or 0 if value is not a number. It is used where only an
+// approximation of a result is kept.
+func Float(value string) float64 {
+	f, err := strconv.ParseFloat(value, 64)
+	if err == nil || errors.Is(err, strconv.ErrRange) {
+		return f
+	}
+	if r, ok := new(big.Rat).SetString(value); ok {
+		f, _ = r.Float64()
+		return f
+	}
+	if c, err := strconv.ParseComplex(value, 128); err == nil {
+		return real(c)
+	}
+	return 0
+}
+
+// Equals reports whether value, a number in text form, is exactly n.
+func Equals(value string, n int64) bool {
+	r, ok := new(big.Rat).SetString(value)
+	return ok && r.Cmp(big.NewRat(n, 1)) == 0
+}
+
+// Imag returns the imaginary part of value, which is 0 unless value is a
+// complex number.
+func Imag(value string) float64 {
+	if !IsImaginary(value) {
+		return 0
+	}
+	c, _ := strconv.ParseComplex(value, 128)
+	return imag(c)
+}
@@ -0,0 +1,197 @@
+package numeric
+
+import (
+	"DistributedCalc/internal/operators"
+	"encoding/json"
+	"testing"
+)
+
+func TestApply(t *testing.T) {
+	decimal := func(scale int, rounding string) Precision {
+		return Precision{Mode: ModeDecimal, Scale: scale, Rounding: rounding}
+	}
+	rational := Precision{Mode: ModeRational}
+	bigint := Precision{Mode: ModeBigInt}
+	complexp := Precision{Mode: ModeComplex}
+	tests := []struct {
+		name      string
+		op        string
+		operands  []string
+		precision Precision
+		expected  string
+		err       error
+	}{
+		{name: "Float64 keeps binary rounding errors", op: "+", operands: []string{"0.1", "0.2"}, expected: "0.30000000000000004"},
+		{name: "Decimal addition is exact", op: "+", operands: []string{"0.1", "0.2"}, precision: decimal(10, RoundHalfEven), expected: "0.3"},
+		{name: "Decimal multiplication", op: "*", operands: []string{"1.05", "1.05"}, precision: decimal(10, RoundHalfEven), expected: "1.1025"},
+		{name: "Division rounds to the scale", op: "/", operands: []string{"2", "3"}, precision: decimal(4, RoundHalfUp), expected: "0.6667"},
+		{name: "Division rounds down", op: "/", operands: []string{"2", "3"}, precision: decimal(4, RoundDown), expected: "0.6666"},
+		{name: "Half up rounds ties away from zero", op: "*", operands: []string{"-0.125", "1"}, precision: decimal(2, RoundHalfUp), expected: "-0.13"},
+		{name: "Half even rounds ties to even", op: "*", operands: []string{"0.125", "1"}, precision: decimal(2, RoundHalfEven), expected: "0.12"},
+		{name: "Floor", op: "neg", operands: []string{"0.121"}, precision: decimal(2, RoundFloor), expected: "-0.13"},
+		{name: "Ceiling", op: "neg", operands: []string{"0.129"}, precision: decimal(2, RoundCeiling), expected: "-0.12"},
+		{name: "Up", op: "+", operands: []string{"0.121", "0"}, precision: decimal(2, RoundUp), expected: "0.13"},
+		{name: "Integer power is exact", op: "^", operands: []string{"1.1", "3"}, precision: decimal(10, RoundHalfEven), expected: "1.331"},
+		{name: "Negative power", op: "^", operands: []string{"2", "-2"}, precision: decimal(10, RoundHalfEven), expected: "0.25"},
+		{name: "Modulo takes the sign of the divisor", op: "%", operands: []string{"-7.5", "2"}, precision: decimal(10, RoundHalfEven), expected: "0.5"},
+		{name: "Integer division floors", op: "//", operands: []string{"-7", "2"}, precision: decimal(10, RoundHalfEven), expected: "-4"},
+		{name: "Square root to the scale", op: "sqrt", operands: []string{"2"}, precision: decimal(22, RoundDown), expected: "1.4142135623730950488016"},
+		{name: "Round uses the rounding mode", op: "round", operands: []string{"2.5"}, precision: decimal(10, RoundHalfEven), expected: "2"},
+		{name: "Round to digits", op: "round", operands: []string{"1.2345", "2"}, precision: decimal(10, RoundHalfUp), expected: "1.23"},
+		{name: "Max of many", op: "max", operands: []string{"0.1", "0.3", "0.2"}, precision: decimal(10, RoundHalfEven), expected: "0.3"},
+		{name: "Division by zero", op: "/", operands: []string{"1", "0"}, precision: decimal(10, RoundHalfEven), err: operators.NewDivisionByZeroError()},
+		{name: "Huge exponent", op: "^", operands: []string{"2", "100000"}, precision: decimal(10, RoundHalfEven), err: NewExponentTooLargeError(maxExponent)},
+		{name: "Invalid operand", op: "+", operands: []string{"1", "x"}, precision: decimal(10, RoundHalfEven), err: NewInvalidNumberError("x")},
+		{name: "Rational keeps fractions", op: "/", operands: []string{"1", "3"}, precision: rational, expected: "1/3"},
+		{name: "Rational fractions cancel", op: "*", operands: []string{"1/3", "3"}, precision: rational, expected: "1"},
+		{name: "Rational reads decimals", op: "+", operands: []string{"0.1", "1/5"}, precision: rational, expected: "3/10"},
+		{name: "Rational negative power", op: "^", operands: []string{"2/3", "-2"}, precision: rational, expected: "9/4"},
+		{name: "Rational square root", op: "sqrt", operands: []string{"4/9"}, precision: rational, expected: "2/3"},
+		{name: "Irrational square root", op: "sqrt", operands: []string{"2"}, precision: rational, err: NewInexactResultError("sqrt", ModeRational)},
+		{name: "Rational log", op: "log", operands: []string{"2"}, precision: rational, err: NewUnsupportedOperatorError("log", ModeRational)},
+		{name: "Rational round", op: "round", operands: []string{"5/2"}, precision: rational, expected: "3"},
+		{name: "Big integers do not overflow", op: "*", operands: []string{"9223372036854775807", "9223372036854775807"}, precision: bigint, expected: "85070591730234615847396907784232501249"},
+		{name: "Big integer exact division", op: "/", operands: []string{"12", "4"}, precision: bigint, expected: "3"},
+		{name: "Big integer inexact division", op: "/", operands: []string{"7", "2"}, precision: bigint, err: NewInexactResultError("/", ModeBigInt)},
+		{name: "Big integer division floors", op: "//", operands: []string{"-7", "2"}, precision: bigint, expected: "-4"},
+		{name: "Big integer modulo", op: "%", operands: []string{"-7", "2"}, precision: bigint, expected: "1"},
+		{name: "Big integer power", op: "^", operands: []string{"3", "40"}, precision: bigint, expected: "12157665459056928801"},
+		{name: "Big integer negative power", op: "^", operands: []string{"2", "-1"}, precision: bigint, err: NewInexactResultError("^", ModeBigInt)},
+		{name: "Big integer round to tens", op: "round", operands: []string{"125", "-1"}, precision: bigint, expected: "130"},
+		{name: "Big integer round past every digit", op: "round", operands: []string{"123", "-1000"}, precision: bigint, expected: "0"},
+		{name: "Big integer round to more places than kept", op: "round", operands: []string{"123", "1000"}, precision: bigint, expected: "123"},
+		{name: "Decimal round past every digit", op: "round", operands: []string{"-123.5", "-1000"}, precision: decimal(10, RoundHalfUp), expected: "0"},
+		{name: "Big integer reads exponents", op: "+", operands: []string{"1e+20", "1"}, precision: bigint, expected: "100000000000000000001"},
+		{name: "Big integer rejects fractions", op: "+", operands: []string{"2.5", "1"}, precision: bigint, err: NewNotIntegerError("2.5")},
+		{name: "Complex multiplication", op: "*", operands: []string{"3+4i", "1-2i"}, precision: complexp, expected: "11-2i"},
+		{name: "Complex division", op: "/", operands: []string{"1", "1i"}, precision: complexp, expected: "-1i"},
+		{name: "Square root of a negative number", op: "sqrt", operands: []string{"-4"}, precision: complexp, expected: "2i"},
+		{name: "Integer power of i", op: "^", operands: []string{"1i", "2"}, precision: complexp, expected: "-1"},
+		{name: "Complex absolute value", op: "abs", operands: []string{"3+4i"}, precision: complexp, expected: "5"},
+		{name: "Conjugate", op: "conj", operands: []string{"3+4i"}, precision: complexp, expected: "3-4i"},
+		{name: "Imaginary part", op: "im", operands: []string{"3+4i"}, precision: complexp, expected: "4"},
+		{name: "Real operators on real parts", op: "max", operands: []string{"1", "3+0i"}, precision: complexp, expected: "3"},
+		{name: "Ordering complex numbers", op: "max", operands: []string{"1", "3+4i"}, precision: complexp, err: NewNotRealError("max")},
+		{name: "Complex overflow", op: "^", operands: []string{"1e300+1e300i", "2"}, precision: complexp, err: operators.NewOverflowError()},
+		{name: "Complex division by zero", op: "/", operands: []string{"1i", "0"}, precision: complexp, err: operators.NewDivisionByZeroError()},
+		{name: "Imaginary number in float64", op: "+", operands: []string{"1", "4i"}, err: NewImaginaryNumberError("4i")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := Apply(tt.op, tt.operands, tt.precision)
+			if tt.err != nil {
+				if err == nil || err.Error() != tt.err.Error() {
+					t.Fatalf("Expected error %v, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if result != tt.expected {
+				t.Errorf("Expected %s, got %s", tt.expected, result)
+			}
+		})
+	}
+}
+
+func TestApply_ChainedPowers(t *testing.T) {
+	for _, precision := range []Precision{{Mode: ModeRational}, {Mode: ModeBigInt}, {Mode: ModeDecimal, Scale: 2}} {
+		t.Run(precision.Mode, func(t *testing.T) {
+			power, err := Apply("^", []string{"9", "9999"}, precision)
+			if err != nil {
+				t.Fatalf("Failed to compute 9^9999: %v", err)
+			}
+			// (9^9999)^9999 would take hundreds of millions of bits.
+			if _, err := Apply("^", []string{power, "9999"}, precision); err == nil || err.Error() != NewResultTooLargeError(maxPowerBits).Error() {
+				t.Errorf("Expected %v, got %v", NewResultTooLargeError(maxPowerBits), err)
+			}
+			if _, err := Apply("^", []string{power, "2"}, precision); err != nil {
+				t.Errorf("Expected (9^9999)^2 to be computed, got %v", err)
+			}
+		})
+	}
+}
+
+func TestPrecision_Normalize(t *testing.T) {
+	tests := []struct {
+		name     string
+		json     string
+		expected Precision
+		err      error
+	}{
+		{name: "Float64 by default", json: `{}`, expected: Precision{Mode: ModeFloat64}},
+		{name: "Decimal defaults", json: `{"mode":"decimal"}`, expected: Precision{Mode: ModeDecimal, Scale: DefaultScale, Rounding: DefaultRounding}},
+		{name: "Zero scale", json: `{"mode":"decimal","scale":0,"rounding":"floor"}`, expected: Precision{Mode: ModeDecimal, Scale: 0, Rounding: RoundFloor}},
+		{name: "Zero scale with the default rounding", json: `{"mode":"decimal","scale":0}`, expected: Precision{Mode: ModeDecimal, Scale: 0, Rounding: DefaultRounding}},
+		{name: "Rational ignores the scale", json: `{"mode":"rational","scale":4}`, expected: Precision{Mode: ModeRational}},
+		{name: "Unknown mode", json: `{"mode":"float32"}`, err: NewUnknownModeError("float32")},
+		{name: "Scale out of range", json: `{"mode":"decimal","scale":101}`, err: NewInvalidScaleError(MaxScale)},
+		{name: "Unknown rounding", json: `{"mode":"decimal","rounding":"nearest"}`, err: NewUnknownRoundingError("nearest")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var p Precision
+			if err := json.Unmarshal([]byte(tt.json), &p); err != nil {
+				t.Fatalf("Failed to decode: %v", err)
+			}
+			p, err := p.Normalize()
+			if tt.err != nil {
+				if err == nil || err.Error() != tt.err.Error() {
+					t.Fatalf("Expected error %v, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil || p != tt.expected {
+				t.Errorf("Expected %+v, got %+v (%v)", tt.expected, p, err)
+			}
+		})
+	}
+}
+
+func TestPrecision_NormalizeInGo(t *testing.T) {
+	tests := []struct {
+		name      string
+		precision Precision
+		expected  Precision
+	}{
+		{name: "Decimal defaults", precision: Precision{Mode: ModeDecimal}, expected: Precision{Mode: ModeDecimal, Scale: DefaultScale, Rounding: DefaultRounding}},
+		{name: "Explicit scale", precision: Precision{Mode: ModeDecimal, Scale: 2}, expected: Precision{Mode: ModeDecimal, Scale: 2, Rounding: DefaultRounding}},
+		{name: "Zero scale with a rounding", precision: Precision{Mode: ModeDecimal, Rounding: RoundDown}, expected: Precision{Mode: ModeDecimal, Scale: 0, Rounding: RoundDown}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := tt.precision.Normalize()
+			if err != nil || p != tt.expected {
+				t.Errorf("Expected %+v, got %+v (%v)", tt.expected, p, err)
+			}
+		})
+	}
+
+	p, _ := Precision{Mode: ModeDecimal}.Normalize()
+	if result, err := Apply("+", []string{"0.1", "0.2"}, p); err != nil || result != "0.3" {
+		t.Errorf("Expected 0.3, got %q (%v)", result, err)
+	}
+}
+
+func TestFloatAndImag(t *testing.T) {
+	tests := []struct {
+		value string
+		real  float64
+		imag  float64
+	}{
+		{value: "2.5", real: 2.5},
+		{value: "1/4", real: 0.25},
+		{value: "3-4i", real: 3, imag: -4},
+		{value: "-2i", imag: -2},
+	}
+
+	for _, tt := range tests {
+		if re, im := Float(tt.value), Imag(tt.value); re != tt.real || im != tt.imag {
+			t.Errorf("Expected %s to be %v and %v, got %v and %v", tt.value, tt.real, tt.imag, re, im)
+		}
+	}
+}
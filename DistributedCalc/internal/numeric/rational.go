@@ -0,0 +1,193 @@
+package numeric
+
+import (
+	"DistributedCalc/internal/operators"
+	"math/big"
+)
+
+// maxExponent bounds integer powers, whose exact results grow with the
+// exponent.
+const maxExponent = 10000
+
+// maxPowerBits bounds the size of an exact power, so that chained powers
+// such as (9^9999)^9999 are rejected before they are computed.
+const maxPowerBits = 1 << 18
+
+// rational computes with exact fractions and writes results as integers or
+// as fractions in lowest terms, such as 1/3. Operators whose result is not
+// rational in general, like sqrt or log, only accept operands with a
+// rational result.
+var rational = backend[*big.Rat]{
+	mode:   ModeRational,
+	parse:  parseRat,
+	format: (*big.Rat).RatString,
+	ops: withOps(ratOps, map[string]func(args []*big.Rat) (*big.Rat, error){
+		operators.Power: func(args []*big.Rat) (*big.Rat, error) {
+			if !args[1].IsInt() {
+				return nil, NewInexactResultError(operators.Power, ModeRational)
+			}
+			return powRat(args[0], args[1])
+		},
+		operators.Sqrt: func(args []*big.Rat) (*big.Rat, error) {
+			if args[0].Sign() < 0 {
+				return nil, operators.NewNegativeSqrtError()
+			}
+			num, okNum := sqrtInt(args[0].Num())
+			denom, okDenom := sqrtInt(args[0].Denom())
+			if !okNum || !okDenom {
+				return nil, NewInexactResultError(operators.Sqrt, ModeRational)
+			}
+			return new(big.Rat).SetFrac(num, denom), nil
+		},
+		operators.Round: func(args []*big.Rat) (*big.Rat, error) {
+			return roundRat(args, RoundHalfUp)
+		},
+	}),
+}
+
+// ratOps are the operators computed exactly on fractions by both the
+// rational and the decimal backend.
+var ratOps = map[string]func(args []*big.Rat) (*big.Rat, error){
+	operators.Add: func(args []*big.Rat) (*big.Rat, error) {
+		return new(big.Rat).Add(args[0], args[1]), nil
+	},
+	operators.Subtract: func(args []*big.Rat) (*big.Rat, error) {
+		return new(big.Rat).Sub(args[0], args[1]), nil
+	},
+	operators.Multiply: func(args []*big.Rat) (*big.Rat, error) {
+		return new(big.Rat).Mul(args[0], args[1]), nil
+	},
+	operators.Divide: func(args []*big.Rat) (*big.Rat, error) {
+		if args[1].Sign() == 0 {
+			return nil, operators.NewDivisionByZeroError()
+		}
+		return new(big.Rat).Quo(args[0], args[1]), nil
+	},
+	operators.IntegerDivide: func(args []*big.Rat) (*big.Rat, error) {
+		if args[1].Sign() == 0 {
+			return nil, operators.NewIntegerDivisionByZeroError()
+		}
+		return floorQuo(args[0], args[1]), nil
+	},
+	operators.Modulo: func(args []*big.Rat) (*big.Rat, error) {
+		if args[1].Sign() == 0 {
+			return nil, operators.NewModuloByZeroError()
+		}
+		multiple := new(big.Rat).Mul(args[1], floorQuo(args[0], args[1]))
+		return multiple.Sub(args[0], multiple), nil
+	},
+	operators.Negate: func(args []*big.Rat) (*big.Rat, error) {
+		return new(big.Rat).Neg(args[0]), nil
+	},
+	operators.Abs: func(args []*big.Rat) (*big.Rat, error) {
+		return new(big.Rat).Abs(args[0]), nil
+	},
+	operators.Min: func(args []*big.Rat) (*big.Rat, error) {
+		result := args[0]
+		for _, arg := range args[1:] {
+			if arg.Cmp(result) < 0 {
+				result = arg
+			}
+		}
+		return result, nil
+	},
+	operators.Max: func(args []*big.Rat) (*big.Rat, error) {
+		result := args[0]
+		for _, arg := range args[1:] {
+			if arg.Cmp(result) > 0 {
+				result = arg
+			}
+		}
+		return result, nil
+	},
+}
+
+// withOps returns the operators of base with some of them replaced or
+// added.
+func withOps[T any](base, ops map[string]func(args []T) (T, error)) map[string]func(args []T) (T, error) {
+	result := make(map[string]func(args []T) (T, error), len(base)+len(ops))
+	for op, apply := range base {
+		result[op] = apply
+	}
+	for op, apply := range ops {
+		result[op] = apply
+	}
+	return result
+}
+
+func parseRat(value string) (*big.Rat, error) {
+	r, ok := new(big.Rat).SetString(value)
+	if !ok {
+		return nil, invalidNumber(value)
+	}
+	return r, nil
+}
+
+// powRat raises base to an integer exponent exactly.
+func powRat(base, exp *big.Rat) (*big.Rat, error) {
+	if base.Sign() == 0 && exp.Sign() < 0 {
+		return nil, operators.NewZeroToNegativePowerError()
+	}
+	if !exp.Num().IsInt64() || abs(exp.Num().Int64()) > maxExponent {
+		return nil, NewExponentTooLargeError(maxExponent)
+	}
+	n := exp.Num().Int64()
+	if err := checkPowerSize(max(base.Num().BitLen(), base.Denom().BitLen()), n); err != nil {
+		return nil, err
+	}
+	num := new(big.Int).Exp(base.Num(), big.NewInt(abs(n)), nil)
+	denom := new(big.Int).Exp(base.Denom(), big.NewInt(abs(n)), nil)
+	if n < 0 {
+		num, denom = denom, num
+	}
+	return new(big.Rat).SetFrac(num, denom), nil
+}
+
+// checkPowerSize rejects raising a number of bits bits to the power n if
+// the result would take more than maxPowerBits.
+func checkPowerSize(bits int, n int64) error {
+	if int64(bits)*abs(n) > maxPowerBits {
+		return NewResultTooLargeError(maxPowerBits)
+	}
+	return nil
+}
+
+// roundRat rounds args[0] to args[1] decimal places, or to an integer when
+// there is no second argument. Beyond MaxScale places the argument is kept,
+// and rounded to more than MaxScale places before the point it is 0.
+func roundRat(args []*big.Rat, rounding string) (*big.Rat, error) {
+	if len(args) == 1 {
+		return roundDecimal(args[0], 0, rounding), nil
+	}
+	if !args[1].IsInt() || !args[1].Num().IsInt64() {
+		return nil, operators.NewInvalidRoundDigitsError()
+	}
+	digits := args[1].Num().Int64()
+	if digits > MaxScale {
+		return args[0], nil
+	}
+	if digits < -MaxScale {
+		return new(big.Rat), nil
+	}
+	return roundDecimal(args[0], int(digits), rounding), nil
+}
+
+// sqrtInt returns the square root of n if n is a perfect square.
+func sqrtInt(n *big.Int) (*big.Int, bool) {
+	root := new(big.Int).Sqrt(n)
+	return root, new(big.Int).Mul(root, root).Cmp(n) == 0
+}
+
+// floorQuo returns the largest integer not greater than a/b.
+func floorQuo(a, b *big.Rat) *big.Rat {
+	q := new(big.Rat).Quo(a, b)
+	floor := new(big.Int).Div(q.Num(), q.Denom())
+	return new(big.Rat).SetInt(floor)
+}
+
+func abs(n int64) int64 {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
@@ -0,0 +1,55 @@
+package operators
+
+import (
+	"DistributedCalc/pkg/errors"
+	"fmt"
+	"net/http"
+)
+
+func NewDivisionByZeroError() *errors.AppError {
+	return &errors.AppError{Code: http.StatusUnprocessableEntity, Message: "division by zero"}
+}
+
+func NewIntegerDivisionByZeroError() *errors.AppError {
+	return &errors.AppError{Code: http.StatusUnprocessableEntity, Message: "integer division by zero"}
+}
+
+func NewModuloByZeroError() *errors.AppError {
+	return &errors.AppError{Code: http.StatusUnprocessableEntity, Message: "modulo by zero"}
+}
+
+func NewZeroToNegativePowerError() *errors.AppError {
+	return &errors.AppError{Code: http.StatusUnprocessableEntity, Message: "zero raised to a negative power"}
+}
+
+func NewFractionalPowerError() *errors.AppError {
+	return &errors.AppError{Code: http.StatusUnprocessableEntity, Message: "negative base raised to a fractional power"}
+}
+
+func NewOverflowError() *errors.AppError {
+	return &errors.AppError{Code: http.StatusUnprocessableEntity, Message: "result is out of the float64 range"}
+}
+
+func NewInvalidOperatorError(op string) *errors.AppError {
+	return &errors.AppError{Code: http.StatusUnprocessableEntity, Message: fmt.Sprintf("invalid operator: %s", op)}
+}
+
+func NewNegativeSqrtError() *errors.AppError {
+	return &errors.AppError{Code: http.StatusUnprocessableEntity, Message: "square root of a negative number"}
+}
+
+func NewNonPositiveLogError() *errors.AppError {
+	return &errors.AppError{Code: http.StatusUnprocessableEntity, Message: "logarithm of a non-positive number"}
+}
+
+func NewInvalidLogBaseError() *errors.AppError {
+	return &errors.AppError{Code: http.StatusUnprocessableEntity, Message: "logarithm base must be positive and not 1"}
+}
+
+func NewInvalidRoundDigitsError() *errors.AppError {
+	return &errors.AppError{Code: http.StatusUnprocessableEntity, Message: "round digits must be an integer"}
+}
+
+func NewArgumentCountError(op string, got int) *errors.AppError {
+	return &errors.AppError{Code: http.StatusUnprocessableEntity, Message: fmt.Sprintf("invalid number of arguments for %s: %d", op, got)}
+}
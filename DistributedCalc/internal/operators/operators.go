@@ -0,0 +1,287 @@
+package operators
+
+import (
+	"context"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const (
+	Add           = "+"
+	Subtract      = "-"
+	Multiply      = "*"
+	Divide        = "/"
+	IntegerDivide = "//"
+	Modulo        = "%"
+	Power         = "^"
+	Negate        = "neg"
+)
+
+const (
+	Sqrt  = "sqrt"
+	Abs   = "abs"
+	Min   = "min"
+	Max   = "max"
+	Log   = "log"
+	Round = "round"
+	Conj  = "conj"
+	Re    = "re"
+	Im    = "im"
+	Arg   = "arg"
+)
+
+// Built-in constants that can be used in expressions by name.
+const (
+	Pi = "pi"
+	E  = "e"
+)
+
+var constants = map[string]float64{
+	Pi: math.Pi,
+	E:  math.E,
+}
+
+// ImaginaryUnit is the name of i in expressions. It is only a number in
+// complex precision, so it is not among the constants, but it is reserved
+// all the same.
+const ImaginaryUnit = "i"
+
+const defaultDurationMS = 100
+
+// Variadic is the MaxArgs of functions that accept any number of arguments.
+const Variadic = -1
+
+// Operator describes how an operator or a built-in function is parsed and
+// computed. Every place that needs to know about operators (parser,
+// orchestrator, agents) reads it from this table.
+type Operator struct {
+	Symbol     string
+	Precedence int
+	RightAssoc bool
+	Unary      bool
+	Function   bool
+	MinArgs    int
+	MaxArgs    int
+	TimeEnv    string
+	Apply      func(args []float64) (float64, error)
+}
+
+var table = map[string]Operator{
+	Add: binary(Add, 1, "TIME_ADDITION_MS", func(a, b float64) (float64, error) {
+		return a + b, nil
+	}),
+	Subtract: binary(Subtract, 1, "TIME_SUBTRACTION_MS", func(a, b float64) (float64, error) {
+		return a - b, nil
+	}),
+	Multiply: binary(Multiply, 2, "TIME_MULTIPLICATIONS_MS", func(a, b float64) (float64, error) {
+		return a * b, nil
+	}),
+	Divide: binary(Divide, 2, "TIME_DIVISIONS_MS", func(a, b float64) (float64, error) {
+		if b == 0 {
+			return 0, NewDivisionByZeroError()
+		}
+		return a / b, nil
+	}),
+	IntegerDivide: binary(IntegerDivide, 2, "TIME_INTEGER_DIVISIONS_MS", func(a, b float64) (float64, error) {
+		if b == 0 {
+			return 0, NewIntegerDivisionByZeroError()
+		}
+		return math.Floor(a / b), nil
+	}),
+	// Modulo takes the sign of the divisor, so that a == b*(a//b) + a%b.
+	Modulo: binary(Modulo, 2, "TIME_MODULO_MS", func(a, b float64) (float64, error) {
+		if b == 0 {
+			return 0, NewModuloByZeroError()
+		}
+		return a - b*math.Floor(a/b), nil
+	}),
+	Power: {Symbol: Power, Precedence: 3, RightAssoc: true, MinArgs: 2, MaxArgs: 2, TimeEnv: "TIME_POWER_MS", Apply: finite(func(args []float64) (float64, error) {
+		a, b := args[0], args[1]
+		if a == 0 && b < 0 {
+			return 0, NewZeroToNegativePowerError()
+		}
+		if a < 0 && b != math.Trunc(b) {
+			return 0, NewFractionalPowerError()
+		}
+		return math.Pow(a, b), nil
+	})},
+	Negate: {Symbol: Negate, Precedence: 3, Unary: true, MinArgs: 1, MaxArgs: 1, TimeEnv: "TIME_NEGATION_MS", Apply: finite(func(args []float64) (float64, error) {
+		return -args[0], nil
+	})},
+
+	Sqrt: function(Sqrt, 1, 1, "TIME_SQRT_MS", func(args []float64) (float64, error) {
+		if args[0] < 0 {
+			return 0, NewNegativeSqrtError()
+		}
+		return math.Sqrt(args[0]), nil
+	}),
+	Abs: function(Abs, 1, 1, "TIME_ABS_MS", func(args []float64) (float64, error) {
+		return math.Abs(args[0]), nil
+	}),
+	Min: function(Min, 1, Variadic, "TIME_MIN_MS", func(args []float64) (float64, error) {
+		result := args[0]
+		for _, arg := range args[1:] {
+			result = math.Min(result, arg)
+		}
+		return result, nil
+	}),
+	Max: function(Max, 1, Variadic, "TIME_MAX_MS", func(args []float64) (float64, error) {
+		result := args[0]
+		for _, arg := range args[1:] {
+			result = math.Max(result, arg)
+		}
+		return result, nil
+	}),
+	// log(x) is the natural logarithm, log(x, b) the logarithm to base b.
+	Log: function(Log, 1, 2, "TIME_LOG_MS", func(args []float64) (float64, error) {
+		if args[0] <= 0 {
+			return 0, NewNonPositiveLogError()
+		}
+		if len(args) == 1 {
+			return math.Log(args[0]), nil
+		}
+		if args[1] <= 0 || args[1] == 1 {
+			return 0, NewInvalidLogBaseError()
+		}
+		return math.Log(args[0]) / math.Log(args[1]), nil
+	}),
+	// round(x) rounds half away from zero, round(x, n) keeps n decimal places.
+	Round: function(Round, 1, 2, "TIME_ROUND_MS", func(args []float64) (float64, error) {
+		if len(args) == 1 {
+			return math.Round(args[0]), nil
+		}
+		if args[1] != math.Trunc(args[1]) {
+			return 0, NewInvalidRoundDigitsError()
+		}
+		scale := math.Pow(10, args[1])
+		return math.Round(args[0]*scale) / scale, nil
+	}),
+	// conj, re, im and arg take the conjugate, real part, imaginary part and
+	// argument of a complex number. Real numbers are their own conjugate and
+	// real part, and their argument is 0 or pi.
+	Conj: function(Conj, 1, 1, "TIME_CONJ_MS", func(args []float64) (float64, error) {
+		return args[0], nil
+	}),
+	Re: function(Re, 1, 1, "TIME_RE_MS", func(args []float64) (float64, error) {
+		return args[0], nil
+	}),
+	Im: function(Im, 1, 1, "TIME_IM_MS", func(args []float64) (float64, error) {
+		return 0, nil
+	}),
+	Arg: function(Arg, 1, 1, "TIME_ARG_MS", func(args []float64) (float64, error) {
+		return math.Atan2(0, args[0]), nil
+	}),
+}
+
+func binary(symbol string, precedence int, timeEnv string, apply func(a, b float64) (float64, error)) Operator {
+	return Operator{Symbol: symbol, Precedence: precedence, MinArgs: 2, MaxArgs: 2, TimeEnv: timeEnv, Apply: finite(func(args []float64) (float64, error) {
+		return apply(args[0], args[1])
+	})}
+}
+
+func function(name string, minArgs, maxArgs int, timeEnv string, apply func(args []float64) (float64, error)) Operator {
+	return Operator{Symbol: name, Function: true, MinArgs: minArgs, MaxArgs: maxArgs, TimeEnv: timeEnv, Apply: finite(apply)}
+}
+
+// finite rejects results that overflow float64, such as 10^400, or are not
+// a number, so that they never reach storage or JSON, which has no way to
+// write them.
+func finite(apply func(args []float64) (float64, error)) func(args []float64) (float64, error) {
+	return func(args []float64) (float64, error) {
+		result, err := apply(args)
+		if err == nil && (math.IsInf(result, 0) || math.IsNaN(result)) {
+			return 0, NewOverflowError()
+		}
+		return result, err
+	}
+}
+
+func Lookup(op string) (Operator, bool) {
+	operator, ok := table[op]
+	return operator, ok
+}
+
+// Symbols lists all operators and functions in a stable order.
+func Symbols() []string {
+	symbols := make([]string, 0, len(table))
+	for symbol := range table {
+		symbols = append(symbols, symbol)
+	}
+	sort.Strings(symbols)
+	return symbols
+}
+
+// IsBinary reports whether token is an infix operator of the expression
+// grammar.
+func IsBinary(token string) bool {
+	operator, ok := table[token]
+	return ok && !operator.Unary && !operator.Function
+}
+
+// IsFunction reports whether name is a built-in function.
+func IsFunction(name string) bool {
+	operator, ok := table[name]
+	return ok && operator.Function
+}
+
+// Constant returns the value of a built-in constant.
+func Constant(name string) (float64, bool) {
+	value, ok := constants[name]
+	return value, ok
+}
+
+// IsReserved reports whether name is taken by a built-in function or
+// constant and therefore cannot be bound as a variable.
+func IsReserved(name string) bool {
+	_, isConstant := constants[name]
+	return isConstant || name == ImaginaryUnit || IsFunction(name)
+}
+
+// AcceptsArgs reports whether the operator can be applied to n operands.
+func (o Operator) AcceptsArgs(n int) bool {
+	return n >= o.MinArgs && (o.MaxArgs == Variadic || n <= o.MaxArgs)
+}
+
+// Duration is the artificial delay an agent waits before computing the
+// operator, configured through its TIME_*_MS environment variable.
+func (o Operator) Duration() time.Duration {
+	ms, _ := strconv.Atoi(os.Getenv(o.TimeEnv))
+	if ms <= 0 {
+		ms = defaultDurationMS
+	}
+	return time.Duration(ms) * time.Millisecond
+}
+
+func (o Operator) DurationMS() int {
+	return int(o.Duration() / time.Millisecond)
+}
+
+// Compute waits for the operator's duration and applies it to args. It
+// gives up with ctx's error if ctx is done first.
+func Compute(ctx context.Context, op string, args []float64) (float64, error) {
+	operator, ok := table[op]
+	if !ok {
+		return 0, NewInvalidOperatorError(op)
+	}
+	if !operator.AcceptsArgs(len(args)) {
+		return 0, NewArgumentCountError(op, len(args))
+	}
+	if err := operator.Wait(ctx); err != nil {
+		return 0, err
+	}
+	return operator.Apply(args)
+}
+
+// Wait blocks for the operator's duration, or until ctx is done, in which
+// case it returns ctx's error.
+func (o Operator) Wait(ctx context.Context) error {
+	select {
+	case <-time.After(o.Duration()):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
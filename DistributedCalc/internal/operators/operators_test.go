@@ -0,0 +1,234 @@
+package operators
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestOperator_Apply(t *testing.T) {
+	tests := []struct {
+		name     string
+		op       string
+		a        float64
+		b        float64
+		expected float64
+		err      error
+	}{
+		{
+			name:     "Integer division floors",
+			op:       IntegerDivide,
+			a:        -7,
+			b:        2,
+			expected: -4,
+		},
+		{
+			name:     "Modulo takes the sign of the divisor",
+			op:       Modulo,
+			a:        -7,
+			b:        3,
+			expected: 2,
+		},
+		{
+			name:     "Fractional modulo",
+			op:       Modulo,
+			a:        5.5,
+			b:        2,
+			expected: 1.5,
+		},
+		{
+			name:     "Negative integer power",
+			op:       Power,
+			a:        -2,
+			b:        3,
+			expected: -8,
+		},
+		{
+			name: "Modulo by zero",
+			op:   Modulo,
+			a:    1,
+			b:    0,
+			err:  NewModuloByZeroError(),
+		},
+		{
+			name: "Integer division by zero",
+			op:   IntegerDivide,
+			a:    1,
+			b:    0,
+			err:  NewIntegerDivisionByZeroError(),
+		},
+		{
+			name: "Zero to a negative power",
+			op:   Power,
+			a:    0,
+			b:    -1,
+			err:  NewZeroToNegativePowerError(),
+		},
+		{
+			name: "Negative base with fractional exponent",
+			op:   Power,
+			a:    -8,
+			b:    0.5,
+			err:  NewFractionalPowerError(),
+		},
+		{
+			name: "Power overflows",
+			op:   Power,
+			a:    10,
+			b:    400,
+			err:  NewOverflowError(),
+		},
+		{
+			name: "Not a number",
+			op:   Subtract,
+			a:    math.Inf(1),
+			b:    math.Inf(1),
+			err:  NewOverflowError(),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			operator, ok := Lookup(tt.op)
+			if !ok {
+				t.Fatalf("Operator %s not found", tt.op)
+			}
+			result, err := operator.Apply([]float64{tt.a, tt.b})
+			if tt.err != nil {
+				if err == nil || err.Error() != tt.err.Error() {
+					t.Errorf("Expected error %v, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("Unexpected error: %v", err)
+			}
+			if result != tt.expected {
+				t.Errorf("Expected %f, got %f", tt.expected, result)
+			}
+		})
+	}
+}
+
+func TestCompute_Functions(t *testing.T) {
+	t.Setenv("TIME_SQRT_MS", "1")
+	t.Setenv("TIME_MAX_MS", "1")
+	t.Setenv("TIME_LOG_MS", "1")
+	t.Setenv("TIME_ROUND_MS", "1")
+	t.Setenv("TIME_ARG_MS", "1")
+
+	tests := []struct {
+		name     string
+		op       string
+		args     []float64
+		expected float64
+		err      error
+	}{
+		{
+			name:     "Square root",
+			op:       Sqrt,
+			args:     []float64{16},
+			expected: 4,
+		},
+		{
+			name:     "Max of many",
+			op:       Max,
+			args:     []float64{3, 7, -1, 5},
+			expected: 7,
+		},
+		{
+			name:     "Logarithm with base",
+			op:       Log,
+			args:     []float64{8, 2},
+			expected: 3,
+		},
+		{
+			name:     "Round to digits",
+			op:       Round,
+			args:     []float64{2.345, 2},
+			expected: 2.35,
+		},
+		{
+			name:     "Argument of a negative number",
+			op:       Arg,
+			args:     []float64{-2},
+			expected: math.Pi,
+		},
+		{
+			name: "Square root of a negative number",
+			op:   Sqrt,
+			args: []float64{-1},
+			err:  NewNegativeSqrtError(),
+		},
+		{
+			name: "Logarithm of zero",
+			op:   Log,
+			args: []float64{0},
+			err:  NewNonPositiveLogError(),
+		},
+		{
+			name: "Logarithm base one",
+			op:   Log,
+			args: []float64{8, 1},
+			err:  NewInvalidLogBaseError(),
+		},
+		{
+			name: "Too many arguments",
+			op:   Sqrt,
+			args: []float64{4, 9},
+			err:  NewArgumentCountError(Sqrt, 2),
+		},
+		{
+			name: "No arguments",
+			op:   Max,
+			args: nil,
+			err:  NewArgumentCountError(Max, 0),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := Compute(context.Background(), tt.op, tt.args)
+			if tt.err != nil {
+				if err == nil || err.Error() != tt.err.Error() {
+					t.Errorf("Expected error %v, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("Unexpected error: %v", err)
+			}
+			if result != tt.expected {
+				t.Errorf("Expected %f, got %f", tt.expected, result)
+			}
+		})
+	}
+}
+
+func TestCompute_ContextDone(t *testing.T) {
+	t.Setenv("TIME_ADDITION_MS", "5000")
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	if _, err := Compute(ctx, Add, []float64{1, 2}); err != context.DeadlineExceeded {
+		t.Errorf("Expected %v, got %v", context.DeadlineExceeded, err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected Compute to stop at the deadline, took %v", elapsed)
+	}
+}
+
+func TestOperator_Duration(t *testing.T) {
+	t.Setenv("TIME_MODULO_MS", "25")
+	operator, _ := Lookup(Modulo)
+	if operator.DurationMS() != 25 {
+		t.Errorf("Expected 25ms, got %dms", operator.DurationMS())
+	}
+	operator, _ = Lookup(Power)
+	t.Setenv("TIME_POWER_MS", "")
+	if operator.DurationMS() != defaultDurationMS {
+		t.Errorf("Expected default duration, got %dms", operator.DurationMS())
+	}
+}
@@ -1,14 +1,100 @@
-package orchestrator
-
-import (
-	"DistributedCalc/pkg/errors"
-	"fmt"
-)
-
-func NewInvalidExpressionError() error {
-	return errors.NewBadRequestError("invalid expression")
-}
-
-func NewTaskDistributionError(msg string) error {
-	return errors.NewInternalError(fmt.Sprintf("task distribution error: %s", msg))
-}
+package orchestrator
+
+import (
+	"DistributedCalc/internal/operators"
+	"DistributedCalc/pkg/errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// SyntaxError is the Details of a parse error. Column counts characters
+// from 1. Token is empty at the end of the expression, and Expected is set
+// when the parser knows what would have been valid.
+type SyntaxError struct {
+	Column   int    `json:"column"`
+	Token    string `json:"token,omitempty"`
+	Expected string `json:"expected,omitempty"`
+}
+
+func newSyntaxError(message string, column int, token, expected string) error {
+	return &errors.AppError{Code: http.StatusUnprocessableEntity, Message: message, Details: &SyntaxError{Column: column, Token: token, Expected: expected}}
+}
+
+func NewEmptyExpressionError() error {
+	return newSyntaxError("empty expression, expected "+expectedOperand, 1, "", expectedOperand)
+}
+
+func NewUnexpectedTokenError(token string, column int, expected string) error {
+	what := fmt.Sprintf("unexpected '%s'", token)
+	if token == "" {
+		what = "unexpected end of expression"
+	}
+	return newSyntaxError(fmt.Sprintf("%s at column %d, expected %s", what, column, expected), column, token, expected)
+}
+
+func NewUnbalancedParenError(column int) error {
+	return newSyntaxError(fmt.Sprintf("unbalanced ')' at column %d", column), column, ")", "")
+}
+
+func NewUnclosedParenError(open, column int) error {
+	return newSyntaxError(fmt.Sprintf("missing ')' at column %d for '(' at column %d", column, open), column, "", "')'")
+}
+
+func NewInvalidCharacterError(token string, column int) error {
+	return newSyntaxError(fmt.Sprintf("invalid character '%s' at column %d", token, column), column, token, "")
+}
+
+func NewInvalidNumberError(token string, column int) error {
+	return newSyntaxError(fmt.Sprintf("invalid number '%s' at column %d", token, column), column, token, "")
+}
+
+func NewTaskDistributionError(msg string) error {
+	return errors.NewInternalError(fmt.Sprintf("task distribution error: %s", msg))
+}
+
+func NewUnknownFunctionError(name string, column int) error {
+	return newSyntaxError(fmt.Sprintf("unknown function '%s' at column %d", name, column), column, name, "")
+}
+
+func NewFunctionArityError(name string, min, max, got, column int) error {
+	var expected string
+	switch {
+	case max == operators.Variadic:
+		expected = fmt.Sprintf("at least %d", min)
+	case min == max:
+		expected = fmt.Sprintf("%d", min)
+	default:
+		expected = fmt.Sprintf("%d to %d", min, max)
+	}
+	message := fmt.Sprintf("function %s at column %d expects %s arguments, got %d", name, column, expected, got)
+	return newSyntaxError(message, column, name, expected+" arguments")
+}
+
+func NewUndefinedVariableError(name string, column int) error {
+	return newSyntaxError(fmt.Sprintf("undefined variable '%s' at column %d", name, column), column, name, "")
+}
+
+func NewInvalidVariableNameError(name string) error {
+	return errors.NewBadRequestError(fmt.Sprintf("invalid variable name: %s", name))
+}
+
+// NewCalculationError reports an error an agent returned for a task, such as
+// division by zero.
+func NewCalculationError(op, msg string) error {
+	return &errors.AppError{Code: http.StatusUnprocessableEntity, Message: fmt.Sprintf("calculation of %s failed: %s", op, msg)}
+}
+
+func NewTaskDeadLetteredError(taskID int64, attempts int, cause error) error {
+	return errors.NewInternalError(fmt.Sprintf("task %d failed after %d attempts: %v", taskID, attempts, cause))
+}
+
+func NewExpressionTimeoutError(deadline time.Time) error {
+	return &errors.AppError{Code: http.StatusGatewayTimeout, Message: fmt.Sprintf("expression did not finish before its deadline %s", deadline.Format(time.RFC3339Nano))}
+}
+
+// NewNoExactResultError reports an agent that answered a task in an exact
+// precision with only a float64.
+func NewNoExactResultError() error {
+	return &errors.AppError{Code: http.StatusBadGateway, Message: "agent does not support exact precision"}
+}
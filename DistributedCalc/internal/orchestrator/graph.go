@@ -0,0 +1,166 @@
+package orchestrator
+
+import (
+	"DistributedCalc/internal/numeric"
+	"slices"
+)
+
+// taskNode is one operation or function call of an expression. Its operands
+// are filled in as the child tasks complete; once pending drops to zero it
+// can be sent to an agent. Identical subtrees are one taskNode used by
+// several parents, or twice by the same one; shared holds the ids of the
+// occurrences it stands for besides its own. See optimized for how nodes
+// are numbered. Operands and results are numbers in text form, see
+// numeric.Apply.
+type taskNode struct {
+	id       int
+	shared   []int
+	op       string
+	args     []string
+	children []*taskNode
+	uses     []taskUse
+	pending  int
+	needed   bool
+	result   string
+	done     bool
+}
+
+// taskUse is an operand slot that the result of a task is written into.
+type taskUse struct {
+	parent *taskNode
+	slot   int
+}
+
+type taskGraph struct {
+	root  *taskNode
+	ready []*taskNode
+	nodes []*taskNode
+}
+
+// buildGraph turns an optimized expression into a task graph. Leaves are
+// folded directly into their parent's operand slots, so only operations
+// become tasks. Nodes whose id is in results are already computed: they are
+// marked done with that result, and nodes only they use are not dispatched.
+func buildGraph(expr *optimized, results map[int]string) *taskGraph {
+	g := &taskGraph{}
+	g.root = g.add(expr, expr.root, make(map[*Node]*taskNode))
+	for _, t := range g.nodes {
+		t.result, t.done = results[t.id]
+	}
+	g.root.need()
+	for _, t := range g.nodes {
+		if !t.needed {
+			// Either computed already or only used by computed nodes.
+			t.done = true
+			continue
+		}
+		for slot, child := range t.children {
+			switch {
+			case child == nil:
+			case child.done:
+				t.args[slot] = child.result
+			default:
+				t.pending++
+			}
+		}
+		if t.pending == 0 {
+			g.ready = append(g.ready, t)
+		}
+	}
+	return g
+}
+
+func (g *taskGraph) add(expr *optimized, n *Node, added map[*Node]*taskNode) *taskNode {
+	if t, ok := added[n]; ok {
+		return t
+	}
+	t := &taskNode{id: expr.ids[n], shared: expr.shared[n], op: n.Op, args: make([]string, len(n.Args)), children: make([]*taskNode, len(n.Args))}
+	added[n] = t
+	g.nodes = append(g.nodes, t)
+	for i, child := range n.Args {
+		if child.IsLeaf() {
+			t.args[i] = child.Number()
+			continue
+		}
+		c := g.add(expr, child, added)
+		c.uses = append(c.uses, taskUse{parent: t, slot: i})
+		t.children[i] = c
+	}
+	return t
+}
+
+// need marks t and everything it waits for as needed, unless it is done.
+func (t *taskNode) need() {
+	if t.done || t.needed {
+		return
+	}
+	t.needed = true
+	for _, child := range t.children {
+		if child != nil {
+			child.need()
+		}
+	}
+}
+
+// PlannedTask is an operation of an expression, numbered the way
+// ProcessExpression numbers its tasks. Operands holds the operands known
+// before anything is computed, in text form; the slots filled in by child
+// tasks are nil. A task
+// whose subtree appears more than once in the expression is planned once:
+// Shared holds the ids of the other occurrences and Parents every node that
+// uses its result, of which Parent is the first.
+type PlannedTask struct {
+	Node     int
+	Parent   int
+	Parents  []int
+	Children []int
+	Shared   []int
+	Operator string
+	Operands []*string
+}
+
+// PlanTasks returns every task ProcessExpression creates for expr after
+// optimising it with policy, ordered by node id, and the precision the tasks
+// are computed in, resolved from precision. An expression without
+// operations has no tasks.
+func PlanTasks(expr string, variables map[string]float64, precision numeric.Precision, policy OptimizePolicy) ([]PlannedTask, numeric.Precision, error) {
+	root, err := ParseWithVariables(expr, variables)
+	if err != nil {
+		return nil, numeric.Precision{}, err
+	}
+	if precision, err = ResolvePrecision(root, precision); err != nil {
+		return nil, numeric.Precision{}, err
+	}
+	optimized := optimize(root, policy)
+	if optimized.root.IsLeaf() {
+		return nil, precision, nil
+	}
+
+	g := buildGraph(optimized, nil)
+	plan := make([]PlannedTask, len(g.nodes))
+	index := make(map[int]int, len(g.nodes))
+	for i, t := range g.nodes {
+		index[t.id] = i
+		plan[i] = PlannedTask{Node: t.id, Shared: t.shared, Operator: t.op, Operands: make([]*string, len(t.args))}
+		for slot, arg := range t.args {
+			if t.children[slot] == nil {
+				plan[i].Operands[slot] = &arg
+			}
+		}
+	}
+	for i, t := range g.nodes {
+		for _, use := range t.uses {
+			parent := &plan[index[use.parent.id]]
+			if !slices.Contains(parent.Children, t.id) {
+				parent.Children = append(parent.Children, t.id)
+			}
+			if !slices.Contains(plan[i].Parents, use.parent.id) {
+				plan[i].Parents = append(plan[i].Parents, use.parent.id)
+			}
+		}
+		if len(plan[i].Parents) > 0 {
+			plan[i].Parent = plan[i].Parents[0]
+		}
+	}
+	return plan, precision, nil
+}
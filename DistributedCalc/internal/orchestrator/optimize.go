@@ -0,0 +1,148 @@
+package orchestrator
+
+import (
+	"DistributedCalc/internal/numeric"
+	"DistributedCalc/internal/operators"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// OptimizePolicy decides how an expression is rewritten between parsing and
+// task creation. Deduplicating identical subtrees never changes the result.
+// Folding identities such as x*1 and x+0 may: in decimal precision the
+// folded operation no longer rounds its result.
+type OptimizePolicy struct {
+	Deduplicate    bool
+	FoldIdentities bool
+}
+
+func DefaultOptimizePolicy() OptimizePolicy {
+	return OptimizePolicy{Deduplicate: true}
+}
+
+// OptimizePolicyFromEnv reads OPTIMIZE_DEDUPLICATE and
+// OPTIMIZE_FOLD_IDENTITIES on top of the defaults.
+func OptimizePolicyFromEnv() OptimizePolicy {
+	policy := DefaultOptimizePolicy()
+	if b, err := strconv.ParseBool(os.Getenv("OPTIMIZE_DEDUPLICATE")); err == nil {
+		policy.Deduplicate = b
+	}
+	if b, err := strconv.ParseBool(os.Getenv("OPTIMIZE_FOLD_IDENTITIES")); err == nil {
+		policy.FoldIdentities = b
+	}
+	return policy
+}
+
+// optimized is a parsed expression after the optimisation pass. Identical
+// subtrees are one node, so root may be a DAG. Operations keep the id they
+// have in the tree as parsed, numbered in pre-order starting at 1, so that
+// the ids of persisted tasks do not depend on the policy. shared lists the
+// ids of the other occurrences that a deduplicated node stands for.
+type optimized struct {
+	root   *Node
+	ids    map[*Node]int
+	shared map[*Node][]int
+}
+
+func optimize(root *Node, policy OptimizePolicy) *optimized {
+	o := &optimizer{
+		policy: policy,
+		result: &optimized{ids: make(map[*Node]int), shared: make(map[*Node][]int)},
+		nodes:  make(map[string]*Node),
+	}
+	o.result.root = o.visit(root)
+	return o.result
+}
+
+type optimizer struct {
+	policy OptimizePolicy
+	result *optimized
+	size   int
+	// nodes holds every operation by its key, see key.
+	nodes map[string]*Node
+}
+
+func (o *optimizer) visit(n *Node) *Node {
+	if n.IsLeaf() {
+		return n
+	}
+	o.size++
+	id := o.size
+	m := &Node{Op: n.Op, Args: make([]*Node, len(n.Args))}
+	for i, arg := range n.Args {
+		m.Args[i] = o.visit(arg)
+	}
+
+	if o.policy.FoldIdentities {
+		if operand := foldIdentity(m); operand != nil {
+			return operand
+		}
+	}
+	if o.policy.Deduplicate {
+		key := o.key(m)
+		if same, ok := o.nodes[key]; ok {
+			o.result.shared[same] = append(o.result.shared[same], id)
+			return same
+		}
+		o.nodes[key] = m
+	}
+	o.result.ids[m] = id
+	return m
+}
+
+// key identifies the subtree of an operation whose operands are already
+// deduplicated: numbers by their text, operations by their id.
+func (o *optimizer) key(n *Node) string {
+	var b strings.Builder
+	b.WriteString(n.Op)
+	for _, arg := range n.Args {
+		if arg.IsLeaf() {
+			b.WriteString(" #")
+			b.WriteString(arg.Number())
+		} else {
+			b.WriteString(" @")
+			b.WriteString(strconv.Itoa(o.result.ids[arg]))
+		}
+	}
+	return b.String()
+}
+
+// foldIdentity returns the operand x of x+0, 0+x, x-0, x*1, 1*x, x/1 and
+// x^1, or nil if n is none of them.
+func foldIdentity(n *Node) *Node {
+	if len(n.Args) != 2 {
+		return nil
+	}
+	x, y := n.Args[0], n.Args[1]
+	switch n.Op {
+	case operators.Add:
+		if isNumber(y, 0) {
+			return x
+		}
+		if isNumber(x, 0) {
+			return y
+		}
+	case operators.Subtract:
+		if isNumber(y, 0) {
+			return x
+		}
+	case operators.Multiply:
+		if isNumber(y, 1) {
+			return x
+		}
+		if isNumber(x, 1) {
+			return y
+		}
+	case operators.Divide, operators.Power:
+		if isNumber(y, 1) {
+			return x
+		}
+	}
+	return nil
+}
+
+// isNumber reports whether n is a number exactly equal to value.
+func isNumber(n *Node, value int64) bool {
+	return n.IsLeaf() && numeric.Equals(n.Number(), value)
+}
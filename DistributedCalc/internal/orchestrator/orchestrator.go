@@ -1,298 +1,387 @@
-package orchestrator
-
-import (
-	"DistributedCalc/internal/grpc"
-	"DistributedCalc/internal/storage"
-	"DistributedCalc/pkg/logger"
-	"context"
-	"fmt"
-	"os"
-	"strconv"
-	"strings"
-	"sync"
-)
-
-type Orchestrator struct {
-	db     *storage.SQLiteDB
-	logr   *logger.Logger
-	client *grpc.Client
-}
-
-func NewOrchestrator(db *storage.SQLiteDB, logr *logger.Logger) *Orchestrator {
-	return &Orchestrator{db: db, logr: logr}
-}
-
-func (o *Orchestrator) SetGRPCClient(client *grpc.Client) {
-	o.client = client
-}
-
-func (o *Orchestrator) ProcessExpression(ctx context.Context, expr string, exprID int64) (float64, error) {
-	o.logr.Info("Processing expression %s (ID: %d)", expr, exprID)
-	tokens := Tokenize(expr)
-	ops := []string{}
-	vals := []float64{}
-	var mu sync.Mutex
-
-	taskChan := make(chan struct{}, 10)
-	var wg sync.WaitGroup
-
-	for _, token := range tokens {
-		if IsNumber(token) {
-			num, err := parseNumber(token)
-			if err != nil {
-				return 0, err
-			}
-			mu.Lock()
-			vals = append(vals, num)
-			mu.Unlock()
-		} else if token == "(" {
-			mu.Lock()
-			ops = append(ops, token)
-			mu.Unlock()
-		} else if token == ")" {
-			mu.Lock()
-			for len(ops) > 0 && ops[len(ops)-1] != "(" {
-				op := ops[len(ops)-1]
-				ops = ops[:len(ops)-1]
-				if len(vals) < 2 {
-					mu.Unlock()
-					return 0, NewInvalidExpressionError()
-				}
-				b := vals[len(vals)-1]
-				vals = vals[:len(vals)-1]
-				a := vals[len(vals)-1]
-				vals = vals[:len(vals)-1]
-				mu.Unlock()
-
-				operationTime := getOperationTime(op)
-				taskID, err := o.db.SaveTask(exprID, a, b, op, operationTime)
-				if err != nil {
-					return 0, NewTaskDistributionError("failed to save task")
-				}
-
-				wg.Add(1)
-				taskChan <- struct{}{}
-				go func(a, b float64, op string, taskID int64) {
-					defer wg.Done()
-					defer func() { <-taskChan }()
-					resp, err := o.client.Calculate(ctx, formatTask(a, b, op))
-					if err != nil {
-						o.logr.Error("gRPC calculation failed for task %d: %v", taskID, err)
-						o.db.UpdateTaskResult(taskID, 0, "error")
-						return
-					}
-					if resp.Error != "" {
-						o.logr.Error("Calculation error for task %d: %s", taskID, resp.Error)
-						o.db.UpdateTaskResult(taskID, 0, "error")
-						return
-					}
-					mu.Lock()
-					vals = append(vals, resp.Result)
-					mu.Unlock()
-					o.db.UpdateTaskResult(taskID, resp.Result, "completed")
-				}(a, b, op, taskID)
-			}
-			if len(ops) == 0 {
-				mu.Unlock()
-				return 0, NewInvalidExpressionError()
-			}
-			ops = ops[:len(ops)-1]
-			mu.Unlock()
-		} else if IsOperator(token) {
-			mu.Lock()
-			for len(ops) > 0 && ops[len(ops)-1] != "(" && Precedence(ops[len(ops)-1]) >= Precedence(token) {
-				op := ops[len(ops)-1]
-				ops = ops[:len(ops)-1]
-				if len(vals) < 2 {
-					mu.Unlock()
-					return 0, NewInvalidExpressionError()
-				}
-				b := vals[len(vals)-1]
-				vals = vals[:len(vals)-1]
-				a := vals[len(vals)-1]
-				vals = vals[:len(vals)-1]
-				mu.Unlock()
-
-				operationTime := getOperationTime(op)
-				taskID, err := o.db.SaveTask(exprID, a, b, op, operationTime)
-				if err != nil {
-					return 0, NewTaskDistributionError("failed to save task")
-				}
-
-				wg.Add(1)
-				taskChan <- struct{}{}
-				go func(a, b float64, op string, taskID int64) {
-					defer wg.Done()
-					defer func() { <-taskChan }()
-					resp, err := o.client.Calculate(ctx, formatTask(a, b, op))
-					if err != nil {
-						o.logr.Error("gRPC calculation failed for task %d: %v", taskID, err)
-						o.db.UpdateTaskResult(taskID, 0, "error")
-						return
-					}
-					if resp.Error != "" {
-						o.logr.Error("Calculation error for task %d: %s", taskID, resp.Error)
-						o.db.UpdateTaskResult(taskID, 0, "error")
-						return
-					}
-					mu.Lock()
-					vals = append(vals, resp.Result)
-					mu.Unlock()
-					o.db.UpdateTaskResult(taskID, resp.Result, "completed")
-				}(a, b, op, taskID)
-			}
-			ops = append(ops, token)
-			mu.Unlock()
-		} else {
-			return 0, NewInvalidExpressionError()
-		}
-	}
-
-	mu.Lock()
-	for len(ops) > 0 {
-		if ops[len(ops)-1] == "(" {
-			mu.Unlock()
-			return 0, NewInvalidExpressionError()
-		}
-		op := ops[len(ops)-1]
-		ops = ops[:len(ops)-1]
-		if len(vals) < 2 {
-			mu.Unlock()
-			return 0, NewInvalidExpressionError()
-		}
-		b := vals[len(vals)-1]
-		vals = vals[:len(vals)-1]
-		a := vals[len(vals)-1]
-		vals = vals[:len(vals)-1]
-		mu.Unlock()
-
-		operationTime := getOperationTime(op)
-		taskID, err := o.db.SaveTask(exprID, a, b, op, operationTime)
-		if err != nil {
-			return 0, NewTaskDistributionError("failed to save task")
-		}
-
-		wg.Add(1)
-		taskChan <- struct{}{}
-		go func(a, b float64, op string, taskID int64) {
-			defer wg.Done()
-			defer func() { <-taskChan }()
-			resp, err := o.client.Calculate(ctx, formatTask(a, b, op))
-			if err != nil {
-				o.logr.Error("gRPC calculation failed for task %d: %v", taskID, err)
-				o.db.UpdateTaskResult(taskID, 0, "error")
-				return
-			}
-			if resp.Error != "" {
-				o.logr.Error("Calculation error for task %d: %s", taskID, resp.Error)
-				o.db.UpdateTaskResult(taskID, 0, "error")
-				return
-			}
-			mu.Lock()
-			vals = append(vals, resp.Result)
-			mu.Unlock()
-			o.db.UpdateTaskResult(taskID, resp.Result, "completed")
-		}(a, b, op, taskID)
-	}
-	mu.Unlock()
-
-	wg.Wait()
-
-	mu.Lock()
-	defer mu.Unlock()
-	if len(vals) != 1 {
-		return 0, NewInvalidExpressionError()
-	}
-
-	return vals[0], nil
-}
-
-func Tokenize(expr string) []string {
-	var tokens []string
-	var num strings.Builder
-	for i := 0; i < len(expr); i++ {
-		ch := expr[i]
-		if isDigit(ch) || ch == '.' {
-			num.WriteByte(ch)
-		} else {
-			if num.Len() > 0 {
-				tokens = append(tokens, num.String())
-				num.Reset()
-			}
-			if IsOperator(string(ch)) || ch == '(' || ch == ')' {
-				tokens = append(tokens, string(ch))
-			}
-		}
-	}
-	if num.Len() > 0 {
-		tokens = append(tokens, num.String())
-	}
-	return tokens
-}
-
-func parseNumber(token string) (float64, error) {
-	num, err := strconv.ParseFloat(token, 64)
-	if err != nil {
-		return 0, NewInvalidExpressionError()
-	}
-	return num, nil
-}
-
-func getOperationTime(op string) int {
-	var envVar string
-	switch op {
-	case "+":
-		envVar = "TIME_ADDITION_MS"
-	case "-":
-		envVar = "TIME_SUBTRACTION_MS"
-	case "*":
-		envVar = "TIME_MULTIPLICATIONS_MS"
-	case "/":
-		envVar = "TIME_DIVISIONS_MS"
-	default:
-		return 100
-	}
-	ms, _ := strconv.Atoi(os.Getenv(envVar))
-	if ms <= 0 {
-		ms = 100
-	}
-	return ms
-}
-
-func formatTask(a, b float64, op string) string {
-	return fmt.Sprintf("%f%s%f", a, op, b)
-}
-
-func IsValidExpression(expr string) bool {
-	allowed := "0123456789+-*/()."
-	for _, ch := range expr {
-		if !strings.ContainsRune(allowed, ch) {
-			return false
-		}
-	}
-	return true
-}
-
-func isDigit(ch byte) bool {
-	return ch >= '0' && ch <= '9'
-}
-
-func IsNumber(token string) bool {
-	_, err := strconv.ParseFloat(token, 64)
-	return err == nil
-}
-
-func IsOperator(token string) bool {
-	return token == "+" || token == "-" || token == "*" || token == "/"
-}
-
-func Precedence(op string) int {
-	switch op {
-	case "+", "-":
-		return 1
-	case "*", "/":
-		return 2
-	default:
-		return 0
-	}
-}
+package orchestrator
+
+import (
+	"DistributedCalc/internal/events"
+	"DistributedCalc/internal/grpc"
+	"DistributedCalc/internal/numeric"
+	"DistributedCalc/internal/operators"
+	"DistributedCalc/internal/storage"
+	"DistributedCalc/pkg/logger"
+	"DistributedCalc/pkg/retry"
+	"context"
+	"sync"
+	"time"
+)
+
+const maxParallelTasks = 10
+
+// leaseOwner holds the lease of tasks the orchestrator dispatches over the
+// task channel, so that HTTP agents do not claim them as well.
+const leaseOwner = "orchestrator"
+
+// taskPollInterval is how often the orchestrator checks whether an HTTP
+// agent has completed a task without being told, for example because the
+// agent reported to another instance.
+const taskPollInterval = 5 * time.Second
+
+type Orchestrator struct {
+	db          *storage.SQLiteDB
+	logr        *logger.Logger
+	client      grpc.TaskExecutor
+	retry       retry.Policy
+	optimize    OptimizePolicy
+	taskTimeout time.Duration
+
+	mu       sync.Mutex
+	finished map[int64]chan struct{}
+}
+
+func NewOrchestrator(db *storage.SQLiteDB, logr *logger.Logger) *Orchestrator {
+	return &Orchestrator{
+		db:       db,
+		logr:     logr,
+		retry:    DefaultRetryPolicy(),
+		optimize: DefaultOptimizePolicy(),
+		finished: make(map[int64]chan struct{}),
+	}
+}
+
+func (o *Orchestrator) SetRetryPolicy(policy retry.Policy) {
+	o.retry = policy
+}
+
+// SetOptimizePolicy sets how expressions are optimised before their tasks
+// are created.
+func (o *Orchestrator) SetOptimizePolicy(policy OptimizePolicy) {
+	o.optimize = policy
+}
+
+// SetTaskTimeout limits how long a single attempt to compute a task may
+// take. An attempt that runs out of time is retried like a transport failure.
+// Zero, the default, only limits attempts by the deadline of the expression.
+func (o *Orchestrator) SetTaskTimeout(timeout time.Duration) {
+	o.taskTimeout = timeout
+}
+
+// SetGRPCClient sets the client tasks are dispatched with. Without one,
+// tasks are left pending for HTTP agents to claim.
+func (o *Orchestrator) SetGRPCClient(client grpc.TaskExecutor) {
+	o.client = client
+}
+
+// Publish wakes the handOff waiting for a task that has finished. Add the
+// orchestrator to the publishers of the database so that tasks computed by
+// HTTP agents are picked up as soon as they are reported.
+func (o *Orchestrator) Publish(event events.Event) {
+	if event.Type != events.TypeTask {
+		return
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if ch, ok := o.finished[event.TaskID]; ok {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// ProcessExpression is Process for an expression computed in float64.
+func (o *Orchestrator) ProcessExpression(ctx context.Context, expr string, variables map[string]float64, exprID int64) (float64, error) {
+	value, err := o.Process(ctx, storage.Expression{ID: exprID, Expression: expr, Variables: variables})
+	if err != nil {
+		return 0, err
+	}
+	return numeric.Float(value), nil
+}
+
+// Process parses an expression with its variable bindings, optimises it
+// and turns it into a task graph, then dispatches every task whose operands
+// are known. Independent subtrees run in parallel and each result is written
+// into the operand slots that use it, so the outcome does not depend on the
+// order in which agents answer. Identical subtrees are computed once.
+// Operands and the result are numbers in text form, computed in the
+// precision of the expression.
+//
+// If the expression was already being processed, for example before the
+// service restarted, the graph is rebuilt from its persisted tasks: completed
+// results are reused and unfinished tasks are taken over instead of being
+// created again.
+func (o *Orchestrator) Process(ctx context.Context, expr storage.Expression) (string, error) {
+	exprID := expr.ID
+	o.logr.Info("Processing expression %s (ID: %d)", expr.Expression, exprID)
+	root, err := ParseWithVariables(expr.Expression, expr.Variables)
+	if err != nil {
+		return "", err
+	}
+	precision, err := ResolvePrecision(root, expr.Precision)
+	if err != nil {
+		return "", err
+	}
+	optimized := optimize(root, o.optimize)
+	if optimized.root.IsLeaf() {
+		return numeric.Format(optimized.root.Number(), precision)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	persisted, err := o.db.GetExpressionTasks(exprID)
+	if err != nil {
+		return "", NewTaskDistributionError("failed to load tasks")
+	}
+	results := make(map[int]string)
+	unfinished := make(map[int]int64)
+	for _, task := range persisted {
+		if task.Node == 0 {
+			continue
+		}
+		if task.Status == "completed" {
+			results[task.Node] = task.Value
+		} else {
+			unfinished[task.Node] = task.ID
+		}
+	}
+	if len(persisted) > 0 {
+		o.logr.Info("Resuming expression %d with %d of its tasks completed", exprID, len(results))
+	}
+
+	graph := buildGraph(optimized, results)
+	if graph.root.done {
+		return graph.root.result, nil
+	}
+	sem := make(chan struct{}, maxParallelTasks)
+	var (
+		mu       sync.Mutex
+		wg       sync.WaitGroup
+		firstErr error
+	)
+
+	var dispatch func(t *taskNode)
+	dispatch = func(t *taskNode) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			result, err := o.runTask(ctx, exprID, precision, t, unfinished[t.id])
+			<-sem
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				if firstErr == nil {
+					firstErr = err
+					cancel()
+				}
+				return
+			}
+			t.result = result
+			t.done = true
+			for _, use := range t.uses {
+				p := use.parent
+				if p.done {
+					continue
+				}
+				p.args[use.slot] = result
+				p.pending--
+				if p.pending == 0 {
+					dispatch(p)
+				}
+			}
+		}()
+	}
+
+	mu.Lock()
+	for _, t := range graph.ready {
+		dispatch(t)
+	}
+	mu.Unlock()
+	wg.Wait()
+
+	if firstErr != nil {
+		return "", firstErr
+	}
+	if !graph.root.done {
+		return "", NewTaskDistributionError(ctx.Err().Error())
+	}
+	return graph.root.result, nil
+}
+
+// runTask computes t. If taskID is set, the persisted task left unfinished
+// by an earlier run is taken over; otherwise a new one is saved.
+func (o *Orchestrator) runTask(ctx context.Context, exprID int64, precision numeric.Precision, t *taskNode, taskID int64) (string, error) {
+	if o.client == nil {
+		return o.handOff(ctx, exprID, precision, t, taskID)
+	}
+
+	// Attempts of a resumed task are numbered after the ones it already has.
+	previous := 0
+	if taskID != 0 {
+		if err := o.db.ResumeTask(taskID, leaseOwner); err != nil {
+			return "", NewTaskDistributionError("failed to resume task")
+		}
+		attempts, _ := o.db.GetTaskAttempts(taskID)
+		previous = len(attempts)
+	} else {
+		var err error
+		if taskID, err = o.saveTask(exprID, precision, t, leaseOwner); err != nil {
+			return "", err
+		}
+	}
+
+	// Transport failures and attempts that time out are retried with
+	// backoff. Once the attempts are used up the task is dead-lettered and the
+	// expression fails.
+	var lastErr error
+	for attempt := 1; attempt <= o.retry.MaxAttempts; attempt++ {
+		if attempt > 1 {
+			select {
+			case <-time.After(o.retry.Backoff(attempt - 1)):
+			case <-ctx.Done():
+				return "", ctx.Err()
+			}
+		}
+
+		started := time.Now()
+		resp, err := o.attempt(ctx, grpc.NewExactTaskRequest(taskID, exprID, t.op, t.args, precision))
+		if err == nil && resp.AgentId != "" {
+			o.db.AssignTask(taskID, resp.AgentId)
+		}
+		record := storage.TaskAttempt{TaskID: taskID, Attempt: previous + attempt, StartedAt: started, FinishedAt: time.Now()}
+		if err == nil && resp.ErrorCode == grpc.ErrorCode_ERROR_CODE_DEADLINE_EXCEEDED {
+			err = context.DeadlineExceeded
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return "", ctx.Err()
+			}
+			o.logr.Error("gRPC calculation failed for task %d (attempt %d): %v", taskID, attempt, err)
+			record.Error = err.Error()
+			o.db.RecordTaskAttempt(record)
+			lastErr = err
+			continue
+		}
+		if resp.ErrorCode != grpc.ErrorCode_ERROR_CODE_NONE {
+			o.logr.Error("Calculation error for task %d: %s (%s)", taskID, resp.Error, resp.ErrorCode)
+			record.Error = resp.Error
+			o.db.RecordTaskAttempt(record)
+			o.db.CompleteTask(taskID, leaseOwner, "", "error", resp.Error)
+			return "", NewCalculationError(t.op, resp.Error)
+		}
+		// Agents that predate text values only answer with a float64,
+		// which cannot stand in for an exact or complex result.
+		value := resp.Value
+		if value == "" && !precision.IsFloat64() {
+			record.Error = NewNoExactResultError().Error()
+			o.db.RecordTaskAttempt(record)
+			o.db.CompleteTask(taskID, leaseOwner, "", "error", record.Error)
+			return "", NewCalculationError(t.op, record.Error)
+		}
+		if value == "" {
+			value = numeric.FormatFloat(resp.Result)
+		}
+		o.db.RecordTaskAttempt(record)
+		o.db.CompleteTask(taskID, leaseOwner, value, "completed", "")
+		return value, nil
+	}
+
+	o.logr.Error("Task %d dead-lettered after %d attempts", taskID, o.retry.MaxAttempts)
+	deadLettered := NewTaskDeadLetteredError(taskID, o.retry.MaxAttempts, lastErr)
+	o.db.CompleteTask(taskID, leaseOwner, "", "dead_letter", deadLettered.Error())
+	return "", deadLettered
+}
+
+// handOff leaves t to HTTP agents and waits until one of them has computed
+// it. An agent that does not report before its lease expires loses the task
+// to the next one that claims it. The wait ends when Publish reports the
+// task finished, or at the latest on the next taskPollInterval.
+func (o *Orchestrator) handOff(ctx context.Context, exprID int64, precision numeric.Precision, t *taskNode, taskID int64) (string, error) {
+	if taskID != 0 {
+		if err := o.db.RequeueTask(taskID); err != nil {
+			return "", NewTaskDistributionError("failed to resume task")
+		}
+	} else {
+		var err error
+		if taskID, err = o.saveTask(exprID, precision, t, ""); err != nil {
+			return "", err
+		}
+	}
+
+	// A report that arrives before the watch is found by the first GetTask.
+	finished := make(chan struct{}, 1)
+	o.watchTask(taskID, finished)
+	defer o.unwatchTask(taskID)
+	ticker := time.NewTicker(taskPollInterval)
+	defer ticker.Stop()
+	for {
+		task, err := o.db.GetTask(taskID)
+		if err != nil {
+			return "", NewTaskDistributionError("failed to load task")
+		}
+		switch task.Status {
+		case "completed":
+			return task.Value, nil
+		case "error":
+			return "", NewCalculationError(t.op, task.Error)
+		}
+		select {
+		case <-finished:
+		case <-ticker.C:
+		case <-ctx.Done():
+			return "", ctx.Err()
+		}
+	}
+}
+
+func (o *Orchestrator) watchTask(taskID int64, finished chan struct{}) {
+	o.mu.Lock()
+	o.finished[taskID] = finished
+	o.mu.Unlock()
+}
+
+func (o *Orchestrator) unwatchTask(taskID int64) {
+	o.mu.Lock()
+	delete(o.finished, taskID)
+	o.mu.Unlock()
+}
+
+// saveTask stores t leased to owner, or pending if owner is empty, together
+// with the nodes it is shared by.
+func (o *Orchestrator) saveTask(exprID int64, precision numeric.Precision, t *taskNode, owner string) (int64, error) {
+	taskID, err := o.db.SaveLeasedTask(exprID, t.id, t.args, precision, t.op, getOperationTime(t.op), owner)
+	if err != nil {
+		return 0, NewTaskDistributionError("failed to save task")
+	}
+	if len(t.shared) > 0 {
+		if err := o.db.ShareTask(taskID, t.shared); err != nil {
+			return 0, NewTaskDistributionError("failed to save task")
+		}
+	}
+	return taskID, nil
+}
+
+// attempt sends req once. Its deadline, the earlier of the task timeout and
+// the deadline of ctx, travels with the request so the agent stops as well.
+func (o *Orchestrator) attempt(ctx context.Context, req *grpc.TaskRequest) (*grpc.TaskResponse, error) {
+	if o.taskTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, o.taskTimeout)
+		defer cancel()
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		req.DeadlineUnixMs = deadline.UnixMilli()
+	}
+	return o.client.CalculateTask(ctx, req)
+}
+
+func getOperationTime(op string) int {
+	operator, ok := operators.Lookup(op)
+	if !ok {
+		return 100
+	}
+	return operator.DurationMS()
+}
@@ -1,110 +1,638 @@
-package orchestrator
-
-import (
-	"DistributedCalc/internal/grpc"
-	"DistributedCalc/internal/storage"
-	"DistributedCalc/pkg/logger"
-	"context"
-	"strconv"
-	"testing"
-)
-
-func TestOrchestrator_ProcessExpression(t *testing.T) {
-	logr := logger.NewLogger()
-	dbConn, err := storage.NewSQLiteDB(":memory:", logr)
-	if err != nil {
-		t.Fatalf("Failed to create DB: %v", err)
-	}
-	defer dbConn.Close()
-
-	orch := NewOrchestrator(dbConn, logr)
-	clientMock := &grpc.ClientMock{
-		CalculateFunc: func(ctx context.Context, expr string) (*grpc.CalcResponse, error) {
-			tokens := Tokenize(expr)
-			ops := []string{}
-			vals := []float64{}
-
-			for _, token := range tokens {
-				if IsNumber(token) {
-					num, _ := strconv.ParseFloat(token, 64)
-					vals = append(vals, num)
-				} else if IsOperator(token) {
-					if len(vals) < 2 {
-						return &grpc.CalcResponse{Error: "invalid expression"}, nil
-					}
-					b := vals[len(vals)-1]
-					vals = vals[:len(vals)-1]
-					a := vals[len(vals)-1]
-					vals = vals[:len(vals)-1]
-					var result float64
-					switch token {
-					case "+":
-						result = a + b
-					case "-":
-						result = a - b
-					case "*":
-						result = a * b
-					case "/":
-						if b == 0 {
-							return &grpc.CalcResponse{Error: "division by zero"}, nil
-						}
-						result = a / b
-					}
-					vals = append(vals, result)
-				}
-			}
-			if len(vals) != 1 {
-				return &grpc.CalcResponse{Error: "invalid expression"}, nil
-			}
-			return &grpc.CalcResponse{Result: vals[0]}, nil
-		},
-	}
-	orch.SetGRPCClient(clientMock)
-
-	tests := []struct {
-		name     string
-		expr     string
-		exprID   int64
-		expected float64
-		err      error
-	}{
-		{
-			name:     "Simple addition",
-			expr:     "2+2",
-			exprID:   1,
-			expected: 4,
-			err:      nil,
-		},
-		{
-			name:     "Complex expression",
-			expr:     "2+2*2",
-			exprID:   2,
-			expected: 6,
-			err:      nil,
-		},
-		{
-			name:   "Invalid expression",
-			expr:   "2/0",
-			exprID: 3,
-			err:    NewInvalidExpressionError(),
-		},
-	}
-
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			result, err := orch.ProcessExpression(context.Background(), tt.expr, tt.exprID)
-			if tt.err != nil {
-				if err == nil || err.Error() != tt.err.Error() {
-					t.Errorf("Expected error %v, got %v", tt.err, err)
-				}
-				return
-			}
-			if err != nil {
-				t.Errorf("Unexpected error: %v", err)
-			}
-			if result != tt.expected {
-				t.Errorf("Expected %f, got %f", tt.expected, result)
-			}
-		})
-	}
-}
+package orchestrator
+
+import (
+	"DistributedCalc/internal/grpc"
+	"DistributedCalc/internal/numeric"
+	"DistributedCalc/internal/operators"
+	"DistributedCalc/internal/storage"
+	"DistributedCalc/pkg/logger"
+	"DistributedCalc/pkg/retry"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestOrchestrator_ProcessExpression(t *testing.T) {
+	logr := logger.NewLogger()
+	dbConn, err := storage.NewSQLiteDB(":memory:", logr)
+	if err != nil {
+		t.Fatalf("Failed to create DB: %v", err)
+	}
+	defer dbConn.Close()
+
+	orch := NewOrchestrator(dbConn, logr)
+	clientMock := &grpc.ClientMock{
+		CalculateTaskFunc: func(ctx context.Context, req *grpc.TaskRequest) (*grpc.TaskResponse, error) {
+			args := req.Operands()
+			// Answer multiplications last so that results arrive out of order.
+			if req.Operator == grpc.Operator_OPERATOR_MULTIPLY {
+				time.Sleep(time.Duration(args[0]) * time.Millisecond)
+			}
+			operator, ok := operators.Lookup(req.Operator.Symbol())
+			if !ok || !operator.AcceptsArgs(len(args)) {
+				return &grpc.TaskResponse{TaskId: req.TaskId, ErrorCode: grpc.ErrorCode_ERROR_CODE_INVALID_OPERATOR}, nil
+			}
+			result, err := operator.Apply(args)
+			if err != nil {
+				return &grpc.TaskResponse{TaskId: req.TaskId, ErrorCode: grpc.ErrorCode_ERROR_CODE_DOMAIN_ERROR, Error: err.Error()}, nil
+			}
+			return &grpc.TaskResponse{TaskId: req.TaskId, Result: result}, nil
+		},
+	}
+	orch.SetGRPCClient(clientMock)
+
+	tests := []struct {
+		name      string
+		expr      string
+		variables map[string]float64
+		exprID    int64
+		expected  float64
+		err       error
+	}{
+		{
+			name:     "Simple addition",
+			expr:     "2+2",
+			exprID:   1,
+			expected: 4,
+			err:      nil,
+		},
+		{
+			name:     "Complex expression",
+			expr:     "2+2*2",
+			exprID:   2,
+			expected: 6,
+			err:      nil,
+		},
+		{
+			name:     "Independent subtrees",
+			expr:     "5*3-2*2",
+			exprID:   3,
+			expected: 11,
+			err:      nil,
+		},
+		{
+			name:     "Parentheses",
+			expr:     "(8-2)/(1+2)",
+			exprID:   4,
+			expected: 2,
+			err:      nil,
+		},
+		{
+			name:     "Left associativity",
+			expr:     "10-4-3",
+			exprID:   5,
+			expected: 3,
+			err:      nil,
+		},
+		{
+			name:     "Signed literals",
+			expr:     "-3+5*-2",
+			exprID:   6,
+			expected: -13,
+			err:      nil,
+		},
+		{
+			name:     "Negated subexpression",
+			expr:     "-(1+2)*+4",
+			exprID:   7,
+			expected: -12,
+			err:      nil,
+		},
+		{
+			name:     "Power is right associative",
+			expr:     "2^3^2",
+			exprID:   8,
+			expected: 512,
+			err:      nil,
+		},
+		{
+			name:     "Modulo and integer division",
+			expr:     "-7//2+7%3",
+			exprID:   9,
+			expected: -3,
+			err:      nil,
+		},
+		{
+			name:     "Function calls",
+			expr:     "max(2, sqrt(16), 1) + abs(-3)",
+			exprID:   12,
+			expected: 7,
+			err:      nil,
+		},
+		{
+			name:      "Variables and constants",
+			expr:      "rate*hours+round(pi)",
+			variables: map[string]float64{"rate": 40, "hours": 7.5},
+			exprID:    15,
+			expected:  303,
+			err:       nil,
+		},
+		{
+			name:   "Undefined variable",
+			expr:   "rate*2",
+			exprID: 16,
+			err:    NewUndefinedVariableError("rate", 1),
+		},
+		{
+			name:   "Unknown function",
+			expr:   "foo(1)",
+			exprID: 13,
+			err:    NewUnknownFunctionError("foo", 1),
+		},
+		{
+			name:   "Wrong number of arguments",
+			expr:   "sqrt(1, 2)",
+			exprID: 14,
+			err:    NewFunctionArityError("sqrt", 1, 1, 2, 1),
+		},
+		{
+			name:   "Unbalanced parentheses",
+			expr:   "(2+2",
+			exprID: 10,
+			err:    NewUnclosedParenError(1, 5),
+		},
+		{
+			name:   "Division by zero",
+			expr:   "2/0",
+			exprID: 11,
+			err:    NewCalculationError("/", "division by zero"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := orch.ProcessExpression(context.Background(), tt.expr, tt.variables, tt.exprID)
+			if tt.err != nil {
+				if err == nil || err.Error() != tt.err.Error() {
+					t.Errorf("Expected error %v, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("Unexpected error: %v", err)
+			}
+			if result != tt.expected {
+				t.Errorf("Expected %f, got %f", tt.expected, result)
+			}
+		})
+	}
+}
+
+func TestOrchestrator_Retries(t *testing.T) {
+	logr := logger.NewLogger()
+	dbConn, err := storage.NewSQLiteDB(":memory:", logr)
+	if err != nil {
+		t.Fatalf("Failed to create DB: %v", err)
+	}
+	defer dbConn.Close()
+
+	var mu sync.Mutex
+	calls := make(map[int64]int)
+	orch := NewOrchestrator(dbConn, logr)
+	orch.SetRetryPolicy(retry.Policy{MaxAttempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: 5 * time.Millisecond, Jitter: 0.5})
+	orch.SetGRPCClient(&grpc.ClientMock{
+		CalculateTaskFunc: func(ctx context.Context, req *grpc.TaskRequest) (*grpc.TaskResponse, error) {
+			mu.Lock()
+			calls[req.TaskId]++
+			call := calls[req.TaskId]
+			mu.Unlock()
+			args := req.Operands()
+			switch req.Operator {
+			case grpc.Operator_OPERATOR_ADD:
+				// Additions fail once and then succeed.
+				if call == 1 {
+					return nil, errors.New("connection reset")
+				}
+				return &grpc.TaskResponse{TaskId: req.TaskId, Result: args[0] + args[1]}, nil
+			case grpc.Operator_OPERATOR_DIVIDE:
+				// Agent errors are final and must not be retried.
+				return &grpc.TaskResponse{TaskId: req.TaskId, ErrorCode: grpc.ErrorCode_ERROR_CODE_DIVISION_BY_ZERO, Error: "division by zero"}, nil
+			default:
+				return nil, errors.New("connection refused")
+			}
+		},
+	})
+
+	result, err := orch.ProcessExpression(context.Background(), "1+2", nil, 1)
+	if err != nil || result != 3 {
+		t.Errorf("Expected 3 after a retry, got %f (%v)", result, err)
+	}
+	attempts, _ := dbConn.GetTaskAttempts(1)
+	if len(attempts) != 2 || attempts[0].Error != "connection reset" || attempts[1].Error != "" {
+		t.Errorf("Expected a failed and a successful attempt, got %+v", attempts)
+	}
+
+	_, err = orch.ProcessExpression(context.Background(), "1/0", nil, 2)
+	if err == nil || err.Error() != NewCalculationError("/", "division by zero").Error() {
+		t.Errorf("Expected division by zero, got %v", err)
+	}
+	if calls[2] != 1 {
+		t.Errorf("Expected division by zero not to be retried, got %d calls", calls[2])
+	}
+
+	_, err = orch.ProcessExpression(context.Background(), "5-2", nil, 3)
+	if err == nil || err.Error() != NewTaskDeadLetteredError(3, 3, errors.New("connection refused")).Error() {
+		t.Errorf("Expected the task to be dead-lettered, got %v", err)
+	}
+	attempts, _ = dbConn.GetTaskAttempts(3)
+	if len(attempts) != 3 {
+		t.Errorf("Expected 3 recorded attempts, got %d", len(attempts))
+	}
+}
+
+func TestOrchestrator_TaskTimeout(t *testing.T) {
+	logr := logger.NewLogger()
+	dbConn, err := storage.NewSQLiteDB(":memory:", logr)
+	if err != nil {
+		t.Fatalf("Failed to create DB: %v", err)
+	}
+	defer dbConn.Close()
+
+	var mu sync.Mutex
+	calls := 0
+	orch := NewOrchestrator(dbConn, logr)
+	orch.SetRetryPolicy(retry.Policy{MaxAttempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond})
+	orch.SetTaskTimeout(20 * time.Millisecond)
+	orch.SetGRPCClient(&grpc.ClientMock{
+		CalculateTaskFunc: func(ctx context.Context, req *grpc.TaskRequest) (*grpc.TaskResponse, error) {
+			mu.Lock()
+			calls++
+			call := calls
+			mu.Unlock()
+			if req.DeadlineUnixMs == 0 {
+				t.Error("Expected the request to carry the task deadline")
+			}
+			switch call {
+			case 1:
+				// A stuck agent: the attempt runs into the task timeout.
+				<-ctx.Done()
+				return nil, ctx.Err()
+			case 2:
+				// The agent itself notices the deadline.
+				return &grpc.TaskResponse{TaskId: req.TaskId, ErrorCode: grpc.ErrorCode_ERROR_CODE_DEADLINE_EXCEEDED, Error: "deadline exceeded"}, nil
+			default:
+				return &grpc.TaskResponse{TaskId: req.TaskId, Result: 3}, nil
+			}
+		},
+	})
+
+	result, err := orch.ProcessExpression(context.Background(), "1+2", nil, 1)
+	if err != nil || result != 3 {
+		t.Fatalf("Expected 3 after the timed out attempts, got %f (%v)", result, err)
+	}
+	attempts, _ := dbConn.GetTaskAttempts(1)
+	if len(attempts) != 3 || attempts[0].Error != context.DeadlineExceeded.Error() || attempts[1].Error != context.DeadlineExceeded.Error() || attempts[2].Error != "" {
+		t.Errorf("Expected two timed out attempts and a successful one, got %+v", attempts)
+	}
+}
+
+func TestOrchestrator_Resume(t *testing.T) {
+	logr := logger.NewLogger()
+	dbConn, err := storage.NewSQLiteDB(":memory:", logr)
+	if err != nil {
+		t.Fatalf("Failed to create DB: %v", err)
+	}
+	defer dbConn.Close()
+
+	// State left behind by a crash while computing (1+2)*(3+4): node 2 (1+2)
+	// is done and node 3 (3+4) was in flight.
+	userID, _ := dbConn.CreateUser("testuser", "hashedpassword")
+	exprID, _ := dbConn.SaveExpression(userID, "(1+2)*(3+4)", nil, time.Time{}, "")
+	doneID, _ := dbConn.SaveTask(exprID, 2, []string{"1", "2"}, numeric.Precision{}, "+", 100)
+	dbConn.LeaseTask(doneID, leaseOwner, 0)
+	dbConn.CompleteTask(doneID, leaseOwner, "3", "completed", "")
+	inFlightID, _ := dbConn.SaveTask(exprID, 3, []string{"3", "4"}, numeric.Precision{}, "+", 100)
+	dbConn.LeaseTask(inFlightID, leaseOwner, 0)
+
+	var mu sync.Mutex
+	var computed []string
+	orch := NewOrchestrator(dbConn, logr)
+	orch.SetGRPCClient(&grpc.ClientMock{
+		CalculateTaskFunc: func(ctx context.Context, req *grpc.TaskRequest) (*grpc.TaskResponse, error) {
+			mu.Lock()
+			computed = append(computed, req.Operator.Symbol())
+			mu.Unlock()
+			result, err := operators.Compute(ctx, req.Operator.Symbol(), req.Operands())
+			if err != nil {
+				return nil, err
+			}
+			return &grpc.TaskResponse{TaskId: req.TaskId, Result: result}, nil
+		},
+	})
+
+	result, err := orch.ProcessExpression(context.Background(), "(1+2)*(3+4)", nil, exprID)
+	if err != nil || result != 21 {
+		t.Fatalf("Expected 21, got %f (%v)", result, err)
+	}
+	if len(computed) != 2 || computed[0] != "+" || computed[1] != "*" {
+		t.Errorf("Expected only 3+4 and the multiplication to be computed, got %v", computed)
+	}
+
+	tasks, _ := dbConn.GetExpressionTasks(exprID)
+	if len(tasks) != 3 {
+		t.Fatalf("Expected the in-flight task to be reused, got %d tasks", len(tasks))
+	}
+	for _, task := range tasks {
+		if task.Status != "completed" {
+			t.Errorf("Expected task %d to be completed, got %s", task.ID, task.Status)
+		}
+	}
+	if tasks[1].ID != inFlightID || tasks[1].Result != 7 {
+		t.Errorf("Expected task %d to hold 7, got %+v", inFlightID, tasks[1])
+	}
+
+	// Once every task is done, the result comes straight from storage.
+	computed = nil
+	result, err = orch.ProcessExpression(context.Background(), "(1+2)*(3+4)", nil, exprID)
+	if err != nil || result != 21 || len(computed) != 0 {
+		t.Errorf("Expected 21 without computing anything, got %f (%v), computed %v", result, err, computed)
+	}
+}
+
+func TestOrchestrator_HTTPAgents(t *testing.T) {
+	logr := logger.NewLogger()
+	dbConn, err := storage.NewSQLiteDB(":memory:", logr)
+	if err != nil {
+		t.Fatalf("Failed to create DB: %v", err)
+	}
+	defer dbConn.Close()
+
+	// Without a gRPC client every task waits for an HTTP agent. The first
+	// agent lets its lease run out, so its task is claimed again.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	go func() {
+		abandoned := false
+		for ctx.Err() == nil {
+			dbConn.ReleaseExpiredLeases(time.Now())
+			lease := time.Minute
+			if !abandoned {
+				lease = -time.Second
+			}
+			task, err := dbConn.ClaimTask("agent-1", lease)
+			if err != nil {
+				time.Sleep(10 * time.Millisecond)
+				continue
+			}
+			if !abandoned {
+				abandoned = true
+				continue
+			}
+			value, err := numeric.Apply(task.Operator, task.Operands, task.Precision)
+			status, taskErr := "completed", ""
+			if err != nil {
+				status, taskErr = "error", err.Error()
+			}
+			dbConn.CompleteTask(task.ID, "agent-1", value, status, taskErr)
+		}
+	}()
+
+	userID, _ := dbConn.CreateUser("testuser", "hashedpassword")
+	exprID, _ := dbConn.SaveExpression(userID, "(1+2)*(3+4)", nil, time.Time{}, "")
+	orch := NewOrchestrator(dbConn, logr)
+	// Reports of the agent wake the orchestrator, well before the next poll.
+	dbConn.SetPublisher(orch)
+	started := time.Now()
+	result, err := orch.ProcessExpression(ctx, "(1+2)*(3+4)", nil, exprID)
+	if err != nil || result != 21 {
+		t.Fatalf("Expected 21, got %f (%v)", result, err)
+	}
+	if elapsed := time.Since(started); elapsed >= taskPollInterval {
+		t.Errorf("Expected the reports to end the wait, took %v", elapsed)
+	}
+	tasks, _ := dbConn.GetExpressionTasks(exprID)
+	for _, task := range tasks {
+		if task.Status != "completed" || task.Agent != "agent-1" {
+			t.Errorf("Expected task %d to be completed by agent-1, got %+v", task.ID, task)
+		}
+	}
+
+	exprID, _ = dbConn.SaveExpression(userID, "1/(2-2)", nil, time.Time{}, "")
+	expected := NewCalculationError("/", operators.NewDivisionByZeroError().Error())
+	if _, err := orch.ProcessExpression(ctx, "1/(2-2)", nil, exprID); err == nil || err.Error() != expected.Error() {
+		t.Errorf("Expected %v, got %v", expected, err)
+	}
+}
+
+func TestOrchestrator_Precision(t *testing.T) {
+	logr := logger.NewLogger()
+	dbConn, err := storage.NewSQLiteDB(":memory:", logr)
+	if err != nil {
+		t.Fatalf("Failed to create DB: %v", err)
+	}
+	defer dbConn.Close()
+
+	orch := NewOrchestrator(dbConn, logr)
+	orch.SetGRPCClient(&grpc.ClientMock{
+		CalculateTaskFunc: func(ctx context.Context, req *grpc.TaskRequest) (*grpc.TaskResponse, error) {
+			value, err := numeric.Apply(req.Operator.Symbol(), req.Values(), req.Precision.Numeric())
+			if err != nil {
+				return &grpc.TaskResponse{TaskId: req.TaskId, ErrorCode: grpc.ErrorCode_ERROR_CODE_DOMAIN_ERROR, Error: err.Error()}, nil
+			}
+			return &grpc.TaskResponse{TaskId: req.TaskId, Result: numeric.Float(value), Value: value}, nil
+		},
+	})
+
+	decimal := numeric.Precision{Mode: numeric.ModeDecimal, Scale: 2, Rounding: numeric.RoundHalfUp}
+	tests := []struct {
+		name      string
+		expr      string
+		variables map[string]float64
+		precision numeric.Precision
+		expected  string
+	}{
+		{name: "Float64", expr: "0.1+0.2", expected: "0.30000000000000004"},
+		{name: "Decimal", expr: "0.1+0.2", precision: decimal, expected: "0.3"},
+		{name: "Every result is rounded", expr: "10/3*3", precision: decimal, expected: "9.99"},
+		{name: "Literal is rounded", expr: "-1.005", precision: decimal, expected: "-1.01"},
+		{name: "Variables", expr: "price*qty", variables: map[string]float64{"price": 19.99, "qty": 3}, precision: decimal, expected: "59.97"},
+		{name: "Rational", expr: "1/3*3", precision: numeric.Precision{Mode: numeric.ModeRational}, expected: "1"},
+		{name: "Rational fraction", expr: "1/6+1/3", precision: numeric.Precision{Mode: numeric.ModeRational}, expected: "1/2"},
+		{name: "Big integer", expr: "2^100+1", precision: numeric.Precision{Mode: numeric.ModeBigInt}, expected: "1267650600228229401496703205377"},
+		{name: "Complex by default for imaginary numbers", expr: "(1+2i)*(3-i)", expected: "5+5i"},
+		{name: "Square root of a negative number", expr: "sqrt(-4)+1", precision: numeric.Precision{Mode: numeric.ModeComplex}, expected: "1+2i"},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			value, err := orch.Process(context.Background(), storage.Expression{ID: int64(i + 1), Expression: tt.expr, Variables: tt.variables, Precision: tt.precision})
+			if err != nil || value != tt.expected {
+				t.Errorf("Expected %s, got %s (%v)", tt.expected, value, err)
+			}
+		})
+	}
+
+	// Tasks keep their exact operands, precision and result.
+	tasks, _ := dbConn.GetExpressionTasks(2)
+	if len(tasks) != 1 || fmt.Sprint(tasks[0].Operands) != "[0.1 0.2]" || tasks[0].Precision != decimal || tasks[0].Value != "0.3" {
+		t.Errorf("Expected the exact task to be stored, got %+v", tasks)
+	}
+
+	// Complex results keep their imaginary part.
+	tasks, _ = dbConn.GetExpressionTasks(9)
+	if len(tasks) != 3 || tasks[2].Value != "5+5i" || tasks[2].Result != 5 || tasks[2].Imag != 5 || tasks[2].Precision.Mode != numeric.ModeComplex {
+		t.Errorf("Expected the complex task to be stored, got %+v", tasks)
+	}
+
+	// An agent that only answers with a float64 cannot compute decimals.
+	orch.SetGRPCClient(&grpc.ClientMock{
+		CalculateTaskFunc: func(ctx context.Context, req *grpc.TaskRequest) (*grpc.TaskResponse, error) {
+			return &grpc.TaskResponse{TaskId: req.TaskId, Result: 0.3}, nil
+		},
+	})
+	_, err = orch.Process(context.Background(), storage.Expression{ID: 20, Expression: "0.1+0.2", Precision: decimal})
+	if err == nil || err.Error() != NewCalculationError("+", NewNoExactResultError().Error()).Error() {
+		t.Errorf("Expected the float64 answer to be rejected, got %v", err)
+	}
+}
+
+func TestPlanTasks(t *testing.T) {
+	logr := logger.NewLogger()
+	dbConn, err := storage.NewSQLiteDB(":memory:", logr)
+	if err != nil {
+		t.Fatalf("Failed to create DB: %v", err)
+	}
+	defer dbConn.Close()
+
+	plan, precision, err := PlanTasks("(1+2)*max(x, 4, 5-6)", map[string]float64{"x": 3}, numeric.Precision{}, DefaultOptimizePolicy())
+	if err != nil {
+		t.Fatalf("Failed to plan tasks: %v", err)
+	}
+	expected := []struct {
+		op       string
+		parent   int
+		children []int
+		known    []bool
+	}{
+		{"*", 0, []int{2, 3}, []bool{false, false}},
+		{"+", 1, nil, []bool{true, true}},
+		{"max", 1, []int{4}, []bool{true, true, false}},
+		{"-", 3, nil, []bool{true, true}},
+	}
+	if len(plan) != len(expected) {
+		t.Fatalf("Expected %d tasks, got %+v", len(expected), plan)
+	}
+	for i, want := range expected {
+		got := plan[i]
+		if got.Node != i+1 || got.Operator != want.op || got.Parent != want.parent || fmt.Sprint(got.Children) != fmt.Sprint(want.children) {
+			t.Errorf("Task %d: expected %+v, got %+v", i+1, want, got)
+		}
+		for slot, known := range want.known {
+			if (got.Operands[slot] != nil) != known {
+				t.Errorf("Task %d: expected operand %d known=%v", i+1, slot, known)
+			}
+		}
+	}
+	if *plan[2].Operands[0] != "3" || precision.Mode != numeric.ModeFloat64 {
+		t.Errorf("Expected the variable to be substituted in float64, got %s in %+v", *plan[2].Operands[0], precision)
+	}
+	// Exact operands are planned as they are written.
+	exact, precision, err := PlanTasks("0.1+1/3", nil, numeric.Precision{Mode: numeric.ModeRational}, DefaultOptimizePolicy())
+	if err != nil || len(exact) != 2 || *exact[0].Operands[0] != "0.1" || precision.Mode != numeric.ModeRational {
+		t.Errorf("Expected the exact operand 0.1 in rational precision, got %+v in %+v (%v)", exact, precision, err)
+	}
+
+	// The plan numbers the tasks the way ProcessExpression saves them.
+	userID, _ := dbConn.CreateUser("testuser", "hashedpassword")
+	exprID, _ := dbConn.SaveExpression(userID, "(1+2)*max(x, 4, 5-6)", map[string]float64{"x": 3}, time.Time{}, "")
+	orch := NewOrchestrator(dbConn, logr)
+	orch.SetGRPCClient(&grpc.ClientMock{
+		CalculateTaskFunc: func(ctx context.Context, req *grpc.TaskRequest) (*grpc.TaskResponse, error) {
+			result, err := operators.Compute(ctx, req.Operator.Symbol(), req.Operands())
+			if err != nil {
+				return nil, err
+			}
+			return &grpc.TaskResponse{TaskId: req.TaskId, Result: result, AgentId: "agent-1"}, nil
+		},
+	})
+	if _, err := orch.ProcessExpression(context.Background(), "(1+2)*max(x, 4, 5-6)", map[string]float64{"x": 3}, exprID); err != nil {
+		t.Fatalf("Failed to process expression: %v", err)
+	}
+	tasks, _ := dbConn.GetExpressionTasks(exprID)
+	if len(tasks) != len(plan) {
+		t.Fatalf("Expected %d tasks, got %d", len(plan), len(tasks))
+	}
+	for _, task := range tasks {
+		if plan[task.Node-1].Operator != task.Operator || task.Agent != "agent-1" {
+			t.Errorf("Expected node %d to be %s computed by agent-1, got %+v", task.Node, plan[task.Node-1].Operator, task)
+		}
+	}
+
+	if plan, _, err := PlanTasks("42", nil, numeric.Precision{}, DefaultOptimizePolicy()); err != nil || len(plan) != 0 {
+		t.Errorf("Expected no tasks for a number, got %+v (%v)", plan, err)
+	}
+}
+
+func TestOrchestrator_Optimize(t *testing.T) {
+	logr := logger.NewLogger()
+	dbConn, err := storage.NewSQLiteDB(":memory:", logr)
+	if err != nil {
+		t.Fatalf("Failed to create DB: %v", err)
+	}
+	defer dbConn.Close()
+
+	var mu sync.Mutex
+	var computed []string
+	client := &grpc.ClientMock{
+		CalculateTaskFunc: func(ctx context.Context, req *grpc.TaskRequest) (*grpc.TaskResponse, error) {
+			mu.Lock()
+			computed = append(computed, req.Operator.Symbol())
+			mu.Unlock()
+			result, err := operators.Compute(ctx, req.Operator.Symbol(), req.Operands())
+			if err != nil {
+				return nil, err
+			}
+			return &grpc.TaskResponse{TaskId: req.TaskId, Result: result}, nil
+		},
+	}
+	userID, _ := dbConn.CreateUser("testuser", "hashedpassword")
+
+	tests := []struct {
+		name     string
+		expr     string
+		policy   OptimizePolicy
+		expected float64
+		computed int
+		// shared maps the node of a task to the nodes it also computes.
+		shared map[int][]int
+	}{
+		{name: "No optimisation", expr: "(1+2)*(1+2)", expected: 9, computed: 3},
+		{name: "Identical subtrees", expr: "(1+2)*(1+2)", policy: DefaultOptimizePolicy(), expected: 9, computed: 2, shared: map[int][]int{2: {3}}},
+		{name: "Nested identical subtrees", expr: "sqrt(3*3)+sqrt(3*3)-3*3", policy: DefaultOptimizePolicy(), expected: -3, computed: 4, shared: map[int][]int{3: {5}, 4: {6, 7}}},
+		{name: "Identities kept", expr: "(2+0)*1", policy: DefaultOptimizePolicy(), expected: 2, computed: 2},
+		{name: "Identities folded", expr: "(2+0)*1+x^1", policy: OptimizePolicy{FoldIdentities: true}, expected: 5, computed: 1},
+		{name: "Folded to a number", expr: "(2+0)*1", policy: OptimizePolicy{FoldIdentities: true}, expected: 2, computed: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			computed = nil
+			variables := map[string]float64{"x": 3}
+			exprID, _ := dbConn.SaveExpression(userID, tt.expr, variables, time.Time{}, "")
+			orch := NewOrchestrator(dbConn, logr)
+			orch.SetOptimizePolicy(tt.policy)
+			orch.SetGRPCClient(client)
+
+			result, err := orch.ProcessExpression(context.Background(), tt.expr, variables, exprID)
+			if err != nil || result != tt.expected {
+				t.Fatalf("Expected %f, got %f (%v)", tt.expected, result, err)
+			}
+			if len(computed) != tt.computed {
+				t.Errorf("Expected %d tasks to be computed, got %v", tt.computed, computed)
+			}
+			tasks, _ := dbConn.GetExpressionTasks(exprID)
+			for _, task := range tasks {
+				if fmt.Sprint(task.Shared) != fmt.Sprint(tt.shared[task.Node]) {
+					t.Errorf("Expected node %d to share %v, got %v", task.Node, tt.shared[task.Node], task.Shared)
+				}
+			}
+
+			plan, _, err := PlanTasks(tt.expr, variables, numeric.Precision{}, tt.policy)
+			if err != nil || len(plan) != len(tasks) {
+				t.Errorf("Expected the plan to match the %d tasks, got %+v (%v)", len(tasks), plan, err)
+			}
+		})
+	}
+}
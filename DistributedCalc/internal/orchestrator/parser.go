@@ -0,0 +1,395 @@
+package orchestrator
+
+import (
+	"DistributedCalc/internal/numeric"
+	"DistributedCalc/internal/operators"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// What the parser expects where it finds something else, for error messages.
+const (
+	expectedOperand     = "a number, variable, function call or '('"
+	expectedOperator    = "an operator"
+	expectedCloseParen  = "an operator or ')'"
+	expectedArgumentEnd = "an operator, ',' or ')'"
+)
+
+// Node is a node of a parsed expression. Leaves hold a Value, inner nodes
+// hold an operator or built-in function Op applied to Args. Text is the
+// number of a leaf as written, so that it can be computed exactly; for an
+// imaginary number such as 4i, Value is 0 and only Text holds it.
+type Node struct {
+	Op    string
+	Value float64
+	Text  string
+	Args  []*Node
+}
+
+func (n *Node) IsLeaf() bool {
+	return n.Op == ""
+}
+
+// Number is the value of a leaf in text form.
+func (n *Node) Number() string {
+	if n.Text != "" {
+		return n.Text
+	}
+	return numeric.FormatFloat(n.Value)
+}
+
+// Parse builds the expression tree for expr. Operators of equal precedence
+// associate to the left except for "^". Unary plus and minus bind tighter
+// than any binary operator but "^", so -2^2 is -(2^2).
+func Parse(expr string) (*Node, error) {
+	return ParseWithVariables(expr, nil)
+}
+
+// ParseWithVariables is like Parse but also resolves the names bound in
+// variables. Built-in constants such as pi are always available.
+//
+// Errors are 422 AppErrors whose message says what is wrong and at which
+// column, counted in characters from 1; their Details is a *SyntaxError.
+func ParseWithVariables(expr string, variables map[string]float64) (*Node, error) {
+	p := &parser{tokens: tokenize(expr), end: utf8.RuneCountInString(expr) + 1, variables: variables}
+	if len(p.tokens) == 0 {
+		return nil, NewEmptyExpressionError()
+	}
+	node, err := p.parseExpr(1)
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		if p.peek() == ")" {
+			return nil, NewUnbalancedParenError(p.column())
+		}
+		return nil, p.unexpected(expectedOperator)
+	}
+	return node, nil
+}
+
+// token is a token of an expression and the column it starts at.
+type token struct {
+	text   string
+	column int
+}
+
+type parser struct {
+	tokens    []token
+	pos       int
+	end       int
+	variables map[string]float64
+}
+
+func (p *parser) peek() string {
+	return p.peekAt(0)
+}
+
+func (p *parser) peekAt(offset int) string {
+	if p.pos+offset < len(p.tokens) {
+		return p.tokens[p.pos+offset].text
+	}
+	return ""
+}
+
+// column is where the current token starts, or just past the end of the
+// expression if there are no tokens left.
+func (p *parser) column() int {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos].column
+	}
+	return p.end
+}
+
+func (p *parser) parseExpr(minPrec int) (*Node, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op := p.peek()
+		if !IsOperator(op) || Precedence(op) < minPrec {
+			return left, nil
+		}
+		p.pos++
+		next := Precedence(op) + 1
+		if operator, _ := operators.Lookup(op); operator.RightAssoc {
+			next = Precedence(op)
+		}
+		right, err := p.parseExpr(next)
+		if err != nil {
+			return nil, err
+		}
+		left = &Node{Op: op, Args: []*Node{left, right}}
+	}
+}
+
+func (p *parser) parseUnary() (*Node, error) {
+	switch p.peek() {
+	case "+":
+		p.pos++
+		return p.parseUnary()
+	case "-":
+		p.pos++
+		negate, _ := operators.Lookup(operators.Negate)
+		operand, err := p.parseExpr(negate.Precedence)
+		if err != nil {
+			return nil, err
+		}
+		// A sign in front of a number is part of the literal.
+		if operand.IsLeaf() {
+			return &Node{Value: -operand.Value, Text: negateNumber(operand.Number())}, nil
+		}
+		return &Node{Op: operators.Negate, Args: []*Node{operand}}, nil
+	default:
+		return p.parsePrimary()
+	}
+}
+
+func (p *parser) parsePrimary() (*Node, error) {
+	token := p.peek()
+	switch {
+	case token == "(":
+		open := p.column()
+		p.pos++
+		node, err := p.parseExpr(1)
+		if err != nil {
+			return nil, err
+		}
+		if err := p.closeParen(open, expectedCloseParen); err != nil {
+			return nil, err
+		}
+		return node, nil
+	case token != "" && (isDigit(token[0]) || token[0] == '.'):
+		// Value only holds real numbers; an imaginary one is only Text.
+		imaginary := numeric.IsImaginary(token)
+		num, err := strconv.ParseFloat(strings.TrimSuffix(token, "i"), 64)
+		if err != nil {
+			return nil, NewInvalidNumberError(token, p.column())
+		}
+		p.pos++
+		if imaginary {
+			return &Node{Text: token}, nil
+		}
+		return &Node{Value: num, Text: token}, nil
+	case isIdentifier(token) && p.peekAt(1) == "(":
+		return p.parseCall()
+	case isIdentifier(token):
+		column := p.column()
+		p.pos++
+		return p.resolve(token, column)
+	default:
+		return nil, p.unexpected(expectedOperand)
+	}
+}
+
+// closeParen consumes the ')' that closes the '(' at column open.
+func (p *parser) closeParen(open int, expected string) error {
+	switch p.peek() {
+	case ")":
+		p.pos++
+		return nil
+	case "":
+		return NewUnclosedParenError(open, p.column())
+	default:
+		return p.unexpected(expected)
+	}
+}
+
+func (p *parser) parseCall() (*Node, error) {
+	name, column := p.peek(), p.column()
+	operator, ok := operators.Lookup(name)
+	if !ok || !operator.Function {
+		return nil, NewUnknownFunctionError(name, column)
+	}
+	p.pos++
+	open := p.column()
+	p.pos++
+
+	var args []*Node
+	if p.peek() != ")" {
+		for {
+			arg, err := p.parseExpr(1)
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, arg)
+			if p.peek() != "," {
+				break
+			}
+			p.pos++
+		}
+	}
+	if err := p.closeParen(open, expectedArgumentEnd); err != nil {
+		return nil, err
+	}
+
+	if !operator.AcceptsArgs(len(args)) {
+		return nil, NewFunctionArityError(name, operator.MinArgs, operator.MaxArgs, len(args), column)
+	}
+	return &Node{Op: name, Args: args}, nil
+}
+
+func (p *parser) resolve(name string, column int) (*Node, error) {
+	if value, ok := p.variables[name]; ok {
+		return &Node{Value: value, Text: numeric.FormatFloat(value)}, nil
+	}
+	if value, ok := operators.Constant(name); ok {
+		return &Node{Value: value, Text: numeric.FormatFloat(value)}, nil
+	}
+	if name == operators.ImaginaryUnit {
+		return &Node{Text: "1i"}, nil
+	}
+	return nil, NewUndefinedVariableError(name, column)
+}
+
+// IsComplex reports whether the tree rooted at n has an imaginary number.
+func IsComplex(n *Node) bool {
+	if n.IsLeaf() {
+		return numeric.IsImaginary(n.Number())
+	}
+	for _, arg := range n.Args {
+		if IsComplex(arg) {
+			return true
+		}
+	}
+	return false
+}
+
+// ResolvePrecision normalizes the precision p that the tree rooted at n is
+// to be computed in. Without a mode, expressions with imaginary numbers are
+// computed in complex precision.
+func ResolvePrecision(n *Node, p numeric.Precision) (numeric.Precision, error) {
+	if p.Mode == "" && IsComplex(n) {
+		p.Mode = numeric.ModeComplex
+	}
+	return p.Normalize()
+}
+
+// negateNumber flips the sign of a number in text form.
+func negateNumber(number string) string {
+	if strings.HasPrefix(number, "-") {
+		return number[1:]
+	}
+	return "-" + number
+}
+
+// unexpected reports the current token, which is not what the parser
+// expected there.
+func (p *parser) unexpected(expected string) error {
+	token := p.peek()
+	known := token == "" || token == "(" || token == ")" || token == "," || IsOperator(token) || isIdentifier(token) || isDigit(token[0]) || token[0] == '.'
+	if !known {
+		return NewInvalidCharacterError(token, p.column())
+	}
+	return NewUnexpectedTokenError(token, p.column(), expected)
+}
+
+func Tokenize(expr string) []string {
+	var texts []string
+	for _, t := range tokenize(expr) {
+		texts = append(texts, t.text)
+	}
+	return texts
+}
+
+func tokenize(expr string) []token {
+	var tokens []token
+	// Columns count characters, so every byte of a multi-byte character
+	// after its first shifts them.
+	shift := 0
+	column := func(i int) int { return i - shift + 1 }
+	for i := 0; i < len(expr); i++ {
+		ch := expr[i]
+		start := i
+		switch {
+		case isDigit(ch) || ch == '.':
+			for i+1 < len(expr) && (isDigit(expr[i+1]) || expr[i+1] == '.') {
+				i++
+			}
+			// A trailing i makes the number imaginary, as in 4i.
+			if i+1 < len(expr) && expr[i+1] == 'i' && (i+2 == len(expr) || !isLetter(expr[i+2]) && !isDigit(expr[i+2])) {
+				i++
+			}
+		case ch == ' ' || ch == '\t' || ch == '\n' || ch == '\r':
+			continue
+		case isLetter(ch):
+			for i+1 < len(expr) && (isLetter(expr[i+1]) || isDigit(expr[i+1])) {
+				i++
+			}
+		case ch == '/' && i+1 < len(expr) && expr[i+1] == '/':
+			i++
+		case ch >= utf8.RuneSelf:
+			_, size := utf8.DecodeRuneInString(expr[i:])
+			i += size - 1
+			tokens = append(tokens, token{text: expr[start : i+1], column: column(start)})
+			shift += size - 1
+			continue
+		}
+		tokens = append(tokens, token{text: expr[start : i+1], column: column(start)})
+	}
+	return tokens
+}
+
+// ValidateVariables checks that every name in variables is an identifier
+// that does not shadow a built-in function or constant.
+func ValidateVariables(variables map[string]float64) error {
+	for name := range variables {
+		tokens := Tokenize(name)
+		if len(tokens) != 1 || tokens[0] != name || !isIdentifier(name) || operators.IsReserved(name) {
+			return NewInvalidVariableNameError(name)
+		}
+	}
+	return nil
+}
+
+// ValidateNumbers checks that every number in the tree rooted at n can be
+// computed in precision p, such as that it is an integer in bigint
+// precision.
+func ValidateNumbers(n *Node, p numeric.Precision) error {
+	if n.IsLeaf() {
+		_, err := numeric.Format(n.Number(), p)
+		return err
+	}
+	for _, arg := range n.Args {
+		if err := ValidateNumbers(arg, p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func IsValidExpression(expr string) bool {
+	_, err := Parse(expr)
+	return err == nil
+}
+
+func isDigit(ch byte) bool {
+	return ch >= '0' && ch <= '9'
+}
+
+func isLetter(ch byte) bool {
+	return ch >= 'a' && ch <= 'z' || ch >= 'A' && ch <= 'Z' || ch == '_'
+}
+
+func isIdentifier(token string) bool {
+	return token != "" && isLetter(token[0])
+}
+
+func IsNumber(token string) bool {
+	_, err := strconv.ParseFloat(token, 64)
+	return err == nil
+}
+
+func IsOperator(token string) bool {
+	return operators.IsBinary(token)
+}
+
+func Precedence(op string) int {
+	operator, ok := operators.Lookup(op)
+	if !ok {
+		return 0
+	}
+	return operator.Precedence
+}
@@ -0,0 +1,231 @@
+package orchestrator
+
+import (
+	"DistributedCalc/internal/storage"
+	"DistributedCalc/pkg/errors"
+	"DistributedCalc/pkg/logger"
+	"context"
+	stderrors "errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	defaultExpressionLease = 30 * time.Second
+	defaultSweepInterval   = 30 * time.Second
+	defaultQueueWorkers    = 16
+	queueSize              = 1024
+)
+
+// Queue processes expressions as soon as they are submitted. Expressions
+// that never made it into the queue, such as the ones left over from before
+// a restart or dropped because the queue was full, are found by a sweep of
+// the database on startup, every sweep interval and as soon as the queue
+// overflows.
+//
+// Before processing an expression the queue claims it for owner, so an
+// expression is processed only once even with several instances running.
+// At most SetWorkers expressions are processed at a time; the others wait in
+// the queue or, unclaimed, in the database.
+type Queue struct {
+	db            *storage.SQLiteDB
+	orch          *Orchestrator
+	owner         string
+	lease         time.Duration
+	sweepInterval time.Duration
+	workers       chan struct{}
+	jobs          chan int64
+	sweepNow      chan struct{}
+	logr          *logger.Logger
+
+	mu      sync.Mutex
+	running map[int64]context.CancelFunc
+}
+
+func NewQueue(db *storage.SQLiteDB, orch *Orchestrator, owner string, logr *logger.Logger) *Queue {
+	return &Queue{
+		db:            db,
+		orch:          orch,
+		owner:         owner,
+		lease:         defaultExpressionLease,
+		sweepInterval: defaultSweepInterval,
+		workers:       make(chan struct{}, defaultQueueWorkers),
+		jobs:          make(chan int64, queueSize),
+		sweepNow:      make(chan struct{}, 1),
+		logr:          logr,
+		running:       make(map[int64]context.CancelFunc),
+	}
+}
+
+// SetLease sets how long an expression stays claimed without being renewed.
+func (q *Queue) SetLease(lease time.Duration) {
+	q.lease = lease
+}
+
+func (q *Queue) SetSweepInterval(interval time.Duration) {
+	q.sweepInterval = interval
+}
+
+// SetWorkers sets how many expressions are processed at a time. It must be
+// called before Run.
+func (q *Queue) SetWorkers(n int) {
+	q.workers = make(chan struct{}, n)
+}
+
+// Enqueue schedules a saved expression for processing. It never blocks; if
+// the queue is full it triggers a sweep, which claims the expression with
+// every other one that did not fit.
+func (q *Queue) Enqueue(exprID int64) {
+	select {
+	case q.jobs <- exprID:
+		return
+	default:
+	}
+	select {
+	case q.sweepNow <- struct{}{}:
+		q.logr.Info("Expression queue is full, sweeping for expression %d and the ones after it", exprID)
+	default:
+	}
+}
+
+// Cancel aborts the processing of an expression, including the task calls
+// in flight, if this queue is processing it. An expression processed by
+// another instance stops once that instance fails to renew its claim.
+func (q *Queue) Cancel(exprID int64) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if cancel, ok := q.running[exprID]; ok {
+		cancel()
+	}
+}
+
+// Run processes queued expressions until ctx is cancelled. Expressions are
+// only taken from the queue, and claimed, once a worker is free.
+func (q *Queue) Run(ctx context.Context) {
+	q.sweep(ctx)
+	ticker := time.NewTicker(q.sweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case q.workers <- struct{}{}:
+		case <-ctx.Done():
+			return
+		}
+		select {
+		case exprID := <-q.jobs:
+			expr, err := q.db.ClaimExpression(exprID, q.owner, q.lease)
+			if err != nil {
+				<-q.workers
+				// A sweep after an overflow claims queued expressions too.
+				var conflict *errors.AppError
+				if !stderrors.As(err, &conflict) || conflict.Code != http.StatusConflict {
+					q.logr.Error("Failed to claim expression %d: %v", exprID, err)
+				}
+				continue
+			}
+			go q.work(ctx, expr)
+		case <-ticker.C:
+			<-q.workers
+			q.sweep(ctx)
+		case <-q.sweepNow:
+			<-q.workers
+			q.sweep(ctx)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// sweep claims as many expressions from the database as there are free
+// workers. If that is all of them, more may be left, so it sweeps again as
+// soon as a worker is free.
+func (q *Queue) sweep(ctx context.Context) {
+	free := cap(q.workers) - len(q.workers)
+	if free == 0 {
+		return
+	}
+	exprs, err := q.db.ClaimExpressions(q.owner, q.lease, free)
+	if err != nil {
+		q.logr.Error("Failed to claim pending expressions: %v", err)
+		return
+	}
+	for _, expr := range exprs {
+		q.workers <- struct{}{}
+		go q.work(ctx, expr)
+	}
+	if len(exprs) == free {
+		select {
+		case q.sweepNow <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// work processes a claimed expression and frees its worker.
+func (q *Queue) work(ctx context.Context, expr storage.Expression) {
+	defer func() { <-q.workers }()
+	q.process(ctx, expr)
+}
+
+// process computes a claimed expression, renewing the claim while it runs.
+// An expression that is not done by its deadline ends with the timeout state.
+func (q *Queue) process(ctx context.Context, expr storage.Expression) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	computeCtx := ctx
+	if !expr.Deadline.IsZero() {
+		var cancelCompute context.CancelFunc
+		computeCtx, cancelCompute = context.WithDeadline(ctx, expr.Deadline)
+		defer cancelCompute()
+	}
+	q.mu.Lock()
+	q.running[expr.ID] = cancel
+	q.mu.Unlock()
+	defer func() {
+		q.mu.Lock()
+		delete(q.running, expr.ID)
+		q.mu.Unlock()
+	}()
+
+	go func() {
+		ticker := time.NewTicker(q.lease / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := q.db.RenewExpressionLease(expr.ID, q.owner, q.lease); err != nil {
+					q.logr.Error("Lost expression %d: %v", expr.ID, err)
+					cancel()
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	value, err := q.orch.Process(computeCtx, expr)
+	if err != nil && ctx.Err() == nil && computeCtx.Err() == context.DeadlineExceeded {
+		q.logr.Error("Expression %d timed out", expr.ID)
+		if err := q.db.TimeoutExpression(expr.ID, q.owner, NewExpressionTimeoutError(expr.Deadline).Error()); err != nil {
+			q.logr.Error("Failed to finish expression %d: %v", expr.ID, err)
+		}
+		return
+	}
+	if err != nil && ctx.Err() != nil {
+		// The expression was cancelled, the claim was lost or the service is
+		// stopping; in the last two cases whoever claims it next resumes it.
+		q.logr.Info("Stopped processing expression %d: %v", expr.ID, err)
+		return
+	}
+	if err != nil {
+		q.logr.Error("Failed to process expression %d: %v", expr.ID, err)
+		err = q.db.FailExpression(expr.ID, q.owner, err.Error())
+	} else {
+		err = q.db.CompleteExpression(expr.ID, q.owner, value)
+	}
+	if err != nil {
+		q.logr.Error("Failed to finish expression %d: %v", expr.ID, err)
+	}
+}
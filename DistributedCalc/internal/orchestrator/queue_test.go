@@ -0,0 +1,267 @@
+package orchestrator
+
+import (
+	"DistributedCalc/internal/grpc"
+	"DistributedCalc/internal/operators"
+	"DistributedCalc/internal/storage"
+	"DistributedCalc/pkg/logger"
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func waitForStatus(t *testing.T, db *storage.SQLiteDB, id, userID int64) storage.Expression {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		expr, err := db.GetExpression(id, userID)
+		if err != nil {
+			t.Fatalf("Failed to get expression: %v", err)
+		}
+		if expr.Status != storage.ExpressionPending && expr.Status != storage.ExpressionProcessing {
+			return expr
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expression %d is still %s", id, expr.Status)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestQueue(t *testing.T) {
+	logr := logger.NewLogger()
+	dbConn, err := storage.NewSQLiteDB(":memory:", logr)
+	if err != nil {
+		t.Fatalf("Failed to create DB: %v", err)
+	}
+	defer dbConn.Close()
+
+	orch := NewOrchestrator(dbConn, logr)
+	orch.SetGRPCClient(&grpc.ClientMock{
+		CalculateTaskFunc: func(ctx context.Context, req *grpc.TaskRequest) (*grpc.TaskResponse, error) {
+			result, err := operators.Compute(ctx, req.Operator.Symbol(), req.Operands())
+			if err != nil {
+				return &grpc.TaskResponse{TaskId: req.TaskId, ErrorCode: grpc.ErrorCode_ERROR_CODE_DIVISION_BY_ZERO, Error: err.Error()}, nil
+			}
+			return &grpc.TaskResponse{TaskId: req.TaskId, Result: result}, nil
+		},
+	})
+
+	userID, _ := dbConn.CreateUser("testuser", "hashedpassword")
+	// Saved before the queue runs, as if left over from before a restart.
+	leftOverID, _ := dbConn.SaveExpression(userID, "2*3", nil, time.Time{}, "")
+
+	queue := NewQueue(dbConn, orch, "orch-1", logr)
+	queue.SetSweepInterval(time.Hour)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go queue.Run(ctx)
+
+	if expr := waitForStatus(t, dbConn, leftOverID, userID); expr.Status != storage.ExpressionCompleted || expr.Result != 6 {
+		t.Errorf("Expected the startup sweep to compute 6, got %+v", expr)
+	}
+
+	// With the next sweep an hour away, only the queue can pick these up.
+	okID, _ := dbConn.SaveExpression(userID, "1+2", nil, time.Time{}, "")
+	queue.Enqueue(okID)
+	failID, _ := dbConn.SaveExpression(userID, "1/0", nil, time.Time{}, "")
+	queue.Enqueue(failID)
+
+	if expr := waitForStatus(t, dbConn, okID, userID); expr.Status != storage.ExpressionCompleted || expr.Result != 3 {
+		t.Errorf("Expected 3, got %+v", expr)
+	}
+	if expr := waitForStatus(t, dbConn, failID, userID); expr.Status != storage.ExpressionError || expr.Error == "" {
+		t.Errorf("Expected the expression to fail with a cause, got %+v", expr)
+	}
+	if tasks, _ := dbConn.GetExpressionTasks(okID); len(tasks) != 1 {
+		t.Errorf("Expected the expression to be processed once, got %d tasks", len(tasks))
+	}
+}
+
+func TestQueue_Overflow(t *testing.T) {
+	logr := logger.NewLogger()
+	dbConn, err := storage.NewSQLiteDB(":memory:", logr)
+	if err != nil {
+		t.Fatalf("Failed to create DB: %v", err)
+	}
+	defer dbConn.Close()
+
+	orch := NewOrchestrator(dbConn, logr)
+	orch.SetGRPCClient(&grpc.ClientMock{
+		CalculateTaskFunc: func(ctx context.Context, req *grpc.TaskRequest) (*grpc.TaskResponse, error) {
+			result, _ := operators.Compute(ctx, req.Operator.Symbol(), req.Operands())
+			return &grpc.TaskResponse{TaskId: req.TaskId, Result: result}, nil
+		},
+	})
+
+	userID, _ := dbConn.CreateUser("testuser", "hashedpassword")
+	queue := NewQueue(dbConn, orch, "orch-1", logr)
+	queue.SetSweepInterval(time.Hour)
+	// Without room in the queue, an expression is only picked up if the
+	// queue is waiting for it or sweeps for it.
+	queue.jobs = make(chan int64)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go queue.Run(ctx)
+
+	ids := make([]int64, 50)
+	for i := range ids {
+		ids[i], _ = dbConn.SaveExpression(userID, "1+2", nil, time.Time{}, "")
+		queue.Enqueue(ids[i])
+	}
+	for _, id := range ids {
+		if expr := waitForStatus(t, dbConn, id, userID); expr.Status != storage.ExpressionCompleted || expr.Result != 3 {
+			t.Errorf("Expected 3, got %+v", expr)
+		}
+	}
+}
+
+func TestQueue_Workers(t *testing.T) {
+	logr := logger.NewLogger()
+	dbConn, err := storage.NewSQLiteDB(":memory:", logr)
+	if err != nil {
+		t.Fatalf("Failed to create DB: %v", err)
+	}
+	defer dbConn.Close()
+
+	var mu sync.Mutex
+	running, most := 0, 0
+	orch := NewOrchestrator(dbConn, logr)
+	orch.SetGRPCClient(&grpc.ClientMock{
+		CalculateTaskFunc: func(ctx context.Context, req *grpc.TaskRequest) (*grpc.TaskResponse, error) {
+			mu.Lock()
+			running++
+			most = max(most, running)
+			mu.Unlock()
+			time.Sleep(20 * time.Millisecond)
+			mu.Lock()
+			running--
+			mu.Unlock()
+			result, _ := operators.Compute(ctx, req.Operator.Symbol(), req.Operands())
+			return &grpc.TaskResponse{TaskId: req.TaskId, Result: result}, nil
+		},
+	})
+
+	userID, _ := dbConn.CreateUser("testuser", "hashedpassword")
+	// Half of them left over in the database, half submitted.
+	ids := make([]int64, 20)
+	for i := 0; i < 10; i++ {
+		ids[i], _ = dbConn.SaveExpression(userID, "1+2", nil, time.Time{}, "")
+	}
+	queue := NewQueue(dbConn, orch, "orch-1", logr)
+	queue.SetSweepInterval(time.Hour)
+	queue.SetWorkers(2)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go queue.Run(ctx)
+	for i := 10; i < 20; i++ {
+		ids[i], _ = dbConn.SaveExpression(userID, "1+2", nil, time.Time{}, "")
+		queue.Enqueue(ids[i])
+	}
+
+	for _, id := range ids {
+		if expr := waitForStatus(t, dbConn, id, userID); expr.Status != storage.ExpressionCompleted || expr.Result != 3 {
+			t.Errorf("Expected 3, got %+v", expr)
+		}
+	}
+	if most > 2 {
+		t.Errorf("Expected at most 2 expressions at a time, got %d", most)
+	}
+}
+
+func TestQueue_Cancel(t *testing.T) {
+	logr := logger.NewLogger()
+	dbConn, err := storage.NewSQLiteDB(":memory:", logr)
+	if err != nil {
+		t.Fatalf("Failed to create DB: %v", err)
+	}
+	defer dbConn.Close()
+
+	started := make(chan struct{}, 2)
+	aborted := make(chan struct{}, 2)
+	orch := NewOrchestrator(dbConn, logr)
+	orch.SetGRPCClient(&grpc.ClientMock{
+		CalculateTaskFunc: func(ctx context.Context, req *grpc.TaskRequest) (*grpc.TaskResponse, error) {
+			started <- struct{}{}
+			<-ctx.Done()
+			aborted <- struct{}{}
+			return nil, ctx.Err()
+		},
+	})
+
+	queue := NewQueue(dbConn, orch, "orch-1", logr)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go queue.Run(ctx)
+
+	userID, _ := dbConn.CreateUser("testuser", "hashedpassword")
+	id, _ := dbConn.SaveExpression(userID, "(1+2)*(3+4)", nil, time.Time{}, "")
+	queue.Enqueue(id)
+	<-started
+	<-started
+
+	if err := dbConn.CancelExpression(id, userID); err != nil {
+		t.Fatalf("Failed to cancel expression: %v", err)
+	}
+	queue.Cancel(id)
+	for i := 0; i < 2; i++ {
+		select {
+		case <-aborted:
+		case <-time.After(2 * time.Second):
+			t.Fatal("Expected the task calls in flight to be aborted")
+		}
+	}
+
+	if expr := waitForStatus(t, dbConn, id, userID); expr.Status != storage.ExpressionCancelled {
+		t.Errorf("Expected the expression to stay cancelled, got %+v", expr)
+	}
+	tasks, _ := dbConn.GetExpressionTasks(id)
+	for _, task := range tasks {
+		if task.Status != "cancelled" {
+			t.Errorf("Expected task %d to be cancelled, got %s", task.ID, task.Status)
+		}
+	}
+	if task, err := dbConn.ClaimTask("agent-1", time.Minute); err == nil {
+		t.Errorf("Expected no task to be claimable, got %+v", task)
+	}
+}
+
+func TestQueue_Deadline(t *testing.T) {
+	logr := logger.NewLogger()
+	dbConn, err := storage.NewSQLiteDB(":memory:", logr)
+	if err != nil {
+		t.Fatalf("Failed to create DB: %v", err)
+	}
+	defer dbConn.Close()
+
+	deadline := time.Now().Add(100 * time.Millisecond)
+	orch := NewOrchestrator(dbConn, logr)
+	orch.SetGRPCClient(&grpc.ClientMock{
+		CalculateTaskFunc: func(ctx context.Context, req *grpc.TaskRequest) (*grpc.TaskResponse, error) {
+			if req.DeadlineUnixMs != deadline.UnixMilli() {
+				t.Errorf("Expected the request to carry deadline %d, got %d", deadline.UnixMilli(), req.DeadlineUnixMs)
+			}
+			<-ctx.Done()
+			return nil, ctx.Err()
+		},
+	})
+
+	queue := NewQueue(dbConn, orch, "orch-1", logr)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go queue.Run(ctx)
+
+	userID, _ := dbConn.CreateUser("testuser", "hashedpassword")
+	id, _ := dbConn.SaveExpression(userID, "1+2", nil, deadline, "")
+	queue.Enqueue(id)
+
+	expr := waitForStatus(t, dbConn, id, userID)
+	if expr.Status != storage.ExpressionTimeout || expr.Error != NewExpressionTimeoutError(expr.Deadline).Error() {
+		t.Errorf("Expected the expression to time out, got %+v", expr)
+	}
+	tasks, _ := dbConn.GetExpressionTasks(id)
+	if len(tasks) != 1 || tasks[0].Status != "cancelled" {
+		t.Errorf("Expected the outstanding task to be cancelled, got %+v", tasks)
+	}
+}
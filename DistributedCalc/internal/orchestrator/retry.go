@@ -0,0 +1,39 @@
+package orchestrator
+
+import (
+	"DistributedCalc/pkg/retry"
+	"os"
+	"strconv"
+	"time"
+)
+
+// DefaultRetryPolicy decides how often and how fast a task is retried after
+// a transport failure. Errors reported by an agent, such as division by
+// zero, are never retried.
+func DefaultRetryPolicy() retry.Policy {
+	return retry.Policy{
+		MaxAttempts:    3,
+		InitialBackoff: 100 * time.Millisecond,
+		MaxBackoff:     2 * time.Second,
+		Jitter:         0.2,
+	}
+}
+
+// RetryPolicyFromEnv reads TASK_MAX_ATTEMPTS, TASK_RETRY_BACKOFF_MS,
+// TASK_RETRY_MAX_BACKOFF_MS and TASK_RETRY_JITTER on top of the defaults.
+func RetryPolicyFromEnv() retry.Policy {
+	policy := DefaultRetryPolicy()
+	if n, err := strconv.Atoi(os.Getenv("TASK_MAX_ATTEMPTS")); err == nil && n > 0 {
+		policy.MaxAttempts = n
+	}
+	if ms, err := strconv.Atoi(os.Getenv("TASK_RETRY_BACKOFF_MS")); err == nil && ms >= 0 {
+		policy.InitialBackoff = time.Duration(ms) * time.Millisecond
+	}
+	if ms, err := strconv.Atoi(os.Getenv("TASK_RETRY_MAX_BACKOFF_MS")); err == nil && ms >= 0 {
+		policy.MaxBackoff = time.Duration(ms) * time.Millisecond
+	}
+	if jitter, err := strconv.ParseFloat(os.Getenv("TASK_RETRY_JITTER"), 64); err == nil && jitter >= 0 && jitter <= 1 {
+		policy.Jitter = jitter
+	}
+	return policy
+}
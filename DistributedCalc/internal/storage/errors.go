@@ -1,22 +1,35 @@
-package storage
-
-import (
-	"DistributedCalc/pkg/errors"
-	"net/http"
-)
-
-func NewDBError(msg string) *errors.AppError {
-	return &errors.AppError{Code: http.StatusInternalServerError, Message: msg}
-}
-
-func NewUserNotFoundError() *errors.AppError {
-	return &errors.AppError{Code: http.StatusNotFound, Message: "user not found"}
-}
-
-func NewUserExistsError() *errors.AppError {
-	return &errors.AppError{Code: http.StatusBadRequest, Message: "user already exists"}
-}
-
-func NewTaskNotFoundError() *errors.AppError {
-	return &errors.AppError{Code: http.StatusNotFound, Message: "no pending tasks"}
-}
+package storage
+
+import (
+	"DistributedCalc/pkg/errors"
+	"fmt"
+	"net/http"
+)
+
+func NewDBError(msg string) *errors.AppError {
+	return &errors.AppError{Code: http.StatusInternalServerError, Message: msg}
+}
+
+func NewUserNotFoundError() *errors.AppError {
+	return &errors.AppError{Code: http.StatusNotFound, Message: "user not found"}
+}
+
+func NewUserExistsError() *errors.AppError {
+	return &errors.AppError{Code: http.StatusBadRequest, Message: "user already exists"}
+}
+
+func NewTaskNotFoundError() *errors.AppError {
+	return &errors.AppError{Code: http.StatusNotFound, Message: "no pending tasks"}
+}
+
+func NewLeaseLostError() *errors.AppError {
+	return &errors.AppError{Code: http.StatusConflict, Message: "task lease is not held"}
+}
+
+func NewExpressionNotOwnedError() *errors.AppError {
+	return &errors.AppError{Code: http.StatusConflict, Message: "expression is not owned by this orchestrator"}
+}
+
+func NewInvalidTransitionError(to string) *errors.AppError {
+	return &errors.AppError{Code: http.StatusConflict, Message: fmt.Sprintf("expression cannot move to %s", to)}
+}
@@ -1,218 +1,1307 @@
-package storage
-
-import (
-	"DistributedCalc/pkg/logger"
-	"database/sql"
-	"errors"
-	"strings"
-
-	_ "github.com/mattn/go-sqlite3"
-)
-
-type SQLiteDB struct {
-	db   *sql.DB
-	logr *logger.Logger
-}
-
-type User struct {
-	ID       int64
-	Login    string
-	Password string
-}
-
-type Expression struct {
-	ID         int64
-	UserID     int64
-	Expression string
-	Result     float64
-	Status     string
-}
-
-type Task struct {
-	ID           int64
-	ExpressionID int64
-	Arg1         float64
-	Arg2         float64
-	Operator     string
-	Duration     int
-	Result       float64
-	Status       string
-}
-
-func NewSQLiteDB(path string, logr *logger.Logger) (*SQLiteDB, error) {
-	db, err := sql.Open("sqlite3", path)
-	if err != nil {
-		logr.Error("Failed to open database: %v", err)
-		return nil, err
-	}
-
-	_, err = db.Exec(`
-		CREATE TABLE IF NOT EXISTS users (
-			id INTEGER PRIMARY KEY AUTOINCREMENT,
-			login TEXT UNIQUE,
-			password TEXT
-		);
-		CREATE TABLE IF NOT EXISTS expressions (
-			id INTEGER PRIMARY KEY AUTOINCREMENT,
-			user_id INTEGER,
-			expression TEXT,
-			result REAL,
-			status TEXT,
-			FOREIGN KEY (user_id) REFERENCES users(id)
-		);
-		CREATE TABLE IF NOT EXISTS tasks (
-			id INTEGER PRIMARY KEY AUTOINCREMENT,
-			expression_id INTEGER,
-			arg1 REAL,
-			arg2 REAL,
-			operator TEXT,
-			duration INTEGER,
-			result REAL,
-			status TEXT,
-			FOREIGN KEY (expression_id) REFERENCES expressions(id)
-		);
-	`)
-	if err != nil {
-		logr.Error("Failed to create tables: %v", err)
-		return nil, err
-	}
-
-	return &SQLiteDB{db: db, logr: logr}, nil
-}
-
-func (s *SQLiteDB) Close() {
-	s.db.Close()
-}
-
-func (s *SQLiteDB) CreateUser(login, password string) (int64, error) {
-	result, err := s.db.Exec("INSERT INTO users (login, password) VALUES (?, ?)", login, password)
-	if err != nil {
-		if strings.Contains(err.Error(), "UNIQUE constraint failed") {
-			return 0, errors.New("user already exists")
-		}
-		s.logr.Error("Failed to insert user: %v", err)
-		return 0, err
-	}
-	id, _ := result.LastInsertId()
-	return id, nil
-}
-
-func (s *SQLiteDB) GetUser(login string) (User, error) {
-	var user User
-	err := s.db.QueryRow("SELECT id, login, password FROM users WHERE login = ?", login).Scan(&user.ID, &user.Login, &user.Password)
-	if err == sql.ErrNoRows {
-		return User{}, errors.New("user not found")
-	}
-	if err != nil {
-		s.logr.Error("Failed to get user: %v", err)
-		return User{}, err
-	}
-	return user, nil
-}
-
-func (s *SQLiteDB) SaveExpression(userID int64, expr string) (int64, error) {
-	result, err := s.db.Exec("INSERT INTO expressions (user_id, expression, result, status) VALUES (?, ?, 0, 'pending')", userID, expr)
-	if err != nil {
-		s.logr.Error("Failed to insert expression: %v", err)
-		return 0, err
-	}
-	id, _ := result.LastInsertId()
-	return id, nil
-}
-
-func (s *SQLiteDB) GetUserExpressions(userID int64) ([]Expression, error) {
-	rows, err := s.db.Query("SELECT id, user_id, expression, result, status FROM expressions WHERE user_id = ?", userID)
-	if err != nil {
-		s.logr.Error("Failed to query expressions: %v", err)
-		return nil, err
-	}
-	defer rows.Close()
-
-	var exprs []Expression
-	for rows.Next() {
-		var expr Expression
-		if err := rows.Scan(&expr.ID, &expr.UserID, &expr.Expression, &expr.Result, &expr.Status); err != nil {
-			s.logr.Error("Failed to scan expression: %v", err)
-			return nil, err
-		}
-		exprs = append(exprs, expr)
-	}
-	return exprs, nil
-}
-
-func (s *SQLiteDB) GetExpression(id, userID int64) (Expression, error) {
-	var expr Expression
-	err := s.db.QueryRow("SELECT id, user_id, expression, result, status FROM expressions WHERE id = ? AND user_id = ?", id, userID).
-		Scan(&expr.ID, &expr.UserID, &expr.Expression, &expr.Result, &expr.Status)
-	if err == sql.ErrNoRows {
-		return Expression{}, errors.New("expression not found")
-	}
-	if err != nil {
-		s.logr.Error("Failed to get expression: %v", err)
-		return Expression{}, err
-	}
-	return expr, nil
-}
-
-func (s *SQLiteDB) SaveTask(exprID int64, arg1, arg2 float64, op string, duration int) (int64, error) {
-	result, err := s.db.Exec("INSERT INTO tasks (expression_id, arg1, arg2, operator, duration, result, status) VALUES (?, ?, ?, ?, ?, 0, 'pending')",
-		exprID, arg1, arg2, op, duration)
-	if err != nil {
-		s.logr.Error("Failed to insert task: %v", err)
-		return 0, err
-	}
-	id, _ := result.LastInsertId()
-	return id, nil
-}
-
-func (s *SQLiteDB) UpdateTaskResult(taskID int64, result float64, status string) error {
-	_, err := s.db.Exec("UPDATE tasks SET result = ?, status = ? WHERE id = ?", result, status, taskID)
-	if err != nil {
-		s.logr.Error("Failed to update task: %v", err)
-		return err
-	}
-	return nil
-}
-
-func (s *SQLiteDB) GetPendingExpressions() ([]Expression, error) {
-	rows, err := s.db.Query("SELECT id, user_id, expression, result, status FROM expressions WHERE status = 'pending'")
-	if err != nil {
-		s.logr.Error("Failed to query pending expressions: %v", err)
-		return nil, err
-	}
-	defer rows.Close()
-
-	var exprs []Expression
-	for rows.Next() {
-		var expr Expression
-		if err := rows.Scan(&expr.ID, &expr.UserID, &expr.Expression, &expr.Result, &expr.Status); err != nil {
-			s.logr.Error("Failed to scan expression: %v", err)
-			return nil, err
-		}
-		exprs = append(exprs, expr)
-	}
-	return exprs, nil
-}
-
-func (s *SQLiteDB) UpdateExpression(exprID int64, result float64, status string) error {
-	_, err := s.db.Exec("UPDATE expressions SET result = ?, status = ? WHERE id = ?", result, status, exprID)
-	if err != nil {
-		s.logr.Error("Failed to update expression: %v", err)
-		return err
-	}
-	return nil
-}
-
-func (s *SQLiteDB) GetPendingTask() (Task, error) {
-	var task Task
-	err := s.db.QueryRow("SELECT id, expression_id, arg1, arg2, operator, duration, result, status FROM tasks WHERE status = 'pending' LIMIT 1").
-		Scan(&task.ID, &task.ExpressionID, &task.Arg1, &task.Arg2, &task.Operator, &task.Duration, &task.Result, &task.Status)
-	if err == sql.ErrNoRows {
-		return Task{}, errors.New("no pending tasks")
-	}
-	if err != nil {
-		s.logr.Error("Failed to get pending task: %v", err)
-		return Task{}, err
-	}
-	return task, nil
-}
+package storage
+
+import (
+	"DistributedCalc/internal/events"
+	"DistributedCalc/internal/numeric"
+	"DistributedCalc/pkg/logger"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+type SQLiteDB struct {
+	db        *sql.DB
+	publisher events.Publisher
+	logr      *logger.Logger
+}
+
+type User struct {
+	ID       int64
+	Login    string
+	Password string
+}
+
+// Expression states. An orchestrator claims a pending expression, moving it
+// to processing, and owns it until it reaches one of the final states.
+const (
+	ExpressionPending    = "pending"
+	ExpressionProcessing = "processing"
+	ExpressionCompleted  = "completed"
+	ExpressionError      = "error"
+	ExpressionCancelled  = "cancelled"
+	ExpressionTimeout    = "timeout"
+)
+
+// expressionTransitions lists the states an expression may move to from
+// each state. Final states have no transitions.
+var expressionTransitions = map[string][]string{
+	ExpressionPending:    {ExpressionProcessing, ExpressionCancelled},
+	ExpressionProcessing: {ExpressionCompleted, ExpressionError, ExpressionCancelled, ExpressionTimeout},
+}
+
+type Expression struct {
+	ID         int64
+	UserID     int64
+	Expression string
+	Variables  map[string]float64
+	Result     float64
+	Status     string
+	Error      string
+	// Deadline is when the expression must be finished; zero means never.
+	Deadline time.Time
+	// CallbackURL receives the outcome of the expression once it finishes.
+	CallbackURL string
+	// BatchID is the batch the expression was submitted in, or 0.
+	BatchID int64
+	// Precision is how the expression is computed and Value its result in
+	// text form, exact unless computed in float64 or complex. Result is the
+	// real part of Value as a float64 and Imag its imaginary part.
+	Precision numeric.Precision
+	Value     string
+	Imag      float64
+}
+
+// NewExpression is an expression to save.
+type NewExpression struct {
+	Expression  string
+	Variables   map[string]float64
+	Deadline    time.Time
+	CallbackURL string
+	Precision   numeric.Precision
+}
+
+// Task is a single operation or function call of an expression. Operands
+// holds all of its operands in text form and Args the same as float64; Arg1
+// and Arg2 mirror the first two for agents that only understand binary
+// tasks. Value is the result in text form, and Result and Imag its real
+// and imaginary parts. Node is the position of the task in the expression's
+// task graph, or 0 if it is unknown; Shared lists the nodes of identical
+// subtrees that the task computes as well. Agent is the agent that computed
+// or is computing the task, if known.
+type Task struct {
+	ID             int64
+	ExpressionID   int64
+	Node           int
+	Shared         []int
+	Arg1           float64
+	Arg2           float64
+	Args           []float64
+	Operands       []string
+	Precision      numeric.Precision
+	Operator       string
+	Duration       int
+	Result         float64
+	Imag           float64
+	Value          string
+	Error          string
+	Status         string
+	LeaseOwner     string
+	LeaseExpiresAt time.Time
+	Agent          string
+	CreatedAt      time.Time
+	StartedAt      time.Time
+	FinishedAt     time.Time
+}
+
+// Agent is an entry of the agent registry.
+type Agent struct {
+	ID             string
+	Capabilities   []string
+	Operators      []string
+	ComputingPower int
+	Status         string
+	RegisteredAt   time.Time
+	LastSeen       time.Time
+	InFlight       int
+	Completed      int64
+}
+
+func NewSQLiteDB(path string, logr *logger.Logger) (*SQLiteDB, error) {
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		logr.Error("Failed to open database: %v", err)
+		return nil, err
+	}
+	// SQLite allows a single writer, and every connection to ":memory:" is a
+	// separate database, so all queries share one connection.
+	db.SetMaxOpenConns(1)
+
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS users (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			login TEXT UNIQUE,
+			password TEXT
+		);
+		CREATE TABLE IF NOT EXISTS expressions (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER,
+			expression TEXT,
+			variables TEXT,
+			result REAL,
+			status TEXT,
+			error TEXT,
+			owner TEXT,
+			lease_expires_at TIMESTAMP,
+			deadline TIMESTAMP,
+			callback_url TEXT,
+			batch_id INTEGER,
+			precision TEXT,
+			value TEXT,
+			imag REAL,
+			FOREIGN KEY (user_id) REFERENCES users(id),
+			FOREIGN KEY (batch_id) REFERENCES batches(id)
+		);
+		CREATE TABLE IF NOT EXISTS batches (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER,
+			created_at TIMESTAMP,
+			FOREIGN KEY (user_id) REFERENCES users(id)
+		);
+		CREATE TABLE IF NOT EXISTS tasks (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			expression_id INTEGER,
+			node INTEGER,
+			arg1 REAL,
+			arg2 REAL,
+			args TEXT,
+			operator TEXT,
+			duration INTEGER,
+			result REAL,
+			status TEXT,
+			lease_owner TEXT,
+			lease_expires_at TIMESTAMP,
+			agent TEXT,
+			created_at TIMESTAMP,
+			started_at TIMESTAMP,
+			finished_at TIMESTAMP,
+			operands TEXT,
+			precision TEXT,
+			value TEXT,
+			imag REAL,
+			shared TEXT,
+			error TEXT,
+			FOREIGN KEY (expression_id) REFERENCES expressions(id)
+		);
+		CREATE TABLE IF NOT EXISTS task_attempts (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			task_id INTEGER,
+			attempt INTEGER,
+			error TEXT,
+			started_at TIMESTAMP,
+			finished_at TIMESTAMP,
+			FOREIGN KEY (task_id) REFERENCES tasks(id)
+		);
+		CREATE TABLE IF NOT EXISTS webhook_attempts (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			expression_id INTEGER,
+			attempt INTEGER,
+			url TEXT,
+			status_code INTEGER,
+			error TEXT,
+			sent_at TIMESTAMP,
+			FOREIGN KEY (expression_id) REFERENCES expressions(id)
+		);
+		CREATE TABLE IF NOT EXISTS agents (
+			id TEXT PRIMARY KEY,
+			capabilities TEXT,
+			operators TEXT,
+			computing_power INTEGER,
+			status TEXT,
+			registered_at TIMESTAMP,
+			last_seen TIMESTAMP,
+			in_flight INTEGER,
+			completed INTEGER
+		);
+	`)
+	if err != nil {
+		logr.Error("Failed to create tables: %v", err)
+		return nil, err
+	}
+
+	if err := migrate(db); err != nil {
+		logr.Error("Failed to migrate tables: %v", err)
+		return nil, err
+	}
+
+	return &SQLiteDB{db: db, logr: logr}, nil
+}
+
+// migrations bring databases created by older versions up to date. Columns
+// are also part of CREATE TABLE, so on a fresh database they already exist.
+var migrations = []string{
+	"ALTER TABLE tasks ADD COLUMN args TEXT",
+	"ALTER TABLE expressions ADD COLUMN variables TEXT",
+	"ALTER TABLE tasks ADD COLUMN lease_owner TEXT",
+	"ALTER TABLE tasks ADD COLUMN lease_expires_at TIMESTAMP",
+	"ALTER TABLE expressions ADD COLUMN error TEXT",
+	"ALTER TABLE tasks ADD COLUMN node INTEGER",
+	"ALTER TABLE expressions ADD COLUMN owner TEXT",
+	"ALTER TABLE expressions ADD COLUMN lease_expires_at TIMESTAMP",
+	"ALTER TABLE expressions ADD COLUMN deadline TIMESTAMP",
+	"ALTER TABLE tasks ADD COLUMN agent TEXT",
+	"ALTER TABLE tasks ADD COLUMN created_at TIMESTAMP",
+	"ALTER TABLE tasks ADD COLUMN started_at TIMESTAMP",
+	"ALTER TABLE tasks ADD COLUMN finished_at TIMESTAMP",
+	"ALTER TABLE expressions ADD COLUMN callback_url TEXT",
+	"ALTER TABLE expressions ADD COLUMN batch_id INTEGER",
+	"ALTER TABLE expressions ADD COLUMN precision TEXT",
+	"ALTER TABLE expressions ADD COLUMN value TEXT",
+	"ALTER TABLE tasks ADD COLUMN operands TEXT",
+	"ALTER TABLE tasks ADD COLUMN precision TEXT",
+	"ALTER TABLE tasks ADD COLUMN value TEXT",
+	"ALTER TABLE expressions ADD COLUMN imag REAL",
+	"ALTER TABLE tasks ADD COLUMN imag REAL",
+	"ALTER TABLE tasks ADD COLUMN shared TEXT",
+	"ALTER TABLE tasks ADD COLUMN error TEXT",
+}
+
+func migrate(db *sql.DB) error {
+	for _, stmt := range migrations {
+		if _, err := db.Exec(stmt); err != nil && !strings.Contains(err.Error(), "duplicate column name") {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *SQLiteDB) Close() {
+	s.db.Close()
+}
+
+// SetPublisher makes the database publish an event for every status change
+// of an expression and every finished task.
+func (s *SQLiteDB) SetPublisher(publisher events.Publisher) {
+	s.publisher = publisher
+}
+
+func (s *SQLiteDB) publish(event events.Event) {
+	if s.publisher != nil {
+		s.publisher.Publish(event)
+	}
+}
+
+func expressionEvent(expr Expression) events.Event {
+	return events.Event{
+		Type:         events.TypeExpression,
+		UserID:       expr.UserID,
+		ExpressionID: expr.ID,
+		Status:       expr.Status,
+		Result:       expr.Result,
+		Imag:         expr.Imag,
+		Value:        expr.Value,
+		Error:        expr.Error,
+	}
+}
+
+// publishTask publishes that a task of exprID has finished.
+func (s *SQLiteDB) publishTask(exprID, taskID int64, op, status, value, taskErr string) {
+	if s.publisher == nil {
+		return
+	}
+	var userID int64
+	if err := s.db.QueryRow("SELECT user_id FROM expressions WHERE id = ?", exprID).Scan(&userID); err != nil {
+		s.logr.Error("Failed to get owner of expression %d: %v", exprID, err)
+		return
+	}
+	s.publish(events.Event{
+		Type:         events.TypeTask,
+		UserID:       userID,
+		ExpressionID: exprID,
+		TaskID:       taskID,
+		Operator:     op,
+		Status:       status,
+		Result:       numeric.Float(value),
+		Imag:         numeric.Imag(value),
+		Value:        value,
+		Error:        taskErr,
+	})
+}
+
+func (s *SQLiteDB) CreateUser(login, password string) (int64, error) {
+	result, err := s.db.Exec("INSERT INTO users (login, password) VALUES (?, ?)", login, password)
+	if err != nil {
+		if strings.Contains(err.Error(), "UNIQUE constraint failed") {
+			return 0, errors.New("user already exists")
+		}
+		s.logr.Error("Failed to insert user: %v", err)
+		return 0, err
+	}
+	id, _ := result.LastInsertId()
+	return id, nil
+}
+
+func (s *SQLiteDB) GetUser(login string) (User, error) {
+	var user User
+	err := s.db.QueryRow("SELECT id, login, password FROM users WHERE login = ?", login).Scan(&user.ID, &user.Login, &user.Password)
+	if err == sql.ErrNoRows {
+		return User{}, errors.New("user not found")
+	}
+	if err != nil {
+		s.logr.Error("Failed to get user: %v", err)
+		return User{}, err
+	}
+	return user, nil
+}
+
+func (s *SQLiteDB) GetUserByID(id int64) (User, error) {
+	var user User
+	err := s.db.QueryRow("SELECT id, login, password FROM users WHERE id = ?", id).Scan(&user.ID, &user.Login, &user.Password)
+	if err == sql.ErrNoRows {
+		return User{}, errors.New("user not found")
+	}
+	if err != nil {
+		s.logr.Error("Failed to get user: %v", err)
+		return User{}, err
+	}
+	return user, nil
+}
+
+// SaveExpression stores expr together with its variable bindings and
+// deadline, so that it evaluates the same way when it is processed again
+// after a restart. A zero deadline means the expression has none, an empty
+// callbackURL that nobody is notified when it finishes.
+func (s *SQLiteDB) SaveExpression(userID int64, expr string, variables map[string]float64, deadline time.Time, callbackURL string) (int64, error) {
+	return s.SaveNewExpression(userID, NewExpression{Expression: expr, Variables: variables, Deadline: deadline, CallbackURL: callbackURL})
+}
+
+// SaveNewExpression is SaveExpression for an expression with all of its
+// settings, including its precision.
+func (s *SQLiteDB) SaveNewExpression(userID int64, expr NewExpression) (int64, error) {
+	id, err := s.insertExpression(s.db, userID, 0, expr)
+	if err != nil {
+		return 0, err
+	}
+	s.publish(events.Event{Type: events.TypeExpression, UserID: userID, ExpressionID: id, Status: ExpressionPending})
+	return id, nil
+}
+
+func (s *SQLiteDB) insertExpression(db execer, userID, batchID int64, expr NewExpression) (int64, error) {
+	var encoded sql.NullString
+	if len(expr.Variables) > 0 {
+		data, err := json.Marshal(expr.Variables)
+		if err != nil {
+			return 0, err
+		}
+		encoded = sql.NullString{String: string(data), Valid: true}
+	}
+	var deadline sql.NullTime
+	if !expr.Deadline.IsZero() {
+		deadline = sql.NullTime{Time: expr.Deadline, Valid: true}
+	}
+	var callback sql.NullString
+	if expr.CallbackURL != "" {
+		callback = sql.NullString{String: expr.CallbackURL, Valid: true}
+	}
+	var batch sql.NullInt64
+	if batchID != 0 {
+		batch = sql.NullInt64{Int64: batchID, Valid: true}
+	}
+	precision, err := encodePrecision(expr.Precision)
+	if err != nil {
+		return 0, err
+	}
+	result, err := db.Exec("INSERT INTO expressions (user_id, expression, variables, result, status, deadline, callback_url, batch_id, precision) VALUES (?, ?, ?, 0, ?, ?, ?, ?, ?)",
+		userID, expr.Expression, encoded, ExpressionPending, deadline, callback, batch, precision)
+	if err != nil {
+		s.logr.Error("Failed to insert expression: %v", err)
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+// SaveBatch saves exprs as one batch of userID. Either all of them are saved
+// or, if any insert fails, none. It returns the ID of the batch and the IDs
+// of the expressions in the order of exprs.
+func (s *SQLiteDB) SaveBatch(userID int64, exprs []NewExpression) (int64, []int64, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		s.logr.Error("Failed to begin transaction: %v", err)
+		return 0, nil, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec("INSERT INTO batches (user_id, created_at) VALUES (?, ?)", userID, time.Now())
+	if err != nil {
+		s.logr.Error("Failed to insert batch: %v", err)
+		return 0, nil, err
+	}
+	batchID, _ := result.LastInsertId()
+	ids := make([]int64, len(exprs))
+	for i, expr := range exprs {
+		if ids[i], err = s.insertExpression(tx, userID, batchID, expr); err != nil {
+			return 0, nil, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, nil, err
+	}
+	for _, id := range ids {
+		s.publish(events.Event{Type: events.TypeExpression, UserID: userID, ExpressionID: id, Status: ExpressionPending})
+	}
+	return batchID, ids, nil
+}
+
+// GetBatchExpressions returns the expressions of a batch of userID in the
+// order they were submitted.
+func (s *SQLiteDB) GetBatchExpressions(batchID, userID int64) ([]Expression, error) {
+	var found int
+	err := s.db.QueryRow("SELECT 1 FROM batches WHERE id = ? AND user_id = ?", batchID, userID).Scan(&found)
+	if err == sql.ErrNoRows {
+		return nil, errors.New("batch not found")
+	}
+	if err != nil {
+		s.logr.Error("Failed to get batch: %v", err)
+		return nil, err
+	}
+
+	rows, err := s.db.Query("SELECT "+expressionColumns+" FROM expressions WHERE batch_id = ? ORDER BY id", batchID)
+	if err != nil {
+		s.logr.Error("Failed to query batch expressions: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var exprs []Expression
+	for rows.Next() {
+		expr, err := scanExpression(rows)
+		if err != nil {
+			s.logr.Error("Failed to scan expression: %v", err)
+			return nil, err
+		}
+		exprs = append(exprs, expr)
+	}
+	return exprs, rows.Err()
+}
+
+// CancelBatch cancels every expression of a batch of userID that has not
+// finished yet, together with its tasks, and returns the IDs of the
+// expressions it cancelled.
+func (s *SQLiteDB) CancelBatch(batchID, userID int64) ([]int64, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		s.logr.Error("Failed to begin transaction: %v", err)
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var found int
+	err = tx.QueryRow("SELECT 1 FROM batches WHERE id = ? AND user_id = ?", batchID, userID).Scan(&found)
+	if err == sql.ErrNoRows {
+		return nil, errors.New("batch not found")
+	}
+	if err != nil {
+		s.logr.Error("Failed to get batch: %v", err)
+		return nil, err
+	}
+
+	rows, err := tx.Query("SELECT id FROM expressions WHERE batch_id = ? AND status IN (?, ?) ORDER BY id", batchID, ExpressionPending, ExpressionProcessing)
+	if err != nil {
+		s.logr.Error("Failed to query batch expressions: %v", err)
+		return nil, err
+	}
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+
+	var cancelled []events.Event
+	for _, id := range ids {
+		event, err := s.transitionExpression(tx, id, "", ExpressionCancelled, "")
+		if err != nil {
+			return nil, err
+		}
+		if err := s.cancelTasks(tx, id); err != nil {
+			return nil, err
+		}
+		cancelled = append(cancelled, event)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	for _, event := range cancelled {
+		s.publish(event)
+	}
+	return ids, nil
+}
+
+func (s *SQLiteDB) GetUserExpressions(userID int64) ([]Expression, error) {
+	rows, err := s.db.Query("SELECT "+expressionColumns+" FROM expressions WHERE user_id = ?", userID)
+	if err != nil {
+		s.logr.Error("Failed to query expressions: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var exprs []Expression
+	for rows.Next() {
+		expr, err := scanExpression(rows)
+		if err != nil {
+			s.logr.Error("Failed to scan expression: %v", err)
+			return nil, err
+		}
+		exprs = append(exprs, expr)
+	}
+	return exprs, nil
+}
+
+func (s *SQLiteDB) GetExpression(id, userID int64) (Expression, error) {
+	expr, err := scanExpression(s.db.QueryRow("SELECT "+expressionColumns+" FROM expressions WHERE id = ? AND user_id = ?", id, userID))
+	if err == sql.ErrNoRows {
+		return Expression{}, errors.New("expression not found")
+	}
+	if err != nil {
+		s.logr.Error("Failed to get expression: %v", err)
+		return Expression{}, err
+	}
+	return expr, nil
+}
+
+const expressionColumns = "id, user_id, expression, variables, result, status, error, deadline, callback_url, batch_id, precision, value, imag"
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanExpression(row scanner) (Expression, error) {
+	var expr Expression
+	var variables, exprErr, callbackURL, precision, value sql.NullString
+	var deadline sql.NullTime
+	var batchID sql.NullInt64
+	var imag sql.NullFloat64
+	if err := row.Scan(&expr.ID, &expr.UserID, &expr.Expression, &variables, &expr.Result, &expr.Status, &exprErr, &deadline, &callbackURL, &batchID,
+		&precision, &value, &imag); err != nil {
+		return Expression{}, err
+	}
+	expr.BatchID = batchID.Int64
+	expr.Error, expr.Deadline, expr.CallbackURL = exprErr.String, deadline.Time, callbackURL.String
+	expr.Value, expr.Imag = value.String, imag.Float64
+	if variables.Valid {
+		if err := json.Unmarshal([]byte(variables.String), &expr.Variables); err != nil {
+			return Expression{}, err
+		}
+	}
+	var err error
+	if expr.Precision, err = decodePrecision(precision); err != nil {
+		return Expression{}, err
+	}
+	return expr, nil
+}
+
+// encodePrecision stores float64, the precision of rows written before
+// precisions existed, as NULL.
+func encodePrecision(p numeric.Precision) (sql.NullString, error) {
+	if p.IsFloat64() {
+		return sql.NullString{}, nil
+	}
+	data, err := json.Marshal(p)
+	if err != nil {
+		return sql.NullString{}, err
+	}
+	return sql.NullString{String: string(data), Valid: true}, nil
+}
+
+func decodePrecision(data sql.NullString) (numeric.Precision, error) {
+	if !data.Valid {
+		return numeric.Precision{Mode: numeric.ModeFloat64}, nil
+	}
+	var p numeric.Precision
+	err := json.Unmarshal([]byte(data.String), &p)
+	return p, err
+}
+
+// SaveTask stores a pending task computing op on operands in precision. The
+// operands are stored as they are and, for agents that only read float64
+// operands, as float64 too.
+func (s *SQLiteDB) SaveTask(exprID int64, node int, operands []string, precision numeric.Precision, op string, duration int) (int64, error) {
+	return s.SaveLeasedTask(exprID, node, operands, precision, op, duration, "")
+}
+
+// SaveLeasedTask is like SaveTask but stores the task already leased to
+// owner without expiry, so that no agent can claim it in between. An empty
+// owner saves a pending task.
+func (s *SQLiteDB) SaveLeasedTask(exprID int64, node int, operands []string, precision numeric.Precision, op string, duration int, owner string) (int64, error) {
+	args := make([]float64, len(operands))
+	for i, operand := range operands {
+		args[i] = numeric.Float(operand)
+	}
+	encoded, err := json.Marshal(args)
+	if err != nil {
+		return 0, err
+	}
+	encodedOperands, err := json.Marshal(operands)
+	if err != nil {
+		return 0, err
+	}
+	encodedPrecision, err := encodePrecision(precision)
+	if err != nil {
+		return 0, err
+	}
+	var arg1, arg2 sql.NullFloat64
+	if len(args) > 0 {
+		arg1 = sql.NullFloat64{Float64: args[0], Valid: true}
+	}
+	if len(args) > 1 {
+		arg2 = sql.NullFloat64{Float64: args[1], Valid: true}
+	}
+	now := time.Now()
+	status := "pending"
+	var leaseOwner sql.NullString
+	var startedAt sql.NullTime
+	if owner != "" {
+		status = "in_progress"
+		leaseOwner = sql.NullString{String: owner, Valid: true}
+		startedAt = sql.NullTime{Time: now, Valid: true}
+	}
+	result, err := s.db.Exec(`INSERT INTO tasks (expression_id, node, arg1, arg2, args, operands, precision, operator, duration, result, status, lease_owner, started_at, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, 0, ?, ?, ?, ?)`,
+		exprID, node, arg1, arg2, string(encoded), string(encodedOperands), encodedPrecision, op, duration, status, leaseOwner, startedAt, now)
+	if err != nil {
+		s.logr.Error("Failed to insert task: %v", err)
+		return 0, err
+	}
+	id, _ := result.LastInsertId()
+	return id, nil
+}
+
+func (s *SQLiteDB) GetTask(taskID int64) (Task, error) {
+	task, err := scanTask(s.db.QueryRow("SELECT "+taskColumns+" FROM tasks WHERE id = ?", taskID))
+	if err == sql.ErrNoRows {
+		return Task{}, errors.New("task not found")
+	}
+	if err != nil {
+		s.logr.Error("Failed to get task: %v", err)
+		return Task{}, err
+	}
+	return task, nil
+}
+
+// GetExpressionTasks returns the tasks of an expression in the order they
+// were created.
+func (s *SQLiteDB) GetExpressionTasks(exprID int64) ([]Task, error) {
+	rows, err := s.db.Query("SELECT "+taskColumns+" FROM tasks WHERE expression_id = ? ORDER BY id", exprID)
+	if err != nil {
+		s.logr.Error("Failed to query expression tasks: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tasks []Task
+	for rows.Next() {
+		task, err := scanTask(rows)
+		if err != nil {
+			s.logr.Error("Failed to scan task: %v", err)
+			return nil, err
+		}
+		tasks = append(tasks, task)
+	}
+	return tasks, rows.Err()
+}
+
+func (s *SQLiteDB) GetPendingExpressions() ([]Expression, error) {
+	rows, err := s.db.Query("SELECT "+expressionColumns+" FROM expressions WHERE status = ?", ExpressionPending)
+	if err != nil {
+		s.logr.Error("Failed to query pending expressions: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var exprs []Expression
+	for rows.Next() {
+		expr, err := scanExpression(rows)
+		if err != nil {
+			s.logr.Error("Failed to scan expression: %v", err)
+			return nil, err
+		}
+		exprs = append(exprs, expr)
+	}
+	return exprs, nil
+}
+
+// ClaimExpressions atomically moves up to limit pending expressions, oldest
+// first, to processing and leases them to owner. Expressions whose owner let
+// the lease expire, for example because it crashed, are claimed again.
+func (s *SQLiteDB) ClaimExpressions(owner string, lease time.Duration, limit int) ([]Expression, error) {
+	now := time.Now()
+	rows, err := s.db.Query(`UPDATE expressions SET status = ?, owner = ?, lease_expires_at = ?
+		WHERE id IN (SELECT id FROM expressions WHERE status = ? OR (status = ? AND lease_expires_at < ?) ORDER BY id LIMIT ?)
+		RETURNING `+expressionColumns,
+		ExpressionProcessing, owner, now.Add(lease), ExpressionPending, ExpressionProcessing, now, limit)
+	if err != nil {
+		s.logr.Error("Failed to claim expressions: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var exprs []Expression
+	for rows.Next() {
+		expr, err := scanExpression(rows)
+		if err != nil {
+			s.logr.Error("Failed to scan expression: %v", err)
+			return nil, err
+		}
+		exprs = append(exprs, expr)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	for _, expr := range exprs {
+		s.publish(expressionEvent(expr))
+	}
+	return exprs, nil
+}
+
+// ClaimExpression is ClaimExpressions for a single expression. It fails if
+// the expression is not pending and not abandoned by its owner.
+func (s *SQLiteDB) ClaimExpression(exprID int64, owner string, lease time.Duration) (Expression, error) {
+	now := time.Now()
+	expr, err := scanExpression(s.db.QueryRow(`UPDATE expressions SET status = ?, owner = ?, lease_expires_at = ?
+		WHERE id = ? AND (status = ? OR (status = ? AND lease_expires_at < ?))
+		RETURNING `+expressionColumns,
+		ExpressionProcessing, owner, now.Add(lease), exprID, ExpressionPending, ExpressionProcessing, now))
+	if err == sql.ErrNoRows {
+		return Expression{}, NewInvalidTransitionError(ExpressionProcessing)
+	}
+	if err != nil {
+		s.logr.Error("Failed to claim expression: %v", err)
+		return Expression{}, err
+	}
+	s.publish(expressionEvent(expr))
+	return expr, nil
+}
+
+// RenewExpressionLease extends the lease of owner on a processing expression.
+// It fails if the expression has another owner or is no longer processing.
+func (s *SQLiteDB) RenewExpressionLease(exprID int64, owner string, lease time.Duration) error {
+	result, err := s.db.Exec("UPDATE expressions SET lease_expires_at = ? WHERE id = ? AND status = ? AND owner = ?",
+		time.Now().Add(lease), exprID, ExpressionProcessing, owner)
+	if err != nil {
+		s.logr.Error("Failed to renew expression lease: %v", err)
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return NewExpressionNotOwnedError()
+	}
+	return nil
+}
+
+// CompleteExpression stores the result of an expression owner is
+// processing, given in text form.
+func (s *SQLiteDB) CompleteExpression(exprID int64, owner string, value string) error {
+	event, err := s.transitionExpression(s.db, exprID, owner, ExpressionCompleted, "result = ?, imag = ?, value = ?", numeric.Float(value), numeric.Imag(value), value)
+	if err != nil {
+		return err
+	}
+	s.publish(event)
+	return nil
+}
+
+// FailExpression marks an expression owner is processing as failed and
+// records the cause.
+func (s *SQLiteDB) FailExpression(exprID int64, owner, cause string) error {
+	event, err := s.transitionExpression(s.db, exprID, owner, ExpressionError, "result = 0, error = ?", cause)
+	if err != nil {
+		return err
+	}
+	s.publish(event)
+	return nil
+}
+
+// CancelExpression cancels an unfinished expression of userID, whoever
+// owns it, together with its outstanding tasks, so that agents no longer
+// claim them.
+func (s *SQLiteDB) CancelExpression(exprID, userID int64) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		s.logr.Error("Failed to begin transaction: %v", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	var found int
+	err = tx.QueryRow("SELECT 1 FROM expressions WHERE id = ? AND user_id = ?", exprID, userID).Scan(&found)
+	if err == sql.ErrNoRows {
+		return errors.New("expression not found")
+	}
+	if err != nil {
+		s.logr.Error("Failed to get expression: %v", err)
+		return err
+	}
+	event, err := s.transitionExpression(tx, exprID, "", ExpressionCancelled, "")
+	if err != nil {
+		return err
+	}
+	if err := s.cancelTasks(tx, exprID); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	s.publish(event)
+	return nil
+}
+
+// TimeoutExpression marks an expression owner is processing as timed out,
+// records the cause and cancels its outstanding tasks.
+func (s *SQLiteDB) TimeoutExpression(exprID int64, owner, cause string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		s.logr.Error("Failed to begin transaction: %v", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	event, err := s.transitionExpression(tx, exprID, owner, ExpressionTimeout, "result = 0, error = ?", cause)
+	if err != nil {
+		return err
+	}
+	if err := s.cancelTasks(tx, exprID); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	s.publish(event)
+	return nil
+}
+
+// cancelTasks cancels the tasks of an expression that are not finished, so
+// that agents no longer claim them.
+func (s *SQLiteDB) cancelTasks(db execer, exprID int64) error {
+	_, err := db.Exec("UPDATE tasks SET status = 'cancelled', lease_owner = NULL, lease_expires_at = NULL, finished_at = ? WHERE expression_id = ? AND status IN ('pending', 'in_progress')", time.Now(), exprID)
+	if err != nil {
+		s.logr.Error("Failed to cancel tasks: %v", err)
+		return err
+	}
+	return nil
+}
+
+// execer is implemented by both *sql.DB and *sql.Tx.
+type execer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+// transitionExpression moves an expression to state to, applying the extra
+// assignments, in a single compare-and-set: it only succeeds if the current
+// state allows the transition and, when owner is set, owner holds the
+// expression. Final states release the ownership. It returns the event to
+// publish once the change is committed.
+func (s *SQLiteDB) transitionExpression(db execer, exprID int64, owner, to, assignments string, args ...any) (events.Event, error) {
+	var from []string
+	for state, next := range expressionTransitions {
+		for _, n := range next {
+			if n == to {
+				from = append(from, state)
+			}
+		}
+	}
+	if len(from) == 0 {
+		return events.Event{}, NewInvalidTransitionError(to)
+	}
+
+	query := "UPDATE expressions SET status = ?"
+	params := []any{to}
+	if len(expressionTransitions[to]) == 0 {
+		query += ", owner = NULL, lease_expires_at = NULL"
+	}
+	if assignments != "" {
+		query += ", " + assignments
+		params = append(params, args...)
+	}
+	query += " WHERE id = ? AND status IN (?" + strings.Repeat(", ?", len(from)-1) + ")"
+	params = append(params, exprID)
+	for _, state := range from {
+		params = append(params, state)
+	}
+	if owner != "" {
+		query += " AND owner = ?"
+		params = append(params, owner)
+	}
+
+	query += " RETURNING " + expressionColumns
+
+	expr, err := scanExpression(db.QueryRow(query, params...))
+	if err == sql.ErrNoRows {
+		return events.Event{}, NewInvalidTransitionError(to)
+	}
+	if err != nil {
+		s.logr.Error("Failed to update expression: %v", err)
+		return events.Event{}, err
+	}
+	return expressionEvent(expr), nil
+}
+
+// ClaimTask atomically takes the oldest pending task and leases it to owner
+// until the lease expires. Until then no one else can claim it. Owner is an
+// agent, so it is recorded as the agent of the task.
+func (s *SQLiteDB) ClaimTask(owner string, lease time.Duration) (Task, error) {
+	now := time.Now()
+	task, err := scanTask(s.db.QueryRow(`UPDATE tasks SET status = 'in_progress', lease_owner = ?, lease_expires_at = ?, agent = ?, started_at = ?
+		WHERE id = (SELECT id FROM tasks WHERE status = 'pending' ORDER BY id LIMIT 1)
+		RETURNING `+taskColumns, owner, now.Add(lease), owner, now))
+	if err == sql.ErrNoRows {
+		return Task{}, errors.New("no pending tasks")
+	}
+	if err != nil {
+		s.logr.Error("Failed to claim task: %v", err)
+		return Task{}, err
+	}
+	return task, nil
+}
+
+// LeaseTask leases a pending task to owner. A zero lease never expires; it
+// is used for tasks the orchestrator dispatches itself.
+func (s *SQLiteDB) LeaseTask(taskID int64, owner string, lease time.Duration) error {
+	var expiresAt sql.NullTime
+	if lease > 0 {
+		expiresAt = sql.NullTime{Time: time.Now().Add(lease), Valid: true}
+	}
+	result, err := s.db.Exec("UPDATE tasks SET status = 'in_progress', lease_owner = ?, lease_expires_at = ?, started_at = ? WHERE id = ? AND status = 'pending'",
+		owner, expiresAt, time.Now(), taskID)
+	if err != nil {
+		s.logr.Error("Failed to lease task: %v", err)
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return NewLeaseLostError()
+	}
+	return nil
+}
+
+// ResumeTask takes over a task that was left unfinished, for example by an
+// orchestrator that crashed, and leases it to owner without expiry. Completed
+// and cancelled tasks cannot be resumed.
+func (s *SQLiteDB) ResumeTask(taskID int64, owner string) error {
+	result, err := s.db.Exec("UPDATE tasks SET status = 'in_progress', lease_owner = ?, lease_expires_at = NULL, started_at = ? WHERE id = ? AND status NOT IN ('completed', 'cancelled')",
+		owner, time.Now(), taskID)
+	if err != nil {
+		s.logr.Error("Failed to resume task: %v", err)
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return NewLeaseLostError()
+	}
+	return nil
+}
+
+// RequeueTask puts a task left unfinished back to pending so that an agent
+// can claim it. Tasks leased to an agent until an expiry keep their lease,
+// which ReleaseExpiredLeases ends if the agent does not report in time.
+func (s *SQLiteDB) RequeueTask(taskID int64) error {
+	_, err := s.db.Exec(`UPDATE tasks SET status = 'pending', lease_owner = NULL, lease_expires_at = NULL, agent = NULL, started_at = NULL
+		WHERE id = ? AND status NOT IN ('completed', 'cancelled') AND lease_expires_at IS NULL`, taskID)
+	if err != nil {
+		s.logr.Error("Failed to requeue task: %v", err)
+	}
+	return err
+}
+
+// CompleteTask stores the result of a task, given in text form and empty if
+// there is none, or the error it failed with, but only if owner still holds
+// an unexpired lease on it.
+func (s *SQLiteDB) CompleteTask(taskID int64, owner string, value string, status string, taskErr string) error {
+	var exprID int64
+	var op string
+	now := time.Now()
+	var stored, storedErr sql.NullString
+	if value != "" {
+		stored = sql.NullString{String: value, Valid: true}
+	}
+	if taskErr != "" {
+		storedErr = sql.NullString{String: taskErr, Valid: true}
+	}
+	err := s.db.QueryRow(`UPDATE tasks SET result = ?, imag = ?, value = ?, error = ?, status = ?, lease_owner = NULL, lease_expires_at = NULL, finished_at = ?
+		WHERE id = ? AND status = 'in_progress' AND lease_owner = ? AND (lease_expires_at IS NULL OR lease_expires_at >= ?)
+		RETURNING expression_id, operator`,
+		numeric.Float(value), numeric.Imag(value), stored, storedErr, status, now, taskID, owner, now).Scan(&exprID, &op)
+	if err == sql.ErrNoRows {
+		return NewLeaseLostError()
+	}
+	if err != nil {
+		s.logr.Error("Failed to complete task: %v", err)
+		return err
+	}
+	s.publishTask(exprID, taskID, op, status, value, taskErr)
+	return nil
+}
+
+// ReleaseExpiredLeases puts tasks whose lease has expired back to pending
+// and returns how many were released.
+func (s *SQLiteDB) ReleaseExpiredLeases(now time.Time) (int64, error) {
+	result, err := s.db.Exec(`UPDATE tasks SET status = 'pending', lease_owner = NULL, lease_expires_at = NULL, agent = NULL, started_at = NULL
+		WHERE status = 'in_progress' AND lease_expires_at IS NOT NULL AND lease_expires_at < ?`, now)
+	if err != nil {
+		s.logr.Error("Failed to release expired leases: %v", err)
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// ShareTask records that a task also computes the nodes of identical
+// subtrees elsewhere in its expression.
+func (s *SQLiteDB) ShareTask(taskID int64, nodes []int) error {
+	encoded, err := json.Marshal(nodes)
+	if err != nil {
+		return err
+	}
+	if _, err := s.db.Exec("UPDATE tasks SET shared = ? WHERE id = ?", string(encoded), taskID); err != nil {
+		s.logr.Error("Failed to share task: %v", err)
+		return err
+	}
+	return nil
+}
+
+// AssignTask records agent as the agent that computes a task the
+// orchestrator dispatched.
+func (s *SQLiteDB) AssignTask(taskID int64, agent string) error {
+	if _, err := s.db.Exec("UPDATE tasks SET agent = ? WHERE id = ?", agent, taskID); err != nil {
+		s.logr.Error("Failed to assign task: %v", err)
+		return err
+	}
+	return nil
+}
+
+// TaskAttempt is one try to compute a task. Error is empty if it succeeded.
+type TaskAttempt struct {
+	TaskID     int64
+	Attempt    int
+	Error      string
+	StartedAt  time.Time
+	FinishedAt time.Time
+}
+
+func (s *SQLiteDB) RecordTaskAttempt(attempt TaskAttempt) error {
+	_, err := s.db.Exec("INSERT INTO task_attempts (task_id, attempt, error, started_at, finished_at) VALUES (?, ?, ?, ?, ?)",
+		attempt.TaskID, attempt.Attempt, attempt.Error, attempt.StartedAt, attempt.FinishedAt)
+	if err != nil {
+		s.logr.Error("Failed to record task attempt: %v", err)
+		return err
+	}
+	return nil
+}
+
+func (s *SQLiteDB) GetTaskAttempts(taskID int64) ([]TaskAttempt, error) {
+	rows, err := s.db.Query("SELECT task_id, attempt, error, started_at, finished_at FROM task_attempts WHERE task_id = ? ORDER BY attempt", taskID)
+	if err != nil {
+		s.logr.Error("Failed to query task attempts: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var attempts []TaskAttempt
+	for rows.Next() {
+		var attempt TaskAttempt
+		if err := rows.Scan(&attempt.TaskID, &attempt.Attempt, &attempt.Error, &attempt.StartedAt, &attempt.FinishedAt); err != nil {
+			s.logr.Error("Failed to scan task attempt: %v", err)
+			return nil, err
+		}
+		attempts = append(attempts, attempt)
+	}
+	return attempts, rows.Err()
+}
+
+// WebhookAttempt is one try to deliver the outcome of an expression to its
+// callback URL. StatusCode is 0 if no response was received.
+type WebhookAttempt struct {
+	ExpressionID int64
+	Attempt      int
+	URL          string
+	StatusCode   int
+	Error        string
+	SentAt       time.Time
+}
+
+func (s *SQLiteDB) RecordWebhookAttempt(attempt WebhookAttempt) error {
+	_, err := s.db.Exec("INSERT INTO webhook_attempts (expression_id, attempt, url, status_code, error, sent_at) VALUES (?, ?, ?, ?, ?, ?)",
+		attempt.ExpressionID, attempt.Attempt, attempt.URL, attempt.StatusCode, attempt.Error, attempt.SentAt)
+	if err != nil {
+		s.logr.Error("Failed to record webhook attempt: %v", err)
+		return err
+	}
+	return nil
+}
+
+func (s *SQLiteDB) GetWebhookAttempts(exprID int64) ([]WebhookAttempt, error) {
+	rows, err := s.db.Query("SELECT expression_id, attempt, url, status_code, error, sent_at FROM webhook_attempts WHERE expression_id = ? ORDER BY attempt", exprID)
+	if err != nil {
+		s.logr.Error("Failed to query webhook attempts: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var attempts []WebhookAttempt
+	for rows.Next() {
+		var attempt WebhookAttempt
+		if err := rows.Scan(&attempt.ExpressionID, &attempt.Attempt, &attempt.URL, &attempt.StatusCode, &attempt.Error, &attempt.SentAt); err != nil {
+			s.logr.Error("Failed to scan webhook attempt: %v", err)
+			return nil, err
+		}
+		attempts = append(attempts, attempt)
+	}
+	return attempts, rows.Err()
+}
+
+const taskColumns = "id, expression_id, node, arg1, arg2, args, operator, duration, result, status, lease_owner, lease_expires_at, agent, created_at, started_at, finished_at, operands, precision, value, imag, shared, error"
+
+func scanTask(row scanner) (Task, error) {
+	var task Task
+	var arg1, arg2, imag sql.NullFloat64
+	var args, leaseOwner, agent, operands, precision, value, shared, taskErr sql.NullString
+	var node sql.NullInt64
+	var leaseExpiresAt, createdAt, startedAt, finishedAt sql.NullTime
+	err := row.Scan(&task.ID, &task.ExpressionID, &node, &arg1, &arg2, &args, &task.Operator, &task.Duration, &task.Result, &task.Status, &leaseOwner, &leaseExpiresAt,
+		&agent, &createdAt, &startedAt, &finishedAt, &operands, &precision, &value, &imag, &shared, &taskErr)
+	if err != nil {
+		return Task{}, err
+	}
+	task.Node = int(node.Int64)
+	task.Arg1, task.Arg2 = arg1.Float64, arg2.Float64
+	task.LeaseOwner, task.LeaseExpiresAt = leaseOwner.String, leaseExpiresAt.Time
+	task.Agent = agent.String
+	task.CreatedAt, task.StartedAt, task.FinishedAt = createdAt.Time, startedAt.Time, finishedAt.Time
+	task.Value, task.Imag = value.String, imag.Float64
+	task.Error = taskErr.String
+	if err := decodeArgs(&task, args, arg1, arg2); err != nil {
+		return Task{}, err
+	}
+	if err := decodeOperands(&task, operands); err != nil {
+		return Task{}, err
+	}
+	if task.Precision, err = decodePrecision(precision); err != nil {
+		return Task{}, err
+	}
+	if shared.Valid {
+		if err := json.Unmarshal([]byte(shared.String), &task.Shared); err != nil {
+			return Task{}, err
+		}
+	}
+	return task, nil
+}
+
+// decodeOperands fills task.Operands and, for completed tasks, task.Value
+// from the float64 columns for rows written before they had a text form.
+func decodeOperands(task *Task, operands sql.NullString) error {
+	if operands.Valid {
+		return json.Unmarshal([]byte(operands.String), &task.Operands)
+	}
+	for _, arg := range task.Args {
+		task.Operands = append(task.Operands, numeric.FormatFloat(arg))
+	}
+	if task.Value == "" && task.Status == "completed" {
+		task.Value = numeric.FormatFloat(task.Result)
+	}
+	return nil
+}
+
+// decodeArgs fills task.Args, falling back to arg1 and arg2 for rows written
+// before the args column existed.
+func decodeArgs(task *Task, args sql.NullString, arg1, arg2 sql.NullFloat64) error {
+	if args.Valid {
+		return json.Unmarshal([]byte(args.String), &task.Args)
+	}
+	for _, arg := range []sql.NullFloat64{arg1, arg2} {
+		if arg.Valid {
+			task.Args = append(task.Args, arg.Float64)
+		}
+	}
+	return nil
+}
+
+// RegisterAgent adds an agent to the registry or, when it registers again
+// after a reconnect, refreshes its details while keeping its completed count.
+func (s *SQLiteDB) RegisterAgent(agent Agent) error {
+	capabilities, err := json.Marshal(agent.Capabilities)
+	if err != nil {
+		return err
+	}
+	ops, err := json.Marshal(agent.Operators)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec(`INSERT INTO agents (id, capabilities, operators, computing_power, status, registered_at, last_seen, in_flight, completed)
+		VALUES (?, ?, ?, ?, 'alive', ?, ?, 0, 0)
+		ON CONFLICT(id) DO UPDATE SET capabilities = excluded.capabilities, operators = excluded.operators,
+			computing_power = excluded.computing_power, status = 'alive', last_seen = excluded.last_seen, in_flight = 0`,
+		agent.ID, string(capabilities), string(ops), agent.ComputingPower, agent.RegisteredAt, agent.RegisteredAt)
+	if err != nil {
+		s.logr.Error("Failed to register agent: %v", err)
+		return err
+	}
+	return nil
+}
+
+// TouchAgent records a heartbeat of an agent.
+func (s *SQLiteDB) TouchAgent(id string, lastSeen time.Time, inFlight int) error {
+	_, err := s.db.Exec("UPDATE agents SET last_seen = ?, in_flight = ?, status = 'alive' WHERE id = ?", lastSeen, inFlight, id)
+	if err != nil {
+		s.logr.Error("Failed to update agent: %v", err)
+		return err
+	}
+	return nil
+}
+
+func (s *SQLiteDB) IncrementAgentCompleted(id string) error {
+	_, err := s.db.Exec("UPDATE agents SET completed = completed + 1 WHERE id = ?", id)
+	if err != nil {
+		s.logr.Error("Failed to update agent: %v", err)
+		return err
+	}
+	return nil
+}
+
+func (s *SQLiteDB) UpdateAgentStatus(id, status string) error {
+	_, err := s.db.Exec("UPDATE agents SET status = ?, in_flight = 0 WHERE id = ?", status, id)
+	if err != nil {
+		s.logr.Error("Failed to update agent: %v", err)
+		return err
+	}
+	return nil
+}
+
+// MarkDeadAgents marks every alive agent that was last seen before cutoff as
+// dead and returns how many were marked.
+func (s *SQLiteDB) MarkDeadAgents(cutoff time.Time) (int64, error) {
+	result, err := s.db.Exec("UPDATE agents SET status = 'dead', in_flight = 0 WHERE status = 'alive' AND last_seen < ?", cutoff)
+	if err != nil {
+		s.logr.Error("Failed to mark dead agents: %v", err)
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+func (s *SQLiteDB) ListAgents() ([]Agent, error) {
+	rows, err := s.db.Query("SELECT id, capabilities, operators, computing_power, status, registered_at, last_seen, in_flight, completed FROM agents ORDER BY id")
+	if err != nil {
+		s.logr.Error("Failed to query agents: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	agents := []Agent{}
+	for rows.Next() {
+		var agent Agent
+		var capabilities, ops string
+		if err := rows.Scan(&agent.ID, &capabilities, &ops, &agent.ComputingPower, &agent.Status, &agent.RegisteredAt, &agent.LastSeen, &agent.InFlight, &agent.Completed); err != nil {
+			s.logr.Error("Failed to scan agent: %v", err)
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(capabilities), &agent.Capabilities); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(ops), &agent.Operators); err != nil {
+			return nil, err
+		}
+		agents = append(agents, agent)
+	}
+	return agents, rows.Err()
+}
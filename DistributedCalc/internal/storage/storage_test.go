@@ -1,66 +1,376 @@
-package storage
-
-import (
-	"DistributedCalc/pkg/logger"
-	"testing"
-)
-
-func TestSQLiteDB_CreateUser(t *testing.T) {
-	logr := logger.NewLogger()
-	dbConn, err := NewSQLiteDB(":memory:", logr)
-	if err != nil {
-		t.Fatalf("Failed to create DB: %v", err)
-	}
-	defer dbConn.Close()
-
-	err = dbConn.CreateUser("testuser", "hashedpassword")
-	if err != nil {
-		t.Errorf("Failed to create user: %v", err)
-	}
-
-	err = dbConn.CreateUser("testuser", "anotherpassword")
-	if err == nil || err.Error() != NewUserExistsError().Error() {
-		t.Error("Expected error for duplicate user, got", err)
-	}
-}
-
-func TestSQLiteDB_SaveExpression(t *testing.T) {
-	logr := logger.NewLogger()
-	dbConn, err := NewSQLiteDB(":memory:", logr)
-	if err != nil {
-		t.Fatalf("Failed to create DB: %v", err)
-	}
-	defer dbConn.Close()
-
-	dbConn.CreateUser("testuser", "hashedpassword")
-	id, err := dbConn.SaveExpression("testuser", "2+2", "pending")
-	if err != nil {
-		t.Errorf("Failed to save expression: %v", err)
-	}
-	if id <= 0 {
-		t.Error("Expected positive ID, got", id)
-	}
-}
-
-func TestSQLiteDB_SaveTask(t *testing.T) {
-	logr := logger.NewLogger()
-	dbConn, err := NewSQLiteDB(":memory:", logr)
-	if err != nil {
-		t.Fatalf("Failed to create DB: %v", err)
-	}
-	defer dbConn.Close()
-
-	dbConn.CreateUser("testuser", "hashedpassword")
-	exprID, err := dbConn.SaveExpression("testuser", "2+2", "pending")
-	if err != nil {
-		t.Fatalf("Failed to save expression: %v", err)
-	}
-
-	taskID, err := dbConn.SaveTask(exprID, 2, 2, "+", 100)
-	if err != nil {
-		t.Errorf("Failed to save task: %v", err)
-	}
-	if taskID <= 0 {
-		t.Error("Expected positive task ID, got", taskID)
-	}
-}
+package storage
+
+import (
+	"DistributedCalc/internal/numeric"
+	"DistributedCalc/pkg/logger"
+	"testing"
+	"time"
+)
+
+func TestSQLiteDB_CreateUser(t *testing.T) {
+	logr := logger.NewLogger()
+	dbConn, err := NewSQLiteDB(":memory:", logr)
+	if err != nil {
+		t.Fatalf("Failed to create DB: %v", err)
+	}
+	defer dbConn.Close()
+
+	_, err = dbConn.CreateUser("testuser", "hashedpassword")
+	if err != nil {
+		t.Errorf("Failed to create user: %v", err)
+	}
+
+	_, err = dbConn.CreateUser("testuser", "anotherpassword")
+	if err == nil || err.Error() != NewUserExistsError().Error() {
+		t.Error("Expected error for duplicate user, got", err)
+	}
+}
+
+func TestSQLiteDB_SaveExpression(t *testing.T) {
+	logr := logger.NewLogger()
+	dbConn, err := NewSQLiteDB(":memory:", logr)
+	if err != nil {
+		t.Fatalf("Failed to create DB: %v", err)
+	}
+	defer dbConn.Close()
+
+	userID, _ := dbConn.CreateUser("testuser", "hashedpassword")
+	id, err := dbConn.SaveExpression(userID, "2+2", nil, time.Time{}, "")
+	if err != nil {
+		t.Errorf("Failed to save expression: %v", err)
+	}
+	if id <= 0 {
+		t.Error("Expected positive ID, got", id)
+	}
+}
+
+func TestSQLiteDB_SaveTask(t *testing.T) {
+	logr := logger.NewLogger()
+	dbConn, err := NewSQLiteDB(":memory:", logr)
+	if err != nil {
+		t.Fatalf("Failed to create DB: %v", err)
+	}
+	defer dbConn.Close()
+
+	userID, _ := dbConn.CreateUser("testuser", "hashedpassword")
+	exprID, err := dbConn.SaveExpression(userID, "2+2", nil, time.Time{}, "")
+	if err != nil {
+		t.Fatalf("Failed to save expression: %v", err)
+	}
+
+	taskID, err := dbConn.SaveTask(exprID, 0, []string{"2", "2"}, numeric.Precision{}, "+", 100)
+	if err != nil {
+		t.Errorf("Failed to save task: %v", err)
+	}
+	if taskID <= 0 {
+		t.Error("Expected positive task ID, got", taskID)
+	}
+}
+
+func TestSQLiteDB_SaveTaskArgs(t *testing.T) {
+	logr := logger.NewLogger()
+	dbConn, err := NewSQLiteDB(":memory:", logr)
+	if err != nil {
+		t.Fatalf("Failed to create DB: %v", err)
+	}
+	defer dbConn.Close()
+
+	userID, _ := dbConn.CreateUser("testuser", "hashedpassword")
+	exprID, err := dbConn.SaveExpression(userID, "max(1, 2, 3)", nil, time.Time{}, "")
+	if err != nil {
+		t.Fatalf("Failed to save expression: %v", err)
+	}
+
+	taskID, err := dbConn.SaveTask(exprID, 0, []string{"1", "2", "3"}, numeric.Precision{}, "max", 100)
+	if err != nil {
+		t.Fatalf("Failed to save task: %v", err)
+	}
+
+	task, err := dbConn.ClaimTask("agent-1", time.Minute)
+	if err != nil {
+		t.Fatalf("Failed to claim task: %v", err)
+	}
+	if task.ID != taskID || len(task.Args) != 3 || task.Args[2] != 3 {
+		t.Errorf("Expected task %d with operands [1 2 3], got %+v", taskID, task)
+	}
+}
+
+func TestSQLiteDB_ExpressionVariables(t *testing.T) {
+	logr := logger.NewLogger()
+	dbConn, err := NewSQLiteDB(":memory:", logr)
+	if err != nil {
+		t.Fatalf("Failed to create DB: %v", err)
+	}
+	defer dbConn.Close()
+
+	userID, _ := dbConn.CreateUser("testuser", "hashedpassword")
+	variables := map[string]float64{"rate": 40, "hours": 7.5}
+	id, err := dbConn.SaveExpression(userID, "rate*hours", variables, time.Time{}, "")
+	if err != nil {
+		t.Fatalf("Failed to save expression: %v", err)
+	}
+
+	expr, err := dbConn.GetExpression(id, userID)
+	if err != nil {
+		t.Fatalf("Failed to get expression: %v", err)
+	}
+	if len(expr.Variables) != 2 || expr.Variables["rate"] != 40 || expr.Variables["hours"] != 7.5 {
+		t.Errorf("Expected variables %v, got %v", variables, expr.Variables)
+	}
+}
+
+func TestSQLiteDB_AgentRegistry(t *testing.T) {
+	logr := logger.NewLogger()
+	dbConn, err := NewSQLiteDB(":memory:", logr)
+	if err != nil {
+		t.Fatalf("Failed to create DB: %v", err)
+	}
+	defer dbConn.Close()
+
+	start := time.Now()
+	for _, id := range []string{"agent-1", "agent-2"} {
+		if err := dbConn.RegisterAgent(Agent{ID: id, Operators: []string{"+"}, ComputingPower: 2, RegisteredAt: start}); err != nil {
+			t.Fatalf("Failed to register agent: %v", err)
+		}
+	}
+	dbConn.IncrementAgentCompleted("agent-1")
+	dbConn.TouchAgent("agent-1", start.Add(time.Minute), 1)
+
+	n, err := dbConn.MarkDeadAgents(start.Add(time.Second))
+	if err != nil || n != 1 {
+		t.Fatalf("Expected one dead agent, got %d (%v)", n, err)
+	}
+	// Registering again revives the agent and keeps its counters.
+	dbConn.RegisterAgent(Agent{ID: "agent-1", ComputingPower: 4, RegisteredAt: start.Add(2 * time.Minute)})
+
+	agents, err := dbConn.ListAgents()
+	if err != nil {
+		t.Fatalf("Failed to list agents: %v", err)
+	}
+	if len(agents) != 2 {
+		t.Fatalf("Expected 2 agents, got %d", len(agents))
+	}
+	if a := agents[0]; a.ID != "agent-1" || a.Status != "alive" || a.Completed != 1 || a.ComputingPower != 4 {
+		t.Errorf("Unexpected agent-1: %+v", a)
+	}
+	if a := agents[1]; a.ID != "agent-2" || a.Status != "dead" || len(a.Operators) != 1 {
+		t.Errorf("Unexpected agent-2: %+v", a)
+	}
+}
+
+func TestSQLiteDB_TaskLeases(t *testing.T) {
+	logr := logger.NewLogger()
+	dbConn, err := NewSQLiteDB(":memory:", logr)
+	if err != nil {
+		t.Fatalf("Failed to create DB: %v", err)
+	}
+	defer dbConn.Close()
+
+	userID, _ := dbConn.CreateUser("testuser", "hashedpassword")
+	exprID, _ := dbConn.SaveExpression(userID, "2+2", nil, time.Time{}, "")
+	taskID, err := dbConn.SaveTask(exprID, 0, []string{"2", "2"}, numeric.Precision{}, "+", 100)
+	if err != nil {
+		t.Fatalf("Failed to save task: %v", err)
+	}
+
+	// A task saved already leased is never pending, so agents cannot claim it.
+	leasedID, err := dbConn.SaveLeasedTask(exprID, 0, []string{"3", "3"}, numeric.Precision{}, "+", 100, "orchestrator")
+	if err != nil {
+		t.Fatalf("Failed to save leased task: %v", err)
+	}
+	if leased, _ := dbConn.GetTask(leasedID); leased.Status != "in_progress" || leased.LeaseOwner != "orchestrator" {
+		t.Errorf("Expected task %d leased to the orchestrator, got %+v", leasedID, leased)
+	}
+
+	task, err := dbConn.ClaimTask("agent-1", -time.Second)
+	if err != nil {
+		t.Fatalf("Failed to claim task: %v", err)
+	}
+	if task.ID != taskID || task.Status != "in_progress" || task.LeaseOwner != "agent-1" {
+		t.Errorf("Expected task %d leased to agent-1, got %+v", taskID, task)
+	}
+	if _, err := dbConn.ClaimTask("agent-2", time.Minute); err == nil {
+		t.Error("Expected a leased task not to be claimed twice")
+	}
+
+	// The lease above has already expired.
+	if err := dbConn.CompleteTask(taskID, "agent-1", "4", "completed", ""); err == nil || err.Error() != NewLeaseLostError().Error() {
+		t.Errorf("Expected %v for an expired lease, got %v", NewLeaseLostError(), err)
+	}
+	if n, err := dbConn.ReleaseExpiredLeases(time.Now()); err != nil || n != 1 {
+		t.Fatalf("Expected one released task, got %d (%v)", n, err)
+	}
+
+	task, err = dbConn.ClaimTask("agent-2", time.Minute)
+	if err != nil || task.ID != taskID {
+		t.Fatalf("Expected task %d to be claimed again, got %+v (%v)", taskID, task, err)
+	}
+	if err := dbConn.CompleteTask(taskID, "agent-1", "4", "completed", ""); err == nil {
+		t.Error("Expected a result from a former lease owner to be rejected")
+	}
+	if err := dbConn.CompleteTask(taskID, "agent-2", "4", "completed", ""); err != nil {
+		t.Errorf("Failed to complete task: %v", err)
+	}
+	if err := dbConn.CompleteTask(leasedID, "orchestrator", "6", "completed", ""); err != nil {
+		t.Errorf("Failed to complete leased task: %v", err)
+	}
+}
+
+func TestSQLiteDB_ExpressionStates(t *testing.T) {
+	logr := logger.NewLogger()
+	dbConn, err := NewSQLiteDB(":memory:", logr)
+	if err != nil {
+		t.Fatalf("Failed to create DB: %v", err)
+	}
+	defer dbConn.Close()
+
+	userID, _ := dbConn.CreateUser("testuser", "hashedpassword")
+	okID, _ := dbConn.SaveExpression(userID, "2+2", nil, time.Time{}, "")
+	failID, _ := dbConn.SaveExpression(userID, "1/0", nil, time.Time{}, "")
+
+	if err := dbConn.CompleteExpression(okID, "orch-1", "4"); err == nil {
+		t.Error("Expected a pending expression not to complete before it is claimed")
+	}
+
+	claimed, err := dbConn.ClaimExpressions("orch-1", time.Minute, 1)
+	if err != nil || len(claimed) != 1 || claimed[0].ID != okID || claimed[0].Status != ExpressionProcessing {
+		t.Fatalf("Expected the oldest expression to be claimed, got %+v (%v)", claimed, err)
+	}
+	if claimed, _ = dbConn.ClaimExpressions("orch-1", time.Minute, 10); len(claimed) != 1 || claimed[0].ID != failID {
+		t.Fatalf("Expected the remaining expression to be claimed, got %+v", claimed)
+	}
+	if again, _ := dbConn.ClaimExpressions("orch-2", time.Minute, 10); len(again) != 0 {
+		t.Errorf("Expected processing expressions not to be claimed again, got %+v", again)
+	}
+
+	if err := dbConn.CompleteExpression(okID, "orch-2", "4"); err == nil {
+		t.Error("Expected another orchestrator not to complete the expression")
+	}
+	if err := dbConn.RenewExpressionLease(okID, "orch-2", time.Minute); err == nil {
+		t.Error("Expected another orchestrator not to renew the lease")
+	}
+	if err := dbConn.CompleteExpression(okID, "orch-1", "4"); err != nil {
+		t.Fatalf("Failed to complete expression: %v", err)
+	}
+	if err := dbConn.FailExpression(okID, "orch-1", "late"); err == nil {
+		t.Error("Expected a completed expression to stay completed")
+	}
+	if err := dbConn.FailExpression(failID, "orch-1", "division by zero"); err != nil {
+		t.Fatalf("Failed to fail expression: %v", err)
+	}
+
+	expr, _ := dbConn.GetExpression(okID, userID)
+	if expr.Status != ExpressionCompleted || expr.Result != 4 {
+		t.Errorf("Expected completed expression with result 4, got %+v", expr)
+	}
+	expr, _ = dbConn.GetExpression(failID, userID)
+	if expr.Status != ExpressionError || expr.Error != "division by zero" {
+		t.Errorf("Expected failed expression with its cause, got %+v", expr)
+	}
+}
+
+func TestSQLiteDB_ComplexResult(t *testing.T) {
+	logr := logger.NewLogger()
+	dbConn, err := NewSQLiteDB(":memory:", logr)
+	if err != nil {
+		t.Fatalf("Failed to create DB: %v", err)
+	}
+	defer dbConn.Close()
+
+	userID, _ := dbConn.CreateUser("testuser", "hashedpassword")
+	id, _ := dbConn.SaveExpression(userID, "(1-2i)^2", nil, time.Time{}, "")
+	dbConn.ClaimExpressions("orch-1", time.Minute, 10)
+	if err := dbConn.CompleteExpression(id, "orch-1", "-3-4i"); err != nil {
+		t.Fatalf("Failed to complete expression: %v", err)
+	}
+
+	expr, _ := dbConn.GetExpression(id, userID)
+	if expr.Result != -3 || expr.Imag != -4 || expr.Value != "-3-4i" {
+		t.Errorf("Expected the real and imaginary parts of -3-4i, got %+v", expr)
+	}
+}
+
+func TestSQLiteDB_ClaimExpiredExpression(t *testing.T) {
+	logr := logger.NewLogger()
+	dbConn, err := NewSQLiteDB(":memory:", logr)
+	if err != nil {
+		t.Fatalf("Failed to create DB: %v", err)
+	}
+	defer dbConn.Close()
+
+	userID, _ := dbConn.CreateUser("testuser", "hashedpassword")
+	id, _ := dbConn.SaveExpression(userID, "2+2", nil, time.Time{}, "")
+	if claimed, _ := dbConn.ClaimExpressions("orch-1", -time.Second, 10); len(claimed) != 1 {
+		t.Fatalf("Expected the expression to be claimed, got %+v", claimed)
+	}
+
+	// orch-1 stopped renewing its lease, so orch-2 takes over.
+	claimed, err := dbConn.ClaimExpressions("orch-2", time.Minute, 10)
+	if err != nil || len(claimed) != 1 || claimed[0].ID != id {
+		t.Fatalf("Expected the expired expression to be claimed again, got %+v (%v)", claimed, err)
+	}
+	if err := dbConn.CompleteExpression(id, "orch-1", "4"); err == nil {
+		t.Error("Expected the previous owner not to complete the expression")
+	}
+	if err := dbConn.CompleteExpression(id, "orch-2", "4"); err != nil {
+		t.Errorf("Failed to complete expression: %v", err)
+	}
+}
+
+func TestSQLiteDB_Batches(t *testing.T) {
+	logr := logger.NewLogger()
+	dbConn, err := NewSQLiteDB(":memory:", logr)
+	if err != nil {
+		t.Fatalf("Failed to create DB: %v", err)
+	}
+	defer dbConn.Close()
+
+	userID, _ := dbConn.CreateUser("testuser", "hashedpassword")
+	otherID, _ := dbConn.CreateUser("otheruser", "hashedpassword")
+	batchID, ids, err := dbConn.SaveBatch(userID, []NewExpression{
+		{Expression: "1+1"},
+		{Expression: "x*2", Variables: map[string]float64{"x": 3}},
+		{Expression: "2^10", CallbackURL: "http://billing.local/results"},
+	})
+	if err != nil || batchID <= 0 || len(ids) != 3 {
+		t.Fatalf("Failed to save batch: %d %v (%v)", batchID, ids, err)
+	}
+	if _, err := dbConn.SaveExpression(userID, "5-5", nil, time.Time{}, ""); err != nil {
+		t.Fatalf("Failed to save expression: %v", err)
+	}
+
+	exprs, err := dbConn.GetBatchExpressions(batchID, userID)
+	if err != nil || len(exprs) != 3 {
+		t.Fatalf("Expected 3 expressions in the batch, got %+v (%v)", exprs, err)
+	}
+	for i, expr := range exprs {
+		if expr.ID != ids[i] || expr.BatchID != batchID || expr.Status != ExpressionPending {
+			t.Errorf("Expected expression %d to be pending in batch %d, got %+v", ids[i], batchID, expr)
+		}
+	}
+	if exprs[1].Variables["x"] != 3 || exprs[2].CallbackURL != "http://billing.local/results" {
+		t.Errorf("Expected variables and callback to be stored, got %+v", exprs)
+	}
+	if _, err := dbConn.GetBatchExpressions(batchID, otherID); err == nil {
+		t.Error("Expected another user not to see the batch")
+	}
+
+	// Only unfinished expressions are cancelled.
+	dbConn.ClaimExpression(ids[0], "orch-1", time.Minute)
+	dbConn.CompleteExpression(ids[0], "orch-1", "2")
+	dbConn.ClaimExpression(ids[1], "orch-1", time.Minute)
+	if _, err := dbConn.CancelBatch(batchID, otherID); err == nil {
+		t.Error("Expected another user not to cancel the batch")
+	}
+	cancelled, err := dbConn.CancelBatch(batchID, userID)
+	if err != nil || len(cancelled) != 2 || cancelled[0] != ids[1] || cancelled[1] != ids[2] {
+		t.Fatalf("Expected expressions %v to be cancelled, got %v (%v)", ids[1:], cancelled, err)
+	}
+	exprs, _ = dbConn.GetBatchExpressions(batchID, userID)
+	if exprs[0].Status != ExpressionCompleted || exprs[1].Status != ExpressionCancelled || exprs[2].Status != ExpressionCancelled {
+		t.Errorf("Unexpected statuses after cancelling: %+v", exprs)
+	}
+	if cancelled, _ := dbConn.CancelBatch(batchID, userID); len(cancelled) != 0 {
+		t.Errorf("Expected nothing left to cancel, got %v", cancelled)
+	}
+}
@@ -1,66 +1,116 @@
-package tasks
-
-import (
-	"DistributedCalc/internal/storage"
-	"DistributedCalc/pkg/errors"
-	"DistributedCalc/pkg/logger"
-	"encoding/json"
-	"net/http"
-)
-
-type TaskService struct {
-	db   *storage.SQLiteDB
-	logr *logger.Logger
-}
-
-func NewTaskService(db *storage.SQLiteDB, logr *logger.Logger) *TaskService {
-	return &TaskService{
-		db:   db,
-		logr: logr,
-	}
-}
-
-func (s *TaskService) GetTaskHandler(w http.ResponseWriter, r *http.Request) {
-	task, err := s.db.GetPendingTask()
-	if err != nil {
-		if err.Error() == "no pending tasks" {
-			errors.HandleHTTPError(w, errors.NewNotFoundError("no pending tasks"))
-			return
-		}
-		s.logr.Error("Failed to get pending task: %v", err)
-		errors.HandleHTTPError(w, errors.NewInternalError("failed to get task"))
-		return
-	}
-
-	taskResponse := Task{
-		ID:            task.ID,
-		ExpressionID:  task.ExpressionID,
-		Arg1:          task.Arg1,
-		Arg2:          task.Arg2,
-		Operation:     task.Operator,
-		OperationTime: task.Duration,
-	}
-	json.NewEncoder(w).Encode(taskResponse)
-}
-
-func (s *TaskService) SubmitTaskResultHandler(w http.ResponseWriter, r *http.Request) {
-	var result TaskResult
-	if err := json.NewDecoder(r.Body).Decode(&result); err != nil {
-		s.logr.Error("Failed to decode task result: %v", err)
-		errors.HandleHTTPError(w, errors.NewBadRequestError("invalid request body"))
-		return
-	}
-
-	status := "completed"
-	if result.Result == 0 {
-		status = "error"
-	}
-
-	if err := s.db.UpdateTaskResult(result.ID, result.Result, status); err != nil {
-		s.logr.Error("Failed to update task %d: %v", result.ID, err)
-		errors.HandleHTTPError(w, errors.NewInternalError("failed to update task"))
-		return
-	}
-
-	w.WriteHeader(http.StatusOK)
-}
+package tasks
+
+import (
+	"DistributedCalc/internal/numeric"
+	"DistributedCalc/internal/storage"
+	"DistributedCalc/pkg/errors"
+	"DistributedCalc/pkg/logger"
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+const defaultLeaseTimeout = 10 * time.Second
+
+type TaskService struct {
+	db           *storage.SQLiteDB
+	logr         *logger.Logger
+	leaseTimeout time.Duration
+}
+
+func NewTaskService(db *storage.SQLiteDB, logr *logger.Logger) *TaskService {
+	return &TaskService{
+		db:           db,
+		logr:         logr,
+		leaseTimeout: defaultLeaseTimeout,
+	}
+}
+
+// SetLeaseTimeout sets how long an agent may work on a claimed task before
+// it is handed out again.
+func (s *TaskService) SetLeaseTimeout(timeout time.Duration) {
+	s.leaseTimeout = timeout
+}
+
+// GetTaskHandler leases the oldest pending task to the agent named by the
+// agent_id query parameter.
+func (s *TaskService) GetTaskHandler(w http.ResponseWriter, r *http.Request) {
+	agentID := r.URL.Query().Get("agent_id")
+	if agentID == "" {
+		errors.HandleHTTPError(w, NewInvalidTaskError("agent_id is required"))
+		return
+	}
+
+	task, err := s.db.ClaimTask(agentID, s.leaseTimeout)
+	if err != nil {
+		if err.Error() == "no pending tasks" {
+			errors.HandleHTTPError(w, errors.NewNotFoundError("no pending tasks"))
+			return
+		}
+		s.logr.Error("Failed to get pending task: %v", err)
+		errors.HandleHTTPError(w, errors.NewInternalError("failed to get task"))
+		return
+	}
+
+	taskResponse := Task{
+		ID:             task.ID,
+		ExpressionID:   task.ExpressionID,
+		Arg1:           task.Arg1,
+		Arg2:           task.Arg2,
+		Args:           task.Args,
+		Operands:       task.Operands,
+		Precision:      task.Precision,
+		Operation:      task.Operator,
+		OperationTime:  task.Duration,
+		LeaseExpiresAt: task.LeaseExpiresAt,
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"task": taskResponse.ToResponse()})
+}
+
+func (s *TaskService) SubmitTaskResultHandler(w http.ResponseWriter, r *http.Request) {
+	var result TaskResult
+	if err := json.NewDecoder(r.Body).Decode(&result); err != nil {
+		s.logr.Error("Failed to decode task result: %v", err)
+		errors.HandleHTTPError(w, errors.NewBadRequestError("invalid request body"))
+		return
+	}
+
+	status := "completed"
+	value := result.Value
+	if result.Error != "" {
+		s.logr.Error("Agent failed to compute task %d: %s", result.ID, result.Error)
+		status, value = "error", ""
+	} else if value == "" {
+		value = numeric.FormatFloat(result.Result)
+	}
+
+	if err := s.db.CompleteTask(result.ID, result.AgentID, value, status, result.Error); err != nil {
+		s.logr.Error("Failed to update task %d: %v", result.ID, err)
+		if _, ok := err.(*errors.AppError); ok {
+			errors.HandleHTTPError(w, err)
+		} else {
+			errors.HandleHTTPError(w, errors.NewInternalError("failed to update task"))
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// ReleaseExpiredLeases returns tasks of agents that did not report in time
+// to the queue, checking every interval until ctx is cancelled.
+func (s *TaskService) ReleaseExpiredLeases(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if n, err := s.db.ReleaseExpiredLeases(time.Now()); err == nil && n > 0 {
+				s.logr.Info("Released %d tasks with expired leases", n)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -1,126 +1,156 @@
-package tasks
-
-import (
-	"DistributedCalc/internal/calculator"
-	"DistributedCalc/pkg/logger"
-	"bytes"
-	"context"
-	"encoding/json"
-	"net/http"
-	"time"
-)
-
-type Task struct {
-	ID            int64
-	ExpressionID  int64
-	Arg1          float64
-	Arg2          float64
-	Operation     string
-	OperationTime int
-}
-
-func (t *Task) ToResponse() map[string]interface{} {
-	return map[string]interface{}{
-		"id":             t.ID,
-		"arg1":           t.Arg1,
-		"arg2":           t.Arg2,
-		"operation":      t.Operation,
-		"operation_time": t.OperationTime,
-	}
-}
-
-type TaskResult struct {
-	ID     int64   `json:"id"`
-	Result float64 `json:"result"`
-}
-
-type TaskClient struct {
-	addr   string
-	client *http.Client
-	logr   *logger.Logger
-}
-
-func NewTaskClient(addr string, logr *logger.Logger) *TaskClient {
-	return &TaskClient{
-		addr:   addr,
-		client: &http.Client{Timeout: 10 * time.Second},
-		logr:   logr,
-	}
-}
-
-func (c *TaskClient) RunWorker(ctx context.Context, calc *calculator.Calculator) {
-	for {
-		select {
-		case <-ctx.Done():
-			c.logr.Info("Task worker stopped")
-			return
-		default:
-			task, err := c.fetchTask()
-			if err != nil {
-				if err.Error() == NewTaskNotFoundError().Error() {
-					time.Sleep(100 * time.Millisecond)
-					continue
-				}
-				c.logr.Error("Failed to fetch task: %v", err)
-				continue
-			}
-
-			result, err := calc.ComputeTask(task.Arg1, task.Arg2, task.Operation)
-			if err != nil {
-				c.logr.Error("Failed to compute task %d: %v", task.ID, err)
-				c.submitTaskResult(task.ID, 0, "error")
-				continue
-			}
-
-			if err := c.submitTaskResult(task.ID, result, "completed"); err != nil {
-				c.logr.Error("Failed to submit task %d result: %v", task.ID, err)
-			}
-		}
-	}
-}
-
-func (c *TaskClient) fetchTask() (Task, error) {
-	resp, err := c.client.Get(c.addr + "/api/v1/task")
-	if err != nil {
-		c.logr.Error("Failed to fetch task from %s: %v", c.addr, err)
-		return Task{}, NewTaskFetchError(err.Error())
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode == http.StatusNotFound {
-		return Task{}, NewTaskNotFoundError()
-	}
-	if resp.StatusCode != http.StatusOK {
-		c.logr.Error("Unexpected status code: %d", resp.StatusCode)
-		return Task{}, NewTaskFetchError("unexpected response status")
-	}
-
-	var task Task
-	if err := json.NewDecoder(resp.Body).Decode(&task); err != nil {
-		c.logr.Error("Failed to decode task: %v", err)
-		return Task{}, NewInvalidTaskError("invalid task data")
-	}
-	return task, nil
-}
-
-func (c *TaskClient) submitTaskResult(taskID int64, result float64, status string) error {
-	taskResult := TaskResult{ID: taskID, Result: result}
-	body, err := json.Marshal(taskResult)
-	if err != nil {
-		c.logr.Error("Failed to marshal task result: %v", err)
-		return NewTaskSubmitError()
-	}
-
-	resp, err := c.client.Post(c.addr+"/api/v1/task/result", "application/json", bytes.NewBuffer(body))
-	if err != nil {
-		c.logr.Error("Failed to submit task result to %s: %v", c.addr, err)
-		return NewTaskSubmitError()
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		c.logr.Error("Unexpected status code: %d", resp.StatusCode)
-		return NewTaskSubmitError()
-	}
-	return nil
-}
+package tasks
+
+import (
+	"DistributedCalc/internal/calculator"
+	"DistributedCalc/internal/numeric"
+	"DistributedCalc/pkg/logger"
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Task is handed to HTTP agents. Operands holds the operands in text form,
+// to be computed in Precision; Args approximates them for older agents.
+type Task struct {
+	ID             int64             `json:"id"`
+	ExpressionID   int64             `json:"expression_id"`
+	Arg1           float64           `json:"arg1"`
+	Arg2           float64           `json:"arg2"`
+	Args           []float64         `json:"args"`
+	Operands       []string          `json:"operands,omitempty"`
+	Precision      numeric.Precision `json:"precision"`
+	Operation      string            `json:"operation"`
+	OperationTime  int               `json:"operation_time"`
+	LeaseExpiresAt time.Time         `json:"lease_expires_at"`
+}
+
+func (t *Task) ToResponse() map[string]interface{} {
+	return map[string]interface{}{
+		"id":               t.ID,
+		"expression_id":    t.ExpressionID,
+		"arg1":             t.Arg1,
+		"arg2":             t.Arg2,
+		"args":             t.Args,
+		"operands":         t.Operands,
+		"precision":        t.Precision,
+		"operation":        t.Operation,
+		"operation_time":   t.OperationTime,
+		"lease_expires_at": t.LeaseExpiresAt,
+	}
+}
+
+// TaskResult is accepted only from the agent that holds the task's lease.
+// Value is the result in text form; agents that leave it out are taken to
+// have computed Result in float64.
+type TaskResult struct {
+	ID      int64   `json:"id"`
+	AgentID string  `json:"agent_id"`
+	Result  float64 `json:"result"`
+	Value   string  `json:"value,omitempty"`
+	Error   string  `json:"error,omitempty"`
+}
+
+type TaskClient struct {
+	addr    string
+	agentID string
+	client  *http.Client
+	logr    *logger.Logger
+}
+
+func NewTaskClient(addr, agentID string, logr *logger.Logger) *TaskClient {
+	return &TaskClient{
+		addr:    addr,
+		agentID: agentID,
+		client:  &http.Client{Timeout: 10 * time.Second},
+		logr:    logr,
+	}
+}
+
+func (c *TaskClient) RunWorker(ctx context.Context, calc *calculator.Calculator) {
+	for {
+		select {
+		case <-ctx.Done():
+			c.logr.Info("Task worker stopped")
+			return
+		default:
+			task, err := c.fetchTask()
+			if err != nil {
+				if err.Error() == NewTaskNotFoundError().Error() {
+					time.Sleep(100 * time.Millisecond)
+					continue
+				}
+				c.logr.Error("Failed to fetch task: %v", err)
+				time.Sleep(time.Second)
+				continue
+			}
+
+			operands := task.Operands
+			if len(operands) == 0 {
+				for _, arg := range task.Args {
+					operands = append(operands, numeric.FormatFloat(arg))
+				}
+			}
+			value, err := calc.ComputeExact(ctx, task.Operation, operands, task.Precision)
+			if err != nil {
+				c.logr.Error("Failed to compute task %d: %v", task.ID, err)
+				c.submitTaskResult(task.ID, "", err.Error())
+				continue
+			}
+
+			if err := c.submitTaskResult(task.ID, value, ""); err != nil {
+				c.logr.Error("Failed to submit task %d result: %v", task.ID, err)
+			}
+		}
+	}
+}
+
+func (c *TaskClient) fetchTask() (Task, error) {
+	resp, err := c.client.Get(c.addr + "/api/v1/task?agent_id=" + url.QueryEscape(c.agentID))
+	if err != nil {
+		c.logr.Error("Failed to fetch task from %s: %v", c.addr, err)
+		return Task{}, NewTaskFetchError(err.Error())
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return Task{}, NewTaskNotFoundError()
+	}
+	if resp.StatusCode != http.StatusOK {
+		c.logr.Error("Unexpected status code: %d", resp.StatusCode)
+		return Task{}, NewTaskFetchError("unexpected response status")
+	}
+
+	var body struct {
+		Task Task `json:"task"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		c.logr.Error("Failed to decode task: %v", err)
+		return Task{}, NewInvalidTaskError("invalid task data")
+	}
+	return body.Task, nil
+}
+
+func (c *TaskClient) submitTaskResult(taskID int64, value string, calcErr string) error {
+	taskResult := TaskResult{ID: taskID, AgentID: c.agentID, Result: numeric.Float(value), Value: value, Error: calcErr}
+	body, err := json.Marshal(taskResult)
+	if err != nil {
+		c.logr.Error("Failed to marshal task result: %v", err)
+		return NewTaskSubmitError()
+	}
+
+	resp, err := c.client.Post(c.addr+"/api/v1/task/result", "application/json", bytes.NewBuffer(body))
+	if err != nil {
+		c.logr.Error("Failed to submit task result to %s: %v", c.addr, err)
+		return NewTaskSubmitError()
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		c.logr.Error("Unexpected status code: %d", resp.StatusCode)
+		return NewTaskSubmitError()
+	}
+	return nil
+}
@@ -1,85 +1,175 @@
-package tasks
-
-import (
-	"DistributedCalc/internal/storage"
-	"DistributedCalc/pkg/logger"
-	"bytes"
-	"encoding/json"
-	"net/http"
-	"net/http/httptest"
-	"testing"
-)
-
-func TestTaskService_GetTaskHandler(t *testing.T) {
-	logr := logger.NewLogger()
-	dbConn, err := storage.NewSQLiteDB(":memory:", logr)
-	if err != nil {
-		t.Fatalf("Failed to create DB: %v", err)
-	}
-	defer dbConn.Close()
-
-	taskService := NewTaskService(dbConn, logr)
-
-	dbConn.CreateUser("testuser", "hashedpassword")
-	exprID, err := dbConn.SaveExpression("testuser", "2+2", "pending")
-	if err != nil {
-		t.Fatalf("Failed to save expression: %v", err)
-	}
-
-	taskID, err := dbConn.SaveTask(exprID, 2, 2, "+", 100)
-	if err != nil {
-		t.Fatalf("Failed to save task: %v", err)
-	}
-
-	req := httptest.NewRequest("GET", "/internal/task", nil)
-	rr := httptest.NewRecorder()
-	taskService.GetTaskHandler(rr, req)
-
-	if rr.Code != http.StatusOK {
-		t.Errorf("Expected status %d, got %d", http.StatusOK, rr.Code)
-	}
-
-	var resp map[string]interface{}
-	if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
-		t.Fatalf("Failed to decode response: %v", err)
-	}
-
-	taskResp, ok := resp["task"].(map[string]interface{})
-	if !ok {
-		t.Fatal("Expected task in response")
-	}
-	if taskResp["id"].(float64) != float64(taskID) {
-		t.Errorf("Expected task ID %d, got %v", taskID, taskResp["id"])
-	}
-}
-
-func TestTaskService_SubmitTaskResult(t *testing.T) {
-	logr := logger.NewLogger()
-	dbConn, err := storage.NewSQLiteDB(":memory:", logr)
-	if err != nil {
-		t.Fatalf("Failed to create DB: %v", err)
-	}
-	defer dbConn.Close()
-
-	taskService := NewTaskService(dbConn, logr)
-
-	dbConn.CreateUser("testuser", "hashedpassword")
-	exprID, err := dbConn.SaveExpression("testuser", "2+2", "pending")
-	if err != nil {
-		t.Fatalf("Failed to save expression: %v", err)
-	}
-
-	taskID, err := dbConn.SaveTask(exprID, 2, 2, "+", 100)
-	if err != nil {
-		t.Fatalf("Failed to save task: %v", err)
-	}
-
-	resultBody := `{"id": ` + string(rune(taskID)) + `, "result": 4}`
-	req := httptest.NewRequest("POST", "/internal/task", bytes.NewBufferString(resultBody))
-	rr := httptest.NewRecorder()
-	taskService.GetTaskHandler(rr, req)
-
-	if rr.Code != http.StatusOK {
-		t.Errorf("Expected status %d, got %d", http.StatusOK, rr.Code)
-	}
-}
+package tasks
+
+import (
+	"DistributedCalc/internal/calculator"
+	"DistributedCalc/internal/numeric"
+	"DistributedCalc/internal/storage"
+	"DistributedCalc/pkg/logger"
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestTaskService_GetTaskHandler(t *testing.T) {
+	logr := logger.NewLogger()
+	dbConn, err := storage.NewSQLiteDB(":memory:", logr)
+	if err != nil {
+		t.Fatalf("Failed to create DB: %v", err)
+	}
+	defer dbConn.Close()
+
+	taskService := NewTaskService(dbConn, logr)
+
+	userID, _ := dbConn.CreateUser("testuser", "hashedpassword")
+	exprID, err := dbConn.SaveExpression(userID, "2+2", nil, time.Time{}, "")
+	if err != nil {
+		t.Fatalf("Failed to save expression: %v", err)
+	}
+
+	taskID, err := dbConn.SaveTask(exprID, 0, []string{"2", "2"}, numeric.Precision{}, "+", 100)
+	if err != nil {
+		t.Fatalf("Failed to save task: %v", err)
+	}
+
+	req := httptest.NewRequest("GET", "/internal/task?agent_id=agent-1", nil)
+	rr := httptest.NewRecorder()
+	taskService.GetTaskHandler(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("Expected status %d, got %d", http.StatusOK, rr.Code)
+	}
+
+	var resp map[string]interface{}
+	if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+
+	taskResp, ok := resp["task"].(map[string]interface{})
+	if !ok {
+		t.Fatal("Expected task in response")
+	}
+	if taskResp["id"].(float64) != float64(taskID) {
+		t.Errorf("Expected task ID %d, got %v", taskID, taskResp["id"])
+	}
+
+	// The task is leased to agent-1 and is not handed out again.
+	rr = httptest.NewRecorder()
+	taskService.GetTaskHandler(rr, httptest.NewRequest("GET", "/internal/task?agent_id=agent-2", nil))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("Expected status %d, got %d", http.StatusNotFound, rr.Code)
+	}
+
+	rr = httptest.NewRecorder()
+	taskService.GetTaskHandler(rr, httptest.NewRequest("GET", "/internal/task", nil))
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status %d without agent_id, got %d", http.StatusBadRequest, rr.Code)
+	}
+}
+
+func TestTaskService_SubmitTaskResult(t *testing.T) {
+	logr := logger.NewLogger()
+	dbConn, err := storage.NewSQLiteDB(":memory:", logr)
+	if err != nil {
+		t.Fatalf("Failed to create DB: %v", err)
+	}
+	defer dbConn.Close()
+
+	taskService := NewTaskService(dbConn, logr)
+
+	userID, _ := dbConn.CreateUser("testuser", "hashedpassword")
+	exprID, err := dbConn.SaveExpression(userID, "2+2", nil, time.Time{}, "")
+	if err != nil {
+		t.Fatalf("Failed to save expression: %v", err)
+	}
+
+	taskID, err := dbConn.SaveTask(exprID, 0, []string{"2", "2"}, numeric.Precision{}, "+", 100)
+	if err != nil {
+		t.Fatalf("Failed to save task: %v", err)
+	}
+
+	if _, err := dbConn.ClaimTask("agent-1", time.Minute); err != nil {
+		t.Fatalf("Failed to claim task: %v", err)
+	}
+
+	// Only the agent holding the lease may submit the result.
+	resultBody := `{"id": ` + strconv.FormatInt(taskID, 10) + `, "agent_id": "agent-2", "result": 0}`
+	req := httptest.NewRequest("POST", "/internal/task/result", bytes.NewBufferString(resultBody))
+	rr := httptest.NewRecorder()
+	taskService.SubmitTaskResultHandler(rr, req)
+	if rr.Code != http.StatusConflict {
+		t.Errorf("Expected status %d, got %d", http.StatusConflict, rr.Code)
+	}
+
+	resultBody = `{"id": ` + strconv.FormatInt(taskID, 10) + `, "agent_id": "agent-1", "result": 0}`
+	req = httptest.NewRequest("POST", "/internal/task/result", bytes.NewBufferString(resultBody))
+	rr = httptest.NewRecorder()
+	taskService.SubmitTaskResultHandler(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Errorf("Expected status %d, got %d", http.StatusOK, rr.Code)
+	}
+
+	// A zero result is a valid answer and must complete the task.
+	if err := dbConn.CompleteTask(taskID, "agent-1", "0", "completed", ""); err == nil {
+		t.Error("Expected task to be completed")
+	}
+
+	// The error of an agent is kept with the task.
+	failedID, _ := dbConn.SaveTask(exprID, 0, []string{"1", "0"}, numeric.Precision{}, "/", 100)
+	dbConn.ClaimTask("agent-1", time.Minute)
+	resultBody = `{"id": ` + strconv.FormatInt(failedID, 10) + `, "agent_id": "agent-1", "error": "division by zero"}`
+	rr = httptest.NewRecorder()
+	taskService.SubmitTaskResultHandler(rr, httptest.NewRequest("POST", "/internal/task/result", bytes.NewBufferString(resultBody)))
+	if task, _ := dbConn.GetTask(failedID); rr.Code != http.StatusOK || task.Status != "error" || task.Error != "division by zero" {
+		t.Errorf("Expected the task to fail with the error of the agent, got %d %+v", rr.Code, task)
+	}
+}
+
+func TestTaskClient_RunWorker(t *testing.T) {
+	logr := logger.NewLogger()
+	dbConn, err := storage.NewSQLiteDB(":memory:", logr)
+	if err != nil {
+		t.Fatalf("Failed to create DB: %v", err)
+	}
+	defer dbConn.Close()
+
+	taskService := NewTaskService(dbConn, logr)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/task", taskService.GetTaskHandler)
+	mux.HandleFunc("/api/v1/task/result", taskService.SubmitTaskResultHandler)
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	userID, _ := dbConn.CreateUser("testuser", "hashedpassword")
+	exprID, _ := dbConn.SaveExpression(userID, "0.1+0.2", nil, time.Time{}, "")
+	taskID, err := dbConn.SaveTask(exprID, 0, []string{"0.1", "0.2"}, numeric.Precision{Mode: numeric.ModeDecimal, Scale: 2, Rounding: numeric.DefaultRounding}, "+", 0)
+	if err != nil {
+		t.Fatalf("Failed to save task: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go NewTaskClient(server.URL, "agent-1", logr).RunWorker(ctx, calculator.NewCalculator())
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		task, err := dbConn.GetTask(taskID)
+		if err != nil {
+			t.Fatalf("Failed to get task: %v", err)
+		}
+		if task.Status == "completed" {
+			if task.Value != "0.3" || task.Agent != "agent-1" {
+				t.Errorf("Expected agent-1 to compute 0.3, got %+v", task)
+			}
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Task is still %s", task.Status)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
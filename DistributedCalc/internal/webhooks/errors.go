@@ -0,0 +1,15 @@
+package webhooks
+
+import (
+	"DistributedCalc/pkg/errors"
+	"fmt"
+	"net/http"
+)
+
+func NewUnexpectedStatusError(status int) *errors.AppError {
+	return &errors.AppError{Code: http.StatusBadGateway, Message: fmt.Sprintf("callback responded with status %d", status)}
+}
+
+func NewPrivateAddressError(host string) *errors.AppError {
+	return &errors.AppError{Code: http.StatusForbidden, Message: fmt.Sprintf("callback address %s is not public", host)}
+}
@@ -0,0 +1,201 @@
+package webhooks
+
+import (
+	"DistributedCalc/internal/events"
+	"DistributedCalc/internal/storage"
+	"DistributedCalc/pkg/errors"
+	"DistributedCalc/pkg/logger"
+	"DistributedCalc/pkg/retry"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	stderrors "errors"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// SignatureHeader carries the HMAC-SHA256 of the request body, keyed with
+// the shared secret, as "sha256=<hex>".
+const SignatureHeader = "X-Calc-Signature"
+
+const requestTimeout = 10 * time.Second
+
+// Payload is the body posted to the callback URL of an expression.
+type Payload struct {
+	ID         int64   `json:"id"`
+	Expression string  `json:"expression"`
+	Result     float64 `json:"result"`
+	Imag       float64 `json:"imag,omitempty"`
+	Value      string  `json:"value,omitempty"`
+	Status     string  `json:"status"`
+	Error      string  `json:"error,omitempty"`
+}
+
+func DefaultRetryPolicy() retry.Policy {
+	return retry.Policy{
+		MaxAttempts:    5,
+		InitialBackoff: time.Second,
+		MaxBackoff:     time.Minute,
+		Jitter:         0.2,
+	}
+}
+
+// Notifier posts the outcome of expressions that finished, failed or timed
+// out to their callback URL. It receives the events published by storage;
+// delivery happens in the background and every attempt is recorded.
+type Notifier struct {
+	db     *storage.SQLiteDB
+	secret []byte
+	client *http.Client
+	retry  retry.Policy
+	wg     sync.WaitGroup
+	logr   *logger.Logger
+}
+
+// NewNotifier returns a notifier that signs callbacks with secret, which
+// must not be empty. Callbacks are only sent to public addresses.
+func NewNotifier(db *storage.SQLiteDB, secret []byte, logr *logger.Logger) *Notifier {
+	dialer := &net.Dialer{Timeout: requestTimeout, Control: dialPublic}
+	return &Notifier{
+		db:     db,
+		secret: secret,
+		client: &http.Client{Timeout: requestTimeout, Transport: &http.Transport{DialContext: dialer.DialContext}},
+		retry:  DefaultRetryPolicy(),
+		logr:   logr,
+	}
+}
+
+// SetRetryPolicy sets how often and how fast failed deliveries are retried.
+// Network errors, 429 and 5xx responses are retried; other responses and
+// addresses that are not public are final.
+func (n *Notifier) SetRetryPolicy(policy retry.Policy) {
+	n.retry = policy
+}
+
+// Publish starts delivering the outcome of an expression once it reaches
+// one of the final states that are reported.
+func (n *Notifier) Publish(event events.Event) {
+	if event.Type != events.TypeExpression {
+		return
+	}
+	switch event.Status {
+	case storage.ExpressionCompleted, storage.ExpressionError, storage.ExpressionTimeout:
+	default:
+		return
+	}
+	n.wg.Add(1)
+	go func() {
+		defer n.wg.Done()
+		n.deliver(event.ExpressionID, event.UserID)
+	}()
+}
+
+// Wait blocks until every delivery that has started is finished.
+func (n *Notifier) Wait() {
+	n.wg.Wait()
+}
+
+func (n *Notifier) deliver(exprID, userID int64) {
+	expr, err := n.db.GetExpression(exprID, userID)
+	if err != nil {
+		n.logr.Error("Failed to load expression %d for its callback: %v", exprID, err)
+		return
+	}
+	if expr.CallbackURL == "" {
+		return
+	}
+	body, err := json.Marshal(Payload{ID: expr.ID, Expression: expr.Expression, Result: expr.Result, Imag: expr.Imag, Value: expr.Value, Status: expr.Status, Error: expr.Error})
+	if err != nil {
+		n.logr.Error("Failed to encode callback of expression %d: %v", exprID, err)
+		return
+	}
+
+	for attempt := 1; attempt <= n.retry.MaxAttempts; attempt++ {
+		if attempt > 1 {
+			time.Sleep(n.retry.Backoff(attempt - 1))
+		}
+		record := storage.WebhookAttempt{ExpressionID: exprID, Attempt: attempt, URL: expr.CallbackURL, SentAt: time.Now()}
+		record.StatusCode, err = n.post(expr.CallbackURL, body)
+		if err == nil && record.StatusCode >= 300 {
+			err = NewUnexpectedStatusError(record.StatusCode)
+		}
+		if err != nil {
+			record.Error = err.Error()
+		}
+		n.db.RecordWebhookAttempt(record)
+
+		if err == nil {
+			n.logr.Info("Delivered callback of expression %d to %s", exprID, expr.CallbackURL)
+			return
+		}
+		n.logr.Error("Callback of expression %d failed (attempt %d): %v", exprID, attempt, err)
+		if record.StatusCode != 0 && record.StatusCode != http.StatusTooManyRequests && record.StatusCode < 500 {
+			return
+		}
+		// Without a response, only an address refused by dialPublic is an
+		// AppError.
+		var refused *errors.AppError
+		if record.StatusCode == 0 && stderrors.As(err, &refused) {
+			return
+		}
+	}
+	n.logr.Error("Giving up on the callback of expression %d after %d attempts", exprID, n.retry.MaxAttempts)
+}
+
+func (n *Notifier) post(url string, body []byte) (int, error) {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(SignatureHeader, Sign(n.secret, body))
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	return resp.StatusCode, nil
+}
+
+// IsPublicHost reports whether host, the host of a callback URL, may be a
+// public address. Loopback, private and link-local addresses are not, and
+// neither is localhost; other names are checked once they are resolved.
+func IsPublicHost(host string) bool {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return false
+	}
+	ip := net.ParseIP(host)
+	return ip == nil || isPublicIP(ip)
+}
+
+func isPublicIP(ip net.IP) bool {
+	return !(ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsUnspecified() || ip.IsMulticast())
+}
+
+// dialPublic refuses connections to addresses that are not public, whatever
+// name they were resolved from.
+func dialPublic(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || !isPublicIP(ip) {
+		return NewPrivateAddressError(host)
+	}
+	return nil
+}
+
+// Sign returns the value of SignatureHeader for body. Receivers compute it
+// from the raw body and compare it with hmac.Equal.
+func Sign(secret, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,168 @@
+package webhooks
+
+import (
+	"DistributedCalc/internal/storage"
+	"DistributedCalc/pkg/logger"
+	"crypto/hmac"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestNotifier(t *testing.T) (*storage.SQLiteDB, *Notifier) {
+	logr := logger.NewLogger()
+	dbConn, err := storage.NewSQLiteDB(":memory:", logr)
+	if err != nil {
+		t.Fatalf("Failed to create DB: %v", err)
+	}
+	t.Cleanup(dbConn.Close)
+
+	notifier := NewNotifier(dbConn, []byte("secret"), logr)
+	// The receivers of the tests listen on loopback.
+	notifier.client = &http.Client{Timeout: requestTimeout}
+	policy := DefaultRetryPolicy()
+	policy.InitialBackoff, policy.MaxBackoff, policy.MaxAttempts = time.Millisecond, time.Millisecond, 3
+	notifier.SetRetryPolicy(policy)
+	dbConn.SetPublisher(notifier)
+	return dbConn, notifier
+}
+
+func TestNotifier(t *testing.T) {
+	dbConn, notifier := newTestNotifier(t)
+
+	var mu sync.Mutex
+	var received []Payload
+	calls := 0
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		calls++
+		if calls == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		if !hmac.Equal([]byte(r.Header.Get(SignatureHeader)), []byte(Sign([]byte("secret"), body))) {
+			t.Errorf("Invalid signature %q", r.Header.Get(SignatureHeader))
+		}
+		var payload Payload
+		json.Unmarshal(body, &payload)
+		received = append(received, payload)
+	}))
+	defer receiver.Close()
+
+	userID, _ := dbConn.CreateUser("testuser", "hashedpassword")
+	exprID, _ := dbConn.SaveExpression(userID, "2*3", nil, time.Time{}, receiver.URL)
+	dbConn.ClaimExpression(exprID, "orch-1", time.Minute)
+	dbConn.CompleteExpression(exprID, "orch-1", "6")
+	notifier.Wait()
+
+	if len(received) != 1 || received[0] != (Payload{ID: exprID, Expression: "2*3", Result: 6, Value: "6", Status: storage.ExpressionCompleted}) {
+		t.Errorf("Expected one delivery of the result, got %+v", received)
+	}
+	attempts, _ := dbConn.GetWebhookAttempts(exprID)
+	if len(attempts) != 2 {
+		t.Fatalf("Expected 2 recorded attempts, got %+v", attempts)
+	}
+	if attempts[0].StatusCode != http.StatusServiceUnavailable || attempts[0].Error == "" {
+		t.Errorf("Expected the first attempt to fail with 503, got %+v", attempts[0])
+	}
+	if attempts[1].StatusCode != http.StatusOK || attempts[1].Error != "" || attempts[1].URL != receiver.URL {
+		t.Errorf("Expected the second attempt to succeed, got %+v", attempts[1])
+	}
+}
+
+func TestNotifier_Failures(t *testing.T) {
+	dbConn, notifier := newTestNotifier(t)
+
+	var mu sync.Mutex
+	var statuses []string
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload Payload
+		json.NewDecoder(r.Body).Decode(&payload)
+		mu.Lock()
+		statuses = append(statuses, payload.Status)
+		mu.Unlock()
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer receiver.Close()
+
+	userID, _ := dbConn.CreateUser("testuser", "hashedpassword")
+
+	// A failed expression is reported, but a 400 is not retried.
+	failedID, _ := dbConn.SaveExpression(userID, "1/0", nil, time.Time{}, receiver.URL)
+	dbConn.ClaimExpression(failedID, "orch-1", time.Minute)
+	dbConn.FailExpression(failedID, "orch-1", "division by zero")
+
+	// Cancelled expressions and ones without a callback are not reported.
+	cancelledID, _ := dbConn.SaveExpression(userID, "1+1", nil, time.Time{}, receiver.URL)
+	dbConn.CancelExpression(cancelledID, userID)
+	silentID, _ := dbConn.SaveExpression(userID, "2+2", nil, time.Time{}, "")
+	dbConn.ClaimExpression(silentID, "orch-1", time.Minute)
+	dbConn.CompleteExpression(silentID, "orch-1", "4")
+	notifier.Wait()
+
+	if len(statuses) != 1 || statuses[0] != storage.ExpressionError {
+		t.Errorf("Expected only the failure to be delivered, got %v", statuses)
+	}
+	if attempts, _ := dbConn.GetWebhookAttempts(failedID); len(attempts) != 1 || attempts[0].StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected a single attempt, got %+v", attempts)
+	}
+	for _, id := range []int64{cancelledID, silentID} {
+		if attempts, _ := dbConn.GetWebhookAttempts(id); len(attempts) != 0 {
+			t.Errorf("Expected no attempts for expression %d, got %+v", id, attempts)
+		}
+	}
+}
+
+func TestNotifier_PrivateAddress(t *testing.T) {
+	dbConn, notifier := newTestNotifier(t)
+	notifier.client = NewNotifier(dbConn, []byte("secret"), notifier.logr).client
+
+	called := false
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+	defer receiver.Close()
+
+	userID, _ := dbConn.CreateUser("testuser", "hashedpassword")
+	exprID, _ := dbConn.SaveExpression(userID, "2*3", nil, time.Time{}, receiver.URL)
+	dbConn.ClaimExpression(exprID, "orch-1", time.Minute)
+	dbConn.CompleteExpression(exprID, "orch-1", "6")
+	notifier.Wait()
+
+	if called {
+		t.Error("Expected no callback to a loopback address")
+	}
+	attempts, _ := dbConn.GetWebhookAttempts(exprID)
+	if len(attempts) != 1 || !strings.Contains(attempts[0].Error, NewPrivateAddressError("127.0.0.1").Error()) {
+		t.Errorf("Expected a single refused attempt, got %+v", attempts)
+	}
+}
+
+func TestIsPublicHost(t *testing.T) {
+	tests := map[string]bool{
+		"example.com":     true,
+		"93.184.216.34":   true,
+		"2606:2800::1":    true,
+		"localhost":       false,
+		"api.localhost":   false,
+		"127.0.0.1":       false,
+		"10.0.0.7":        false,
+		"192.168.1.1":     false,
+		"169.254.169.254": false,
+		"0.0.0.0":         false,
+		"::1":             false,
+		"fd00::1":         false,
+	}
+	for host, public := range tests {
+		if got := IsPublicHost(host); got != public {
+			t.Errorf("Expected IsPublicHost(%q) to be %v", host, public)
+		}
+	}
+}
@@ -1,38 +1,41 @@
-package errors
-
-import (
-	"encoding/json"
-	"net/http"
-)
-
-type AppError struct {
-	Code    int    `json:"code"`
-	Message string `json:"message"`
-}
-
-func (e *AppError) Error() string {
-	return e.Message
-}
-
-func NewBadRequestError(msg string) *AppError {
-	return &AppError{Code: http.StatusBadRequest, Message: msg}
-}
-
-func NewNotFoundError(msg string) *AppError {
-	return &AppError{Code: http.StatusNotFound, Message: msg}
-}
-
-func NewInternalError(msg string) *AppError {
-	return &AppError{Code: http.StatusInternalServerError, Message: msg}
-}
-
-func HandleHTTPError(w http.ResponseWriter, err error) {
-	w.Header().Set("Content-Type", "application/json")
-	if appErr, ok := err.(*AppError); ok {
-		w.WriteHeader(appErr.Code)
-		json.NewEncoder(w).Encode(appErr)
-		return
-	}
-	w.WriteHeader(http.StatusInternalServerError)
-	json.NewEncoder(w).Encode(&AppError{Code: http.StatusInternalServerError, Message: "internal server error"})
-}
+package errors
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+type AppError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	// Details optionally carries structured information about the error,
+	// such as where an expression fails to parse.
+	Details any `json:"details,omitempty"`
+}
+
+func (e *AppError) Error() string {
+	return e.Message
+}
+
+func NewBadRequestError(msg string) *AppError {
+	return &AppError{Code: http.StatusBadRequest, Message: msg}
+}
+
+func NewNotFoundError(msg string) *AppError {
+	return &AppError{Code: http.StatusNotFound, Message: msg}
+}
+
+func NewInternalError(msg string) *AppError {
+	return &AppError{Code: http.StatusInternalServerError, Message: msg}
+}
+
+func HandleHTTPError(w http.ResponseWriter, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	if appErr, ok := err.(*AppError); ok {
+		w.WriteHeader(appErr.Code)
+		json.NewEncoder(w).Encode(appErr)
+		return
+	}
+	w.WriteHeader(http.StatusInternalServerError)
+	json.NewEncoder(w).Encode(&AppError{Code: http.StatusInternalServerError, Message: "internal server error"})
+}
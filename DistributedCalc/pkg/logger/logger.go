@@ -1,28 +1,28 @@
-package logger
-
-import (
-	"log"
-	"os"
-)
-
-type Logger struct {
-	logger *log.Logger
-}
-
-func NewLogger() *Logger {
-	return &Logger{
-		logger: log.New(os.Stdout, "DistributedCalc: ", log.LstdFlags|log.Lshortfile),
-	}
-}
-
-func (l *Logger) Info(format string, v ...interface{}) {
-	l.logger.Printf("[INFO] "+format, v...)
-}
-
-func (l *Logger) Error(format string, v ...interface{}) {
-	l.logger.Printf("[ERROR] "+format, v...)
-}
-
-func (l *Logger) Debug(format string, v ...interface{}) {
-	l.logger.Printf("[DEBUG] "+format, v...)
-}
+package logger
+
+import (
+	"log"
+	"os"
+)
+
+type Logger struct {
+	logger *log.Logger
+}
+
+func NewLogger() *Logger {
+	return &Logger{
+		logger: log.New(os.Stdout, "DistributedCalc: ", log.LstdFlags|log.Lshortfile),
+	}
+}
+
+func (l *Logger) Info(format string, v ...interface{}) {
+	l.logger.Printf("[INFO] "+format, v...)
+}
+
+func (l *Logger) Error(format string, v ...interface{}) {
+	l.logger.Printf("[ERROR] "+format, v...)
+}
+
+func (l *Logger) Debug(format string, v ...interface{}) {
+	l.logger.Printf("[DEBUG] "+format, v...)
+}
@@ -0,0 +1,30 @@
+package retry
+
+import (
+	"math/rand"
+	"time"
+)
+
+// Policy decides how often and how fast an operation is retried after a
+// failure that may go away by itself, such as a lost connection.
+type Policy struct {
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	// Jitter is the fraction of each backoff, between 0 and 1, that is
+	// randomly taken off so that many retries spread out.
+	Jitter float64
+}
+
+// Backoff is the delay before the given retry, counting from 1.
+func (p Policy) Backoff(retry int) time.Duration {
+	backoff := p.InitialBackoff
+	for i := 1; i < retry && backoff < p.MaxBackoff; i++ {
+		backoff *= 2
+	}
+	backoff = min(backoff, p.MaxBackoff)
+	if p.Jitter > 0 {
+		backoff -= time.Duration(rand.Float64() * p.Jitter * float64(backoff))
+	}
+	return backoff
+}
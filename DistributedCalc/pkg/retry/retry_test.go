@@ -0,0 +1,23 @@
+package retry
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPolicy_Backoff(t *testing.T) {
+	policy := Policy{MaxAttempts: 5, InitialBackoff: 100 * time.Millisecond, MaxBackoff: 300 * time.Millisecond}
+	expected := []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 300 * time.Millisecond, 300 * time.Millisecond}
+	for i, want := range expected {
+		if got := policy.Backoff(i + 1); got != want {
+			t.Errorf("Expected backoff %v before retry %d, got %v", want, i+1, got)
+		}
+	}
+
+	policy.Jitter = 0.5
+	for i := 0; i < 20; i++ {
+		if got := policy.Backoff(1); got < 50*time.Millisecond || got > 100*time.Millisecond {
+			t.Errorf("Expected jittered backoff between 50ms and 100ms, got %v", got)
+		}
+	}
+}
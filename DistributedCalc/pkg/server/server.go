@@ -1,45 +1,67 @@
-package server
-
-import (
-	"DistributedCalc/pkg/errors"
-	"DistributedCalc/pkg/logger"
-	"net/http"
-
-	"github.com/gorilla/mux"
-)
-
-type Server struct {
-	addr   string
-	router *mux.Router
-	logr   *logger.Logger
-}
-
-func NewServer(addr string, logr *logger.Logger) *Server {
-	return &Server{
-		addr:   addr,
-		router: mux.NewRouter(),
-		logr:   logr,
-	}
-}
-
-func (s *Server) AddRoute(path string, handler http.Handler, methods ...string) {
-	s.router.Handle(path, handler).Methods(methods...)
-	s.logr.Info("Added route: %s [%s]", path, methods)
-}
-
-func (s *Server) Run() error {
-	s.router.Use(s.loggingMiddleware)
-	s.logr.Info("Starting server on %s", s.addr)
-	if err := http.ListenAndServe(s.addr, s.router); err != nil {
-		s.logr.Error("Server failed to start: %v", err)
-		return errors.NewInternalError("server failed to start")
-	}
-	return nil
-}
-
-func (s *Server) loggingMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		s.logr.Info("Received %s request to %s", r.Method, r.URL.Path)
-		next.ServeHTTP(w, r)
-	})
-}
+package server
+
+import (
+	"DistributedCalc/pkg/errors"
+	"DistributedCalc/pkg/logger"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+)
+
+type Server struct {
+	addr     string
+	router   *mux.Router
+	upgrader websocket.Upgrader
+	logr     *logger.Logger
+}
+
+// WebSocketHandler serves one WebSocket connection. The connection is
+// closed when it returns.
+type WebSocketHandler func(conn *websocket.Conn, r *http.Request)
+
+func NewServer(addr string, logr *logger.Logger) *Server {
+	return &Server{
+		addr:   addr,
+		router: mux.NewRouter(),
+		logr:   logr,
+	}
+}
+
+func (s *Server) AddRoute(path string, handler http.Handler, methods ...string) {
+	s.router.Handle(path, handler).Methods(methods...)
+	s.logr.Info("Added route: %s [%s]", path, methods)
+}
+
+// WebSocket returns a handler that upgrades requests to WebSocket
+// connections and passes them to handler. Wrap it in middleware, such as
+// authentication, and register it with AddRoute for GET.
+func (s *Server) WebSocket(handler WebSocketHandler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := s.upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			// Upgrade has already replied with an error.
+			s.logr.Error("Failed to upgrade to WebSocket: %v", err)
+			return
+		}
+		defer conn.Close()
+		handler(conn, r)
+	})
+}
+
+func (s *Server) Run() error {
+	s.router.Use(s.loggingMiddleware)
+	s.logr.Info("Starting server on %s", s.addr)
+	if err := http.ListenAndServe(s.addr, s.router); err != nil {
+		s.logr.Error("Server failed to start: %v", err)
+		return errors.NewInternalError("server failed to start")
+	}
+	return nil
+}
+
+func (s *Server) loggingMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.logr.Info("Received %s request to %s", r.Method, r.URL.Path)
+		next.ServeHTTP(w, r)
+	})
+}